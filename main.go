@@ -2,14 +2,22 @@
 package main
 
 import (
+	"bytes"
+	"expvar"
 	"flag"
+	"fmt"
+	"net/http"
 	"strconv"
+	"time"
 
+	"gopkg.in/yaml.v3"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 	"k8s.io/klog/v2"
 
 	"cri-lite/pkg/config"
 	"cri-lite/pkg/policy"
 	"cri-lite/pkg/proxy"
+	"cri-lite/pkg/streaming"
 	"cri-lite/pkg/version"
 )
 
@@ -57,15 +65,34 @@ func main() {
 	klog.Infof("Using runtime endpoint: %s", cfg.RuntimeEndpoint)
 	klog.Infof("Using image endpoint: %s", cfg.ImageEndpoint)
 
+	// The endpoints share the budget, as they all call the same runtime, and
+	// each uses its own share of it.
+	var budget *proxy.UpstreamBudget
+
+	if b := cfg.UpstreamBudget; b != nil {
+		klog.Infof("Using an upstream budget of %g calls per second, bursts of %d and %d calls in flight", b.Rate, b.Burst, b.MaxInFlight)
+
+		budget = proxy.NewUpstreamBudget(b.Rate, b.Burst, b.MaxInFlight)
+	}
+
+	if cfg.DebugAddress != "" {
+		go startDebug(cfg.DebugAddress)
+	}
+
 	for _, endpoint := range cfg.Endpoints {
-		go startEndpoint(endpoint, cfg)
+		var share *proxy.UpstreamBudget
+		if budget != nil {
+			share = budget.Share(len(cfg.Endpoints))
+		}
+
+		go startEndpoint(endpoint, cfg, share)
 	}
 
 	// Keep the main goroutine alive.
 	select {}
 }
 
-func startEndpoint(endpoint config.Endpoint, cfg *config.Config) {
+func startEndpoint(endpoint config.Endpoint, cfg *config.Config, budget *proxy.UpstreamBudget) {
 	klog.Infof("Starting server for endpoint: %s", endpoint.Endpoint)
 
 	server, err := proxy.NewServer(cfg.RuntimeEndpoint, cfg.ImageEndpoint)
@@ -73,42 +100,343 @@ func startEndpoint(endpoint config.Endpoint, cfg *config.Config) {
 		klog.Fatalf("failed to create server for endpoint %s: %v", endpoint.Endpoint, err)
 	}
 
+	policyConfigs := endpoint.PolicyConfigs()
+	policies := newPolicies(endpoint.Endpoint, policyConfigs, server)
+
+	if endpoint.Mode == config.ModeAudit {
+		klog.Infof("Endpoint %s audits its policies without enforcing them", endpoint.Endpoint)
+
+		for i, p := range policies {
+			policies[i] = policy.NewAuditPolicy(endpoint.Endpoint, p)
+		}
+	}
+
+	// The rate limit goes before the policies added below too, as they call
+	// the runtime.
+	var rateLimit policy.Policy
+	if len(policyConfigs) > 0 && policyConfigs[0].Name == "RateLimit" && policyConfigs[0].File == "" {
+		rateLimit, policies = policies[0], policies[1:]
+	}
+
+	if len(endpoint.Shadow) > 0 {
+		klog.Infof("Endpoint %s evaluates shadow policies", endpoint.Endpoint)
+
+		shadow := newPolicies(endpoint.Endpoint, endpoint.Shadow, server)
+		policies = []policy.Policy{policy.NewShadowPolicy(policies, shadow)}
+	}
+
+	if len(endpoint.AllowedUIDs) > 0 || len(endpoint.AllowedGIDs) > 0 {
+		klog.Infof("Endpoint %s allows the callers with UIDs %v or GIDs %v", endpoint.Endpoint, endpoint.AllowedUIDs, endpoint.AllowedGIDs)
+
+		policies = append([]policy.Policy{policy.NewCallerIDPolicy(endpoint.AllowedUIDs, endpoint.AllowedGIDs)}, policies...)
+	}
+
+	if len(endpoint.AllowedExecutables) > 0 {
+		executables := make([]policy.Executable, 0, len(endpoint.AllowedExecutables))
+		for _, e := range endpoint.AllowedExecutables {
+			executables = append(executables, policy.Executable{Path: e.Path, SHA256: e.SHA256, Unit: e.Unit})
+		}
+
+		p, err := policy.NewExecutablePolicy(executables)
+		if err != nil {
+			klog.Fatalf("failed to create executable policy for endpoint %s: %v", endpoint.Endpoint, err)
+		}
+
+		klog.Infof("Endpoint %s allows the callers running %d executables", endpoint.Endpoint, len(executables))
+
+		policies = append([]policy.Policy{p}, policies...)
+	}
+
+	// The audit goes first after the rate limit, so that the calls denied by
+	// the other policies are recorded too.
+	if audit := endpoint.ExecSyncAudit; audit != nil {
+		p, err := policy.NewExecSyncAuditPolicy(policy.ExecSyncAudit{
+			Endpoint:         endpoint.Endpoint,
+			File:             audit.File,
+			MaxOutputBytes:   audit.MaxOutputBytes,
+			HashOnly:         audit.HashOnly,
+			OutputSampleRate: audit.OutputSampleRate,
+		})
+		if err != nil {
+			klog.Fatalf("failed to create exec sync audit for endpoint %s: %v", endpoint.Endpoint, err)
+		}
+
+		klog.Infof("Endpoint %s audits its ExecSync calls", endpoint.Endpoint)
+
+		policies = append([]policy.Policy{p}, policies...)
+	}
+
+	if rateLimit != nil {
+		policies = append([]policy.Policy{rateLimit}, policies...)
+	}
+
+	server.SetPolicies(policies...)
+
+	if budget != nil {
+		server.SetUpstreamBudget(budget)
+	}
+
+	if endpoint.Streaming != nil {
+		startStreaming(endpoint, server)
+	}
+
+	err = server.Start(endpoint.Endpoint)
+	if err != nil {
+		klog.Fatalf("failed to start server for endpoint %s: %v", endpoint.Endpoint, err)
+	}
+}
+
+// startStreaming starts the streaming server of an endpoint, and makes the
+// endpoint hand out its URLs.
+// startDebug serves the counters published with expvar, such as the denials
+// of audited policies, at /debug/vars.
+func startDebug(address string) {
+	klog.Infof("Serving counters at http://%s/debug/vars", address)
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	err := server.ListenAndServe()
+	if err != nil {
+		klog.Fatalf("failed to serve counters at %s: %v", address, err)
+	}
+}
+
+func startStreaming(endpoint config.Endpoint, server *proxy.Server) {
+	streamingServer, err := streaming.NewServer(streaming.Config{
+		Address:            endpoint.Streaming.Address,
+		BaseURL:            endpoint.Streaming.URL,
+		TokenTTL:           time.Duration(endpoint.Streaming.TokenTTLSeconds) * time.Second,
+		MaxSessionDuration: time.Duration(endpoint.Streaming.MaxSessionSeconds) * time.Second,
+		AllowTTY:           endpoint.Streaming.AllowTTY,
+		AllowStdin:         endpoint.Streaming.AllowStdin,
+	})
+	if err != nil {
+		klog.Fatalf("failed to create streaming server for endpoint %s: %v", endpoint.Endpoint, err)
+	}
+
+	server.SetStreamingServer(streamingServer)
+
+	go func() {
+		err := streamingServer.Start()
+		if err != nil {
+			klog.Fatalf("failed to start streaming server for endpoint %s: %v", endpoint.Endpoint, err)
+		}
+	}()
+}
+
+func newPolicies(endpoint string, policyConfigs []config.PolicyConfig, server *proxy.Server) []policy.Policy {
+	policies := make([]policy.Policy, 0, len(policyConfigs))
+
+	for _, policyConfig := range policyConfigs {
+		var p policy.Policy
+
+		if policyConfig.File != "" {
+			var err error
+
+			p, err = newFilePolicy(policyConfig, server.GetRuntimeClient())
+			if err != nil {
+				klog.Fatalf("failed to load policy file for endpoint %s: %v", endpoint, err)
+			}
+		} else {
+			p = newBuiltinPolicy(endpoint, policyConfig, server.GetRuntimeClient(), server.GetImageClient())
+		}
+
+		if policyConfig.AuthorizeFromAnnotation {
+			name := policyConfig.Name
+			if name == "" {
+				name = p.Name()
+			}
+
+			klog.Infof("Endpoint %s requires pods to be annotated with %s=%s", endpoint, policy.PolicyAnnotation, name)
+			p = policy.NewAnnotationAuthorizedPolicy(name, p, server.GetRuntimeClient())
+		}
+
+		policies = append(policies, p)
+	}
+
+	return policies
+}
+
+func newFilePolicy(policyConfig config.PolicyConfig, runtimeClient runtimeapi.RuntimeServiceClient) (policy.Policy, error) {
+	policyFile, err := policy.LoadConfig(policyConfig.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load policy file %q: %w", policyConfig.File, err)
+	}
+
+	if policyConfig.Name != "" {
+		policyFile.Name = policyConfig.Name
+	}
+
+	return policy.NewFromConfigData(policyFile, runtimeClient)
+}
+
+func newBuiltinPolicy(
+	endpoint string,
+	policyConfig config.PolicyConfig,
+	runtimeClient runtimeapi.RuntimeServiceClient,
+	imageClient runtimeapi.ImageServiceClient,
+) policy.Policy {
 	var p policy.Policy
 
-	switch endpoint.Policy.Name {
+	switch policyConfig.Name {
 	case "ReadOnly":
 		p = policy.NewReadOnlyPolicy()
 	case "ImageManagement":
-		p = policy.NewImageManagementPolicy()
+		var imageManagement policy.ImageManagement
+
+		err := decodeAttributes(policyConfig.Attributes, &imageManagement)
+		if err != nil {
+			klog.Fatalf("invalid ImageManagement attributes for endpoint %s: %v", endpoint, err)
+		}
+
+		p, err = policy.NewImageManagementPolicy(imageManagement, runtimeClient, imageClient)
+		if err != nil {
+			klog.Fatalf("failed to create ImageManagement policy for endpoint %s: %v", endpoint, err)
+		}
 	case "PodScoped":
 		var (
 			podSandboxID            string
 			podSandboxFromCallerPID bool
 		)
 
-		if val, ok := endpoint.Policy.Attributes["pod-sandbox-id"]; ok {
+		if val, ok := policyConfig.Attributes["pod-sandbox-id"]; ok {
 			podSandboxID, ok = val.(string)
 			if !ok {
-				klog.Fatalf("pod-sandbox-id must be a string for endpoint %s", endpoint.Endpoint)
+				klog.Fatalf("pod-sandbox-id must be a string for endpoint %s", endpoint)
 			}
 		}
 
-		if val, ok := endpoint.Policy.Attributes["pod-sandbox-from-caller-pid"]; ok {
+		if val, ok := policyConfig.Attributes["pod-sandbox-from-caller-pid"]; ok {
 			podSandboxFromCallerPID, ok = val.(bool)
 			if !ok {
-				klog.Fatalf("pod-sandbox-from-caller-pid must be a boolean for endpoint %s", endpoint.Endpoint)
+				klog.Fatalf("pod-sandbox-from-caller-pid must be a boolean for endpoint %s", endpoint)
+			}
+		}
+
+		p = policy.NewPodScopedPolicy(podSandboxID, podSandboxFromCallerPID, runtimeClient)
+	case "ContainerScoped":
+		var siblings bool
+
+		if val, ok := policyConfig.Attributes["siblings"]; ok {
+			siblings, ok = val.(bool)
+			if !ok {
+				klog.Fatalf("siblings must be a boolean for endpoint %s", endpoint)
+			}
+		}
+
+		p = policy.NewContainerScopedPolicy(siblings, runtimeClient)
+	case "NamespaceScoped":
+		var namespace string
+
+		if val, ok := policyConfig.Attributes["namespace"]; ok {
+			namespace, ok = val.(string)
+			if !ok {
+				klog.Fatalf("namespace must be a string for endpoint %s", endpoint)
 			}
 		}
 
-		p = policy.NewPodScopedPolicy(podSandboxID, podSandboxFromCallerPID, server.GetRuntimeClient())
+		p = policy.NewNamespaceScopedPolicy(namespace, runtimeClient)
+	case "LabelSelectorScoped":
+		selector, ok := policyConfig.Attributes["selector"].(string)
+		if !ok {
+			klog.Fatalf("selector must be a string for endpoint %s", endpoint)
+		}
+
+		var err error
+
+		p, err = policy.NewLabelSelectorScopedPolicy(selector, runtimeClient)
+		if err != nil {
+			klog.Fatalf("failed to create LabelSelectorScoped policy for endpoint %s: %v", endpoint, err)
+		}
+	case "ExecAllowlist":
+		var allowlist policy.ExecAllowlist
+
+		err := decodeAttributes(policyConfig.Attributes, &allowlist)
+		if err != nil {
+			klog.Fatalf("invalid ExecAllowlist attributes for endpoint %s: %v", endpoint, err)
+		}
+
+		p, err = policy.NewExecAllowlistPolicy(allowlist, runtimeClient)
+		if err != nil {
+			klog.Fatalf("failed to create ExecAllowlist policy for endpoint %s: %v", endpoint, err)
+		}
+	case "ContainerGuard":
+		var guard policy.ContainerGuard
+
+		err := decodeAttributes(policyConfig.Attributes, &guard)
+		if err != nil {
+			klog.Fatalf("invalid ContainerGuard attributes for endpoint %s: %v", endpoint, err)
+		}
+
+		p, err = policy.NewContainerGuardPolicy(guard)
+		if err != nil {
+			klog.Fatalf("failed to create ContainerGuard policy for endpoint %s: %v", endpoint, err)
+		}
+	case "RateLimit":
+		var rateLimit policy.RateLimit
+
+		err := decodeAttributes(policyConfig.Attributes, &rateLimit)
+		if err != nil {
+			klog.Fatalf("invalid RateLimit attributes for endpoint %s: %v", endpoint, err)
+		}
+
+		p, err = policy.NewRateLimitPolicy(rateLimit, runtimeClient)
+		if err != nil {
+			klog.Fatalf("failed to create RateLimit policy for endpoint %s: %v", endpoint, err)
+		}
+	case "ResourceBounds":
+		var bounds policy.ResourceBounds
+
+		err := decodeAttributes(policyConfig.Attributes, &bounds)
+		if err != nil {
+			klog.Fatalf("invalid ResourceBounds attributes for endpoint %s: %v", endpoint, err)
+		}
+
+		p, err = policy.NewResourceBoundsPolicy(bounds, runtimeClient)
+		if err != nil {
+			klog.Fatalf("failed to create ResourceBounds policy for endpoint %s: %v", endpoint, err)
+		}
+	case "PortForward":
+		var portForward policy.PortForward
+
+		err := decodeAttributes(policyConfig.Attributes, &portForward)
+		if err != nil {
+			klog.Fatalf("invalid PortForward attributes for endpoint %s: %v", endpoint, err)
+		}
+
+		p, err = policy.NewPortForwardPolicy(portForward, runtimeClient)
+		if err != nil {
+			klog.Fatalf("failed to create PortForward policy for endpoint %s: %v", endpoint, err)
+		}
 	default:
-		klog.Fatalf("unknown policy: %s", endpoint.Policy.Name)
+		klog.Fatalf("unknown policy: %s", policyConfig.Name)
 	}
 
-	server.SetPolicy(p)
+	return p
+}
 
-	err = server.Start(endpoint.Endpoint)
+// decodeAttributes decodes the attributes of a policy into out, for policies
+// whose configuration is more structured than a few scalar attributes.
+func decodeAttributes(attributes map[string]interface{}, out interface{}) error {
+	data, err := yaml.Marshal(attributes)
 	if err != nil {
-		klog.Fatalf("failed to start server for endpoint %s: %v", endpoint.Endpoint, err)
+		return fmt.Errorf("failed to marshal attributes: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(out)
+	if err != nil {
+		return fmt.Errorf("failed to decode attributes: %w", err)
 	}
+
+	return nil
 }
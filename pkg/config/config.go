@@ -2,12 +2,42 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	yaml "gopkg.in/yaml.v3"
 )
 
+var (
+	// ErrConflictingPolicies is returned when an endpoint sets both policy and policies.
+	ErrConflictingPolicies = errors.New("only one of policy and policies can be set")
+	// ErrInvalidUpstreamBudget is returned when the upstream budget has negative limits.
+	ErrInvalidUpstreamBudget = errors.New("invalid upstream budget")
+	// ErrInvalidMode is returned when an endpoint has an unknown mode.
+	ErrInvalidMode = errors.New("invalid mode")
+	// ErrInvalidStreaming is returned when the streaming server of an endpoint
+	// has no address or negative durations.
+	ErrInvalidStreaming = errors.New("invalid streaming server")
+	// ErrRateLimitNotFirst is returned when a RateLimit policy is not the first
+	// policy of an endpoint, as the policies before it would call the runtime
+	// for the calls over the limits.
+	ErrRateLimitNotFirst = errors.New("RateLimit must be the first policy")
+	// ErrPortForwardWithoutStreaming is returned when an endpoint has a
+	// PortForward policy but no streaming server, as the streams of the
+	// runtime forward any port.
+	ErrPortForwardWithoutStreaming = errors.New("PortForward requires a streaming server")
+)
+
+// Modes of an endpoint.
+const (
+	// ModeEnforce denies the requests that the policies do not allow.
+	ModeEnforce = "enforce"
+	// ModeAudit logs the requests that the policies do not allow, and forwards
+	// all requests and responses unchanged.
+	ModeAudit = "audit"
+)
+
 // Config defines the global configuration for cri-lite.
 type Config struct {
 	RuntimeEndpoint string     `yaml:"runtime-endpoint"`
@@ -15,6 +45,24 @@ type Config struct {
 	Timeout         int        `yaml:"timeout"`
 	Logging         Logging    `yaml:"logging"`
 	Endpoints       []Endpoint `yaml:"endpoints"`
+	// UpstreamBudget limits the calls made to the runtime and image services
+	// by all the endpoints together.
+	UpstreamBudget *UpstreamBudget `yaml:"upstream-budget,omitempty"`
+	// DebugAddress is the TCP address serving the counters of cri-lite, such
+	// as the denials of audited policies, at /debug/vars. Empty means none.
+	DebugAddress string `yaml:"debug-address,omitempty"`
+}
+
+// UpstreamBudget defines the limits of the calls made to the runtime and image
+// services. Each endpoint can use up to an even share of it, so that the
+// callers of one endpoint cannot starve the others. Zero means no limit.
+type UpstreamBudget struct {
+	// Rate is the number of calls per second.
+	Rate float64 `yaml:"rate,omitempty"`
+	// Burst is the number of calls that can be made at once above the rate.
+	Burst int `yaml:"burst,omitempty"`
+	// MaxInFlight is the number of unary calls in flight.
+	MaxInFlight int `yaml:"max-in-flight,omitempty"`
 }
 
 // Logging defines the logging configuration for cri-lite.
@@ -26,12 +74,112 @@ type Logging struct {
 type Endpoint struct {
 	Endpoint string       `yaml:"endpoint"`
 	Policy   PolicyConfig `yaml:"policy"`
+	// Policies are enforced together: a call must be allowed by all of them.
+	// Policy is a shorthand for a single policy and cannot be combined with Policies.
+	Policies []PolicyConfig `yaml:"policies,omitempty"`
+	// Mode is either ModeEnforce or ModeAudit. It defaults to ModeEnforce.
+	Mode string `yaml:"mode,omitempty"`
+	// Shadow are candidate policies evaluated alongside the enforced ones
+	// without being enforced. Their divergences from the enforced policies
+	// are logged.
+	Shadow []PolicyConfig `yaml:"shadow,omitempty"`
+	// AllowedUIDs and AllowedGIDs restrict the endpoint to the callers with
+	// one of the UIDs, or with one of the GIDs as primary or supplementary
+	// group, before any policy is evaluated. The endpoint is open to all
+	// callers when both are empty.
+	AllowedUIDs []uint32 `yaml:"allowed-uids,omitempty"`
+	AllowedGIDs []uint32 `yaml:"allowed-gids,omitempty"`
+	// AllowedExecutables restrict the endpoint to the callers running one of
+	// the executables, before any policy is evaluated.
+	AllowedExecutables []Executable `yaml:"allowed-executables,omitempty"`
+	// Streaming runs a streaming server for the Exec, Attach and PortForward
+	// calls of the endpoint. Without it, the callers get the streaming URLs
+	// of the runtime.
+	Streaming *Streaming `yaml:"streaming,omitempty"`
+	// ExecSyncAudit records the ExecSync calls of the endpoint, with their
+	// caller and what they returned.
+	ExecSyncAudit *ExecSyncAudit `yaml:"exec-sync-audit,omitempty"`
+}
+
+// ExecSyncAudit defines the audit of the ExecSync calls of an endpoint.
+type ExecSyncAudit struct {
+	// File is the path of the file the records are appended to as JSON lines.
+	// When empty, the records are logged.
+	File string `yaml:"file,omitempty"`
+	// MaxOutputBytes is the number of bytes of the standard output and error
+	// recorded. It defaults to 1024.
+	MaxOutputBytes int `yaml:"max-output-bytes,omitempty"`
+	// HashOnly records the lengths and SHA-256 digests of the outputs only.
+	HashOnly bool `yaml:"hash-only,omitempty"`
+	// OutputSampleRate is the fraction of the calls whose outputs are
+	// recorded. It defaults to 1.
+	OutputSampleRate float64 `yaml:"output-sample-rate,omitempty"`
+}
+
+// Streaming defines the streaming server of an endpoint, which proxies the
+// streams of the callers to the runtime.
+type Streaming struct {
+	// Address is a host:port to listen on, or the absolute path of a unix
+	// socket. Only the streams opened over a unix socket are bound to the UID
+	// of the caller: over TCP, anyone holding a URL can open its stream.
+	Address string `yaml:"address"`
+	// URL is the base URL that callers reach the server at. It defaults to
+	// http://Address for TCP addresses.
+	URL string `yaml:"url,omitempty"`
+	// TokenTTLSeconds is how long the URLs handed out to callers can be used.
+	// It defaults to 30 seconds.
+	TokenTTLSeconds int `yaml:"token-ttl-seconds,omitempty"`
+	// MaxSessionSeconds ends the streams that last longer. Zero means no limit.
+	MaxSessionSeconds int `yaml:"max-session-seconds,omitempty"`
+	// AllowTTY and AllowStdin allow the Exec and Attach streams that
+	// allocate a TTY or stream the standard input.
+	AllowTTY   bool `yaml:"allow-tty,omitempty"`
+	AllowStdin bool `yaml:"allow-stdin,omitempty"`
+}
+
+// Executable identifies the callers running on the host, like node agents.
+// SHA256 and Unit match any caller when they are empty. Only the callers in
+// the mount namespace of cri-lite match.
+type Executable struct {
+	// Path is the absolute path of the executable of the caller.
+	Path string `yaml:"path"`
+	// SHA256 is the hexadecimal SHA-256 digest of the executable.
+	SHA256 string `yaml:"sha256,omitempty"`
+	// Unit is the systemd unit of the caller, e.g. node-agent.service.
+	Unit string `yaml:"unit,omitempty"`
+}
+
+// PolicyConfigs returns the policies of the endpoint in the order they are enforced.
+func (e *Endpoint) PolicyConfigs() []PolicyConfig {
+	if len(e.Policies) > 0 {
+		return e.Policies
+	}
+
+	return []PolicyConfig{e.Policy}
 }
 
 // PolicyConfig defines the configuration for a policy.
 type PolicyConfig struct {
-	Name       string                 `yaml:"name"`
-	Attributes map[string]interface{} `yaml:"attributes,omitempty"`
+	Name string `yaml:"name"`
+	// File is the path to a declarative policy file. When set, the rules in the file
+	// are enforced instead of a built-in policy and Name only overrides the policy name.
+	File string `yaml:"file,omitempty"`
+	// AuthorizeFromAnnotation restricts the policy to pods whose cri-lite.io/policy
+	// annotation contains the name of the policy.
+	AuthorizeFromAnnotation bool                   `yaml:"authorize-from-annotation,omitempty"`
+	Attributes              map[string]interface{} `yaml:"attributes,omitempty"`
+}
+
+// UnmarshalYAML allows a policy without a file or attributes to be given by
+// its name only, e.g. `policies: [ReadOnly]`.
+func (p *PolicyConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&p.Name)
+	}
+
+	type plain PolicyConfig
+
+	return value.Decode((*plain)(p))
 }
 
 // LoadFile reads and parses the configuration from a YAML file.
@@ -53,5 +201,43 @@ func LoadFile(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to unmarshal config file %q: %w", path, err)
 	}
 
+	for _, endpoint := range config.Endpoints {
+		hasPolicy := endpoint.Policy.Name != "" || endpoint.Policy.File != "" ||
+			endpoint.Policy.AuthorizeFromAnnotation || len(endpoint.Policy.Attributes) > 0
+		if hasPolicy && len(endpoint.Policies) > 0 {
+			return nil, fmt.Errorf("%w: endpoint %s", ErrConflictingPolicies, endpoint.Endpoint)
+		}
+
+		for i, p := range endpoint.PolicyConfigs() {
+			if i > 0 && p.Name == "RateLimit" && p.File == "" {
+				return nil, fmt.Errorf("%w: endpoint %s", ErrRateLimitNotFirst, endpoint.Endpoint)
+			}
+
+			if p.Name == "PortForward" && p.File == "" && endpoint.Streaming == nil {
+				return nil, fmt.Errorf("%w: endpoint %s", ErrPortForwardWithoutStreaming, endpoint.Endpoint)
+			}
+		}
+
+		if endpoint.Mode != "" && endpoint.Mode != ModeEnforce && endpoint.Mode != ModeAudit {
+			return nil, fmt.Errorf("%w: endpoint %s: mode must be %q or %q, got %q", ErrInvalidMode, endpoint.Endpoint, ModeEnforce, ModeAudit, endpoint.Mode)
+		}
+
+		if streaming := endpoint.Streaming; streaming != nil {
+			if streaming.Address == "" {
+				return nil, fmt.Errorf("%w: endpoint %s: address must be set", ErrInvalidStreaming, endpoint.Endpoint)
+			}
+
+			if streaming.TokenTTLSeconds < 0 || streaming.MaxSessionSeconds < 0 {
+				return nil, fmt.Errorf("%w: endpoint %s: durations must not be negative", ErrInvalidStreaming, endpoint.Endpoint)
+			}
+		}
+	}
+
+	if budget := config.UpstreamBudget; budget != nil {
+		if budget.Rate < 0 || budget.Burst < 0 || budget.MaxInFlight < 0 {
+			return nil, fmt.Errorf("%w: limits must not be negative", ErrInvalidUpstreamBudget)
+		}
+	}
+
 	return &config, nil
 }
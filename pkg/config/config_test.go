@@ -0,0 +1,371 @@
+// Package config_test provides tests for the config package.
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"cri-lite/pkg/config"
+)
+
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	return path
+}
+
+func TestLoadFilePolicies(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, `
+endpoints:
+- endpoint: /run/cri-lite/single.sock
+  policy:
+    name: ReadOnly
+- endpoint: /run/cri-lite/multiple.sock
+  policies:
+  - name: PodScoped
+    attributes:
+      pod-sandbox-from-caller-pid: true
+  - ReadOnly
+`)
+
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	if len(cfg.Endpoints) != 2 {
+		t.Fatalf("expected 2 endpoints, got %d", len(cfg.Endpoints))
+	}
+
+	single := cfg.Endpoints[0].PolicyConfigs()
+	if len(single) != 1 || single[0].Name != "ReadOnly" {
+		t.Errorf("expected the single policy ReadOnly, got %+v", single)
+	}
+
+	multiple := cfg.Endpoints[1].PolicyConfigs()
+	if len(multiple) != 2 {
+		t.Fatalf("expected 2 policies, got %+v", multiple)
+	}
+
+	if multiple[0].Name != "PodScoped" || multiple[0].Attributes["pod-sandbox-from-caller-pid"] != true {
+		t.Errorf("expected PodScoped with attributes, got %+v", multiple[0])
+	}
+
+	if multiple[1].Name != "ReadOnly" {
+		t.Errorf("expected ReadOnly, got %+v", multiple[1])
+	}
+}
+
+func TestLoadFileConflictingPolicies(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, `
+endpoints:
+- endpoint: /run/cri-lite/conflict.sock
+  policy:
+    name: ReadOnly
+  policies:
+  - ImageManagement
+`)
+
+	_, err := config.LoadFile(path)
+	if !errors.Is(err, config.ErrConflictingPolicies) {
+		t.Errorf("expected ErrConflictingPolicies, got %v", err)
+	}
+}
+
+func TestLoadFileRateLimitNotFirst(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, `
+endpoints:
+- endpoint: /run/cri-lite/limited.sock
+  policies:
+  - PodScoped
+  - name: RateLimit
+    attributes:
+      groups:
+      - name: all
+        methods: ["*"]
+        rate: 1
+`)
+
+	_, err := config.LoadFile(path)
+	if !errors.Is(err, config.ErrRateLimitNotFirst) {
+		t.Errorf("expected ErrRateLimitNotFirst, got %v", err)
+	}
+}
+
+func TestLoadFilePortForwardWithoutStreaming(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, `
+endpoints:
+- endpoint: /run/cri-lite/forward.sock
+  policies:
+  - PodScoped
+  - name: PortForward
+    attributes:
+      allowed-ports: [8080]
+`)
+
+	_, err := config.LoadFile(path)
+	if !errors.Is(err, config.ErrPortForwardWithoutStreaming) {
+		t.Errorf("expected ErrPortForwardWithoutStreaming, got %v", err)
+	}
+
+	path = writeConfig(t, `
+endpoints:
+- endpoint: /run/cri-lite/forward.sock
+  policies:
+  - PodScoped
+  - name: PortForward
+    attributes:
+      allowed-ports: [8080]
+  streaming:
+    address: 127.0.0.1:10350
+`)
+
+	_, err = config.LoadFile(path)
+	if err != nil {
+		t.Errorf("expected a PortForward policy with a streaming server to load, got %v", err)
+	}
+}
+
+func TestLoadFileDebugAddress(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, `
+debug-address: 127.0.0.1:9090
+endpoints:
+- endpoint: /run/cri-lite/readonly.sock
+  policy:
+    name: ReadOnly
+  mode: audit
+`)
+
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	if cfg.DebugAddress != "127.0.0.1:9090" {
+		t.Errorf("expected debug address 127.0.0.1:9090, got %q", cfg.DebugAddress)
+	}
+}
+
+func TestLoadFileUpstreamBudget(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, `
+upstream-budget:
+  rate: 100
+  burst: 20
+  max-in-flight: 8
+endpoints:
+- endpoint: /run/cri-lite/readonly.sock
+  policy:
+    name: ReadOnly
+`)
+
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	expected := config.UpstreamBudget{Rate: 100, Burst: 20, MaxInFlight: 8}
+	if cfg.UpstreamBudget == nil || *cfg.UpstreamBudget != expected {
+		t.Errorf("expected upstream budget %+v, got %+v", expected, cfg.UpstreamBudget)
+	}
+
+	path = writeConfig(t, `
+upstream-budget:
+  rate: -1
+`)
+
+	_, err = config.LoadFile(path)
+	if !errors.Is(err, config.ErrInvalidUpstreamBudget) {
+		t.Errorf("expected ErrInvalidUpstreamBudget, got %v", err)
+	}
+}
+
+func TestLoadFileMode(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, `
+endpoints:
+- endpoint: /run/cri-lite/audit.sock
+  mode: audit
+  policy:
+    name: PodScoped
+`)
+
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	if cfg.Endpoints[0].Mode != config.ModeAudit {
+		t.Errorf("expected mode %q, got %q", config.ModeAudit, cfg.Endpoints[0].Mode)
+	}
+
+	path = writeConfig(t, `
+endpoints:
+- endpoint: /run/cri-lite/dry-run.sock
+  mode: dry-run
+  policy:
+    name: PodScoped
+`)
+
+	_, err = config.LoadFile(path)
+	if !errors.Is(err, config.ErrInvalidMode) {
+		t.Errorf("expected ErrInvalidMode, got %v", err)
+	}
+}
+
+func TestLoadFileShadow(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, `
+endpoints:
+- endpoint: /run/cri-lite/pod.sock
+  policy:
+    name: PodScoped
+  shadow:
+  - name: LabelSelectorScoped
+    attributes:
+      selector: app=web
+`)
+
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	shadow := cfg.Endpoints[0].Shadow
+	if len(shadow) != 1 || shadow[0].Name != "LabelSelectorScoped" || shadow[0].Attributes["selector"] != "app=web" {
+		t.Errorf("expected the shadow policy LabelSelectorScoped with attributes, got %+v", shadow)
+	}
+}
+
+func TestLoadFileAllowedIDs(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, `
+endpoints:
+- endpoint: /run/cri-lite/host.sock
+  policy: ReadOnly
+  allowed-uids: [0, 1000]
+  allowed-gids: [2000]
+`)
+
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	endpoint := cfg.Endpoints[0]
+	if !slices.Equal(endpoint.AllowedUIDs, []uint32{0, 1000}) || !slices.Equal(endpoint.AllowedGIDs, []uint32{2000}) {
+		t.Errorf("expected allowed UIDs [0 1000] and GIDs [2000], got %v and %v", endpoint.AllowedUIDs, endpoint.AllowedGIDs)
+	}
+}
+
+func TestLoadFileAllowedExecutables(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, `
+endpoints:
+- endpoint: /run/cri-lite/node-agent.sock
+  policy: ReadOnly
+  allowed-executables:
+  - path: /usr/bin/node-agent
+    unit: node-agent.service
+`)
+
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	expected := []config.Executable{{Path: "/usr/bin/node-agent", Unit: "node-agent.service"}}
+	if !slices.Equal(cfg.Endpoints[0].AllowedExecutables, expected) {
+		t.Errorf("expected allowed executables %+v, got %+v", expected, cfg.Endpoints[0].AllowedExecutables)
+	}
+}
+
+func TestLoadFileStreaming(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, `
+endpoints:
+- endpoint: /run/cri-lite/debug.sock
+  policy: PodScoped
+  streaming:
+    address: 127.0.0.1:10350
+    token-ttl-seconds: 10
+    max-session-seconds: 3600
+    allow-stdin: true
+`)
+
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	expected := config.Streaming{Address: "127.0.0.1:10350", TokenTTLSeconds: 10, MaxSessionSeconds: 3600, AllowStdin: true}
+	if cfg.Endpoints[0].Streaming == nil || *cfg.Endpoints[0].Streaming != expected {
+		t.Errorf("expected streaming %+v, got %+v", expected, cfg.Endpoints[0].Streaming)
+	}
+
+	for _, streaming := range []string{
+		"url: http://node:10350",
+		"address: 127.0.0.1:10350\n    max-session-seconds: -1",
+	} {
+		path = writeConfig(t, `
+endpoints:
+- endpoint: /run/cri-lite/debug.sock
+  policy: PodScoped
+  streaming:
+    `+streaming+`
+`)
+
+		_, err = config.LoadFile(path)
+		if !errors.Is(err, config.ErrInvalidStreaming) {
+			t.Errorf("expected ErrInvalidStreaming for %q, got %v", streaming, err)
+		}
+	}
+}
+
+func TestLoadFileExecSyncAudit(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, `
+endpoints:
+- endpoint: /run/cri-lite/debug.sock
+  policy: PodScoped
+  exec-sync-audit:
+    file: /var/log/cri-lite/exec-sync.log
+    max-output-bytes: 4096
+    output-sample-rate: 0.1
+`)
+
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	expected := config.ExecSyncAudit{File: "/var/log/cri-lite/exec-sync.log", MaxOutputBytes: 4096, OutputSampleRate: 0.1}
+	if cfg.Endpoints[0].ExecSyncAudit == nil || *cfg.Endpoints[0].ExecSyncAudit != expected {
+		t.Errorf("expected exec sync audit %+v, got %+v", expected, cfg.Endpoints[0].ExecSyncAudit)
+	}
+}
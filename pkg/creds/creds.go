@@ -1,4 +1,4 @@
-// Package creds provides a custom gRPC credentials implementation that extracts the caller's PID.
+// Package creds provides a custom gRPC credentials implementation that extracts the caller's PID and identity.
 package creds
 
 import (
@@ -60,3 +60,37 @@ func (ai *ucredAuthInfo) AuthType() string {
 func (ai *ucredAuthInfo) GetPID() int32 {
 	return ai.ucred.pid
 }
+
+func (ai *ucredAuthInfo) GetUID() uint32 {
+	return ai.ucred.uid
+}
+
+func (ai *ucredAuthInfo) GetGID() uint32 {
+	return ai.ucred.gid
+}
+
+func (ai *ucredAuthInfo) GetGroups() []uint32 {
+	return ai.ucred.groups
+}
+
+func (ai *ucredAuthInfo) GetNamespaceUID() uint32 {
+	return ai.ucred.namespaceUID
+}
+
+func (ai *ucredAuthInfo) GetNamespaceGID() uint32 {
+	return ai.ucred.namespaceGID
+}
+
+func (ai *ucredAuthInfo) GetExecutable() string {
+	return ai.ucred.executable
+}
+
+// GetExecutableDigest returns the digest of the executable of the caller,
+// which is computed on the first call.
+func (ai *ucredAuthInfo) GetExecutableDigest() string {
+	return ai.ucred.executableDigest()
+}
+
+func (ai *ucredAuthInfo) GetUnit() string {
+	return ai.ucred.unit
+}
@@ -0,0 +1,287 @@
+//go:build linux
+// +build linux
+
+package creds
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+var (
+	errIDNotMapped  = errors.New("id is not mapped")
+	errInvalidIDMap = errors.New("invalid ID map")
+)
+
+// supplementaryGroups returns the supplementary groups of a process, as listed
+// by /proc/<pid>/status in the user namespace of cri-lite.
+func supplementaryGroups(pid int32) ([]uint32, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "Groups:")
+		if !ok {
+			continue
+		}
+
+		fields := strings.Fields(value)
+		groups := make([]uint32, 0, len(fields))
+
+		for _, field := range fields {
+			gid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid group %q: %w", field, err)
+			}
+
+			groups = append(groups, uint32(gid))
+		}
+
+		return groups, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// namespacedID maps an ID of the user namespace of cri-lite to the user
+// namespace of a process, using the uid_map or gid_map file of the process.
+func namespacedID(pid int32, mapFile string, id uint32) (uint32, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/%s", pid, mapFile))
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	return mapID(file, id)
+}
+
+// mapID maps an ID through an ID map. Every line of the map is a range of
+// "<first ID inside> <first ID outside> <length>", where the IDs outside are
+// the IDs of the user namespace of the reader of the map.
+func mapID(r io.Reader, id uint32) (uint32, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			return 0, fmt.Errorf("%w: %q", errInvalidIDMap, scanner.Text())
+		}
+
+		var values [3]uint64
+
+		for i, field := range fields {
+			value, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return 0, fmt.Errorf("%w: %q: %w", errInvalidIDMap, scanner.Text(), err)
+			}
+
+			values[i] = value
+		}
+
+		inside, outside, length := values[0], values[1], values[2]
+		if uint64(id) >= outside && uint64(id)-outside < length {
+			return uint32(inside + uint64(id) - outside), nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("%w: %d", errIDNotMapped, id)
+}
+
+// executable returns the path of the executable of a process. The path ends
+// with " (deleted)" when the executable was removed after the process started.
+func executable(pid int32) (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+}
+
+// sharesMountNamespace tells whether a process is in the mount namespace of
+// cri-lite, where the path of its executable names the same file.
+func sharesMountNamespace(pid int32) (bool, error) {
+	own, err := os.Readlink("/proc/self/ns/mnt")
+	if err != nil {
+		return false, err
+	}
+
+	other, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/mnt", pid))
+	if err != nil {
+		return false, err
+	}
+
+	return own == other, nil
+}
+
+// digestKey identifies a version of an executable file.
+type digestKey struct {
+	dev     uint64
+	ino     uint64
+	size    int64
+	modTime int64
+}
+
+// maxDigests bounds the digests of executables remembered, which are only
+// computed for the callers matched against a digest.
+const maxDigests = 256
+
+var (
+	errExecutableChanged = errors.New("executable changed")
+	errNoStat            = errors.New("no stat")
+)
+
+// digests caches the digests of the executables of the callers, as callers
+// usually make many connections with the same executable.
+var digests = struct {
+	sync.Mutex
+
+	byKey map[digestKey]string
+}{byKey: make(map[digestKey]string)}
+
+// executableKey returns the key of the version of the executable a process
+// runs, even if the file was replaced or removed since the process started.
+func executableKey(pid int32) (digestKey, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return digestKey{}, err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	return fileDigestKey(file)
+}
+
+func fileDigestKey(file *os.File) (digestKey, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return digestKey{}, err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return digestKey{}, fmt.Errorf("%w: %s", errNoStat, file.Name())
+	}
+
+	return digestKey{
+		dev:     uint64(stat.Dev), //nolint:unconvert // Dev is not a uint64 on every architecture.
+		ino:     stat.Ino,
+		size:    info.Size(),
+		modTime: info.ModTime().UnixNano(),
+	}, nil
+}
+
+// executableDigest returns the SHA-256 digest of the version key of the
+// executable of a process, as "sha256:<hex>". It is computed when a caller is
+// first matched against a digest, when the process may have exited, so the
+// executable is only read if it still is the version of key.
+func executableDigest(pid int32, key digestKey) (string, error) {
+	digests.Lock()
+	digest, found := digests.byKey[key]
+	digests.Unlock()
+
+	if found {
+		return digest, nil
+	}
+
+	file, err := os.Open(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	current, err := fileDigestKey(file)
+	if err != nil {
+		return "", err
+	}
+
+	if current != key {
+		return "", fmt.Errorf("%w: process %d", errExecutableChanged, pid)
+	}
+
+	hash := sha256.New()
+
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	digest = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+
+	digests.Lock()
+
+	if len(digests.byKey) >= maxDigests {
+		clear(digests.byKey)
+	}
+
+	digests.byKey[key] = digest
+	digests.Unlock()
+
+	return digest, nil
+}
+
+// systemdUnit returns the systemd unit of a process, e.g. node-agent.service,
+// or an empty string if it does not run in one.
+func systemdUnit(pid int32) (string, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	return parseSystemdUnit(file)
+}
+
+// parseSystemdUnit returns the innermost unit of the cgroup of the systemd
+// hierarchy in a cgroup file: the unified hierarchy of cgroup v2, or the
+// name=systemd hierarchy of cgroup v1.
+func parseSystemdUnit(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// Lines are of the form "<hierarchy ID>:<controllers>:<path>".
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 || (fields[1] != "" && fields[1] != "name=systemd") {
+			continue
+		}
+
+		elements := strings.Split(fields[2], "/")
+		for i := len(elements) - 1; i >= 0; i-- {
+			if strings.HasSuffix(elements[i], ".service") || strings.HasSuffix(elements[i], ".scope") {
+				return elements[i], nil
+			}
+		}
+
+		return "", nil
+	}
+
+	return "", scanner.Err()
+}
@@ -0,0 +1,177 @@
+//go:build linux
+// +build linux
+
+package creds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMapID(t *testing.T) {
+	t.Parallel()
+
+	idMap := "         0     100000      65536\n     65536          0          1\n"
+
+	tests := []struct {
+		id       uint32
+		expected uint32
+	}{
+		{id: 100000, expected: 0},
+		{id: 101000, expected: 1000},
+		{id: 0, expected: 65536},
+	}
+
+	for _, test := range tests {
+		id, err := mapID(strings.NewReader(idMap), test.id)
+		if err != nil {
+			t.Fatalf("mapID(%d) failed: %v", test.id, err)
+		}
+
+		if id != test.expected {
+			t.Errorf("expected mapID(%d) to be %d, got %d", test.id, test.expected, id)
+		}
+	}
+
+	_, err := mapID(strings.NewReader(idMap), 1000)
+	if !errors.Is(err, errIDNotMapped) {
+		t.Errorf("expected errIDNotMapped, got %v", err)
+	}
+
+	_, err = mapID(strings.NewReader("0 0\n"), 0)
+	if !errors.Is(err, errInvalidIDMap) {
+		t.Errorf("expected errInvalidIDMap, got %v", err)
+	}
+}
+
+func TestSupplementaryGroups(t *testing.T) {
+	t.Parallel()
+
+	groups, err := supplementaryGroups(int32(os.Getpid()))
+	if err != nil {
+		t.Fatalf("supplementaryGroups failed: %v", err)
+	}
+
+	expected, err := os.Getgroups()
+	if err != nil {
+		t.Fatalf("Getgroups failed: %v", err)
+	}
+
+	if len(groups) != len(expected) {
+		t.Errorf("expected groups %v, got %v", expected, groups)
+	}
+}
+
+func TestSharesMountNamespace(t *testing.T) {
+	t.Parallel()
+
+	shared, err := sharesMountNamespace(int32(os.Getpid()))
+	if err != nil {
+		t.Fatalf("sharesMountNamespace failed: %v", err)
+	}
+
+	if !shared {
+		t.Errorf("expected cri-lite to share its own mount namespace")
+	}
+}
+
+func TestParseSystemdUnit(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		cgroup   string
+		expected string
+	}{
+		{
+			name:     "cgroup v2 service",
+			cgroup:   "0::/system.slice/node-agent.service\n",
+			expected: "node-agent.service",
+		},
+		{
+			name:     "cgroup v2 cgroup in a service",
+			cgroup:   "0::/system.slice/node-agent.service/workers\n",
+			expected: "node-agent.service",
+		},
+		{
+			name:     "cgroup v2 user service",
+			cgroup:   "0::/user.slice/user-1000.slice/user@1000.service/app.slice/agent.service\n",
+			expected: "agent.service",
+		},
+		{
+			name:     "cgroup v1",
+			cgroup:   "12:cpu,cpuacct:/system.slice/other.service\n1:name=systemd:/system.slice/node-agent.service\n",
+			expected: "node-agent.service",
+		},
+		{
+			name:     "container",
+			cgroup:   "0::/kubepods.slice/kubepods-besteffort.slice/cri-containerd-0123.scope\n",
+			expected: "cri-containerd-0123.scope",
+		},
+		{
+			name:     "no unit",
+			cgroup:   "0::/\n",
+			expected: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			unit, err := parseSystemdUnit(strings.NewReader(test.cgroup))
+			if err != nil {
+				t.Fatalf("parseSystemdUnit failed: %v", err)
+			}
+
+			if unit != test.expected {
+				t.Errorf("expected unit %q, got %q", test.expected, unit)
+			}
+		})
+	}
+}
+
+func TestExecutableDigest(t *testing.T) {
+	t.Parallel()
+
+	path, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Executable failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+
+	sum := sha256.Sum256(data)
+	expected := "sha256:" + hex.EncodeToString(sum[:])
+
+	key, err := executableKey(int32(os.Getpid()))
+	if err != nil {
+		t.Fatalf("executableKey failed: %v", err)
+	}
+
+	// The second call returns the cached digest.
+	for range 2 {
+		digest, err := executableDigest(int32(os.Getpid()), key)
+		if err != nil {
+			t.Fatalf("executableDigest failed: %v", err)
+		}
+
+		if digest != expected {
+			t.Errorf("expected digest %s, got %s", expected, digest)
+		}
+	}
+
+	key.modTime++
+
+	_, err = executableDigest(int32(os.Getpid()), key)
+	if !errors.Is(err, errExecutableChanged) {
+		t.Errorf("expected errExecutableChanged for another version, got %v", err)
+	}
+}
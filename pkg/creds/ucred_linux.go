@@ -4,17 +4,57 @@
 package creds
 
 import (
+	"errors"
 	"net"
+	"sync"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
+var errNotUnixConn = errors.New("not a unix socket connection")
+
+// soPeerPIDFD is the SO_PEERPIDFD socket option of Linux 6.5, which returns a
+// pidfd of the process that connected a unix socket.
+const soPeerPIDFD = 77
+
 type ucred struct {
 	pid int32
 	uid uint32
 	gid uint32
+	// groups are the supplementary groups of the process.
+	groups []uint32
+	// namespaceUID and namespaceGID are the IDs of the process in its own
+	// user namespace, e.g. 0 for the root user of a user namespaced pod.
+	namespaceUID uint32
+	namespaceGID uint32
+	// executable, executableDigest and unit identify the callers that run
+	// on the host, where they have no pod sandbox. They are empty if they
+	// could not be resolved. executable and executableDigest are also empty
+	// for the callers in another mount namespace than cri-lite, e.g. in a
+	// container, where the path of the executable names another file.
+	executable string
+	unit       string
+	// executableKey is the version of the executable, whose digest is only
+	// computed once asked for.
+	executableKey    digestKey
+	executableDigest func() string
 }
 
-func getUcred(conn net.Conn) (*ucred, error) {
+// PeerUID returns the UID of the process on the other side of a unix socket
+// connection. ok is false for other connections.
+func PeerUID(conn net.Conn) (uid uint32, ok bool, err error) {
+	cred, err := peerUcred(conn)
+	if err != nil || cred == nil {
+		return 0, false, err
+	}
+
+	return cred.Uid, true, nil
+}
+
+// peerUcred returns the credentials of the peer of a unix socket connection,
+// or nil for other connections.
+func peerUcred(conn net.Conn) (*syscall.Ucred, error) {
 	unixConn, ok := conn.(*net.UnixConn)
 	if !ok {
 		return nil, nil
@@ -34,9 +74,114 @@ func getUcred(conn net.Conn) (*ucred, error) {
 		return nil, err
 	}
 
-	return &ucred{
-		pid: cred.Pid,
-		uid: cred.Uid,
-		gid: cred.Gid,
-	}, nil
+	return cred, nil
+}
+
+func getUcred(conn net.Conn) (*ucred, error) {
+	cred, err := peerUcred(conn)
+	if err != nil || cred == nil {
+		return nil, err
+	}
+
+	u := &ucred{
+		pid:          cred.Pid,
+		uid:          cred.Uid,
+		gid:          cred.Gid,
+		namespaceUID: cred.Uid,
+		namespaceGID: cred.Gid,
+	}
+	u.executableDigest = sync.OnceValue(u.digest)
+
+	// The identity of the process is read from /proc, where its PID may
+	// name another process once it exited. It is only read through a pidfd
+	// of the process, and is dropped unless the process is still alive,
+	// and so still owns its PID, once read.
+	pidfd, err := peerPIDFD(conn, cred.Pid)
+	if err != nil {
+		return u, nil
+	}
+
+	defer func() {
+		_ = unix.Close(pidfd)
+	}()
+
+	u.readProc()
+
+	if unix.PidfdSendSignal(pidfd, 0, nil, 0) != nil {
+		u.clearProc()
+	}
+
+	return u, nil
+}
+
+// peerPIDFD returns a pidfd of the process that connected a unix socket. On
+// kernels without SO_PEERPIDFD, it opens a pidfd of the PID the process had,
+// which cannot tell whether the process exited before.
+func peerPIDFD(conn net.Conn, pid int32) (int, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, errNotUnixConn
+	}
+
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	pidfd := -1
+
+	err = rawConn.Control(func(fd uintptr) {
+		pidfd, err = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, soPeerPIDFD)
+	})
+	if err == nil {
+		return pidfd, nil
+	}
+
+	return unix.PidfdOpen(int(pid), 0)
+}
+
+// readProc reads the identity of the process from /proc. The process may exit
+// before its identity is read. The supplementary groups, executable and unit
+// are then left empty, so that they cannot allow the process, and the IDs of
+// its user namespace default to the IDs seen by cri-lite.
+func (u *ucred) readProc() {
+	u.groups, _ = supplementaryGroups(u.pid)
+
+	if uid, err := namespacedID(u.pid, "uid_map", u.uid); err == nil {
+		u.namespaceUID = uid
+	}
+
+	if gid, err := namespacedID(u.pid, "gid_map", u.gid); err == nil {
+		u.namespaceGID = gid
+	}
+
+	if shared, err := sharesMountNamespace(u.pid); err == nil && shared {
+		u.executable, _ = executable(u.pid)
+		u.executableKey, _ = executableKey(u.pid)
+	}
+
+	u.unit, _ = systemdUnit(u.pid)
+}
+
+// clearProc drops the identity read from /proc, when it may be the identity
+// of another process.
+func (u *ucred) clearProc() {
+	u.groups = nil
+	u.namespaceUID = u.uid
+	u.namespaceGID = u.gid
+	u.executable = ""
+	u.executableKey = digestKey{}
+	u.unit = ""
+}
+
+// digest returns the digest of the executable of the process, or an empty
+// string if it could not be resolved.
+func (u *ucred) digest() string {
+	if u.executableKey == (digestKey{}) {
+		return ""
+	}
+
+	digest, _ := executableDigest(u.pid, u.executableKey)
+
+	return digest
 }
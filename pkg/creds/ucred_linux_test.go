@@ -0,0 +1,54 @@
+//go:build linux
+// +build linux
+
+package creds
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetUcred(t *testing.T) {
+	t.Parallel()
+
+	lis, err := net.Listen("unix", filepath.Join(t.TempDir(), "creds.sock"))
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	defer lis.Close()
+
+	client, err := net.Dial("unix", lis.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+
+	defer client.Close()
+
+	conn, err := lis.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+
+	defer conn.Close()
+
+	u, err := getUcred(conn)
+	if err != nil {
+		t.Fatalf("getUcred failed: %v", err)
+	}
+
+	if int(u.pid) != os.Getpid() {
+		t.Errorf("expected PID %d, got %d", os.Getpid(), u.pid)
+	}
+
+	expected, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Executable failed: %v", err)
+	}
+
+	if u.executable != expected {
+		t.Errorf("expected the identity of the live peer to be read, got executable %q", u.executable)
+	}
+}
@@ -3,8 +3,12 @@ package fake
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
+	"slices"
+	"sync/atomic"
+	"time"
 
 	"google.golang.org/grpc"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
@@ -15,10 +19,24 @@ type Server struct {
 	runtimeapi.RuntimeServiceServer
 	runtimeapi.ImageServiceServer
 
-	containers      []*runtimeapi.Container
-	stats           []*runtimeapi.ContainerStats
-	podSandboxStats []*runtimeapi.PodSandboxStats
-	emittedEvents   []*runtimeapi.ContainerEventResponse
+	containers         []*runtimeapi.Container
+	stats              []*runtimeapi.ContainerStats
+	podSandboxes       []*runtimeapi.PodSandbox
+	podSandboxStats    []*runtimeapi.PodSandboxStats
+	podSandboxMetrics  []*runtimeapi.PodSandboxMetrics
+	emittedEvents      []*runtimeapi.ContainerEventResponse
+	images             []*runtimeapi.Image
+	lastExecSync       *runtimeapi.ExecSyncRequest
+	lastPullImage      *runtimeapi.PullImageRequest
+	lastListPodSandbox *runtimeapi.ListPodSandboxRequest
+	execSyncDelay      time.Duration
+	execSyncResponse   *runtimeapi.ExecSyncResponse
+
+	listContainersCalls  atomic.Int64
+	removeContainerCalls atomic.Int64
+
+	containerResources map[string]*runtimeapi.LinuxContainerResources
+	podSandboxConfigs  map[string]*runtimeapi.PodSandboxConfig
 }
 
 // NewServer creates a new fake CRI server.
@@ -45,6 +63,12 @@ func NewServer(socketPath string) (server *grpc.Server, listener net.Listener, f
 				State: runtimeapi.ContainerState_CONTAINER_RUNNING,
 			},
 		},
+		images: []*runtimeapi.Image{
+			{
+				Id:       "sha256:12345",
+				RepoTags: []string{"fake-image:latest"},
+			},
+		},
 	}
 	grpcServer := grpc.NewServer()
 	runtimeapi.RegisterRuntimeServiceServer(grpcServer, s)
@@ -58,11 +82,26 @@ func (s *Server) SetContainers(containers []*runtimeapi.Container) {
 	s.containers = containers
 }
 
+// SetImages sets the list of images for the fake server.
+func (s *Server) SetImages(images []*runtimeapi.Image) {
+	s.images = images
+}
+
 // SetContainerStats sets the list of container stats for the fake server.
 func (s *Server) SetContainerStats(stats []*runtimeapi.ContainerStats) {
 	s.stats = stats
 }
 
+// SetPodSandboxes sets the list of pod sandboxes for the fake server.
+func (s *Server) SetPodSandboxes(podSandboxes []*runtimeapi.PodSandbox) {
+	s.podSandboxes = podSandboxes
+}
+
+// SetPodSandboxMetrics sets the list of pod sandbox metrics for the fake server.
+func (s *Server) SetPodSandboxMetrics(metrics []*runtimeapi.PodSandboxMetrics) {
+	s.podSandboxMetrics = metrics
+}
+
 // SetPodSandboxStats sets the list of pod sandbox stats for the fake server.
 func (s *Server) SetPodSandboxStats(stats []*runtimeapi.PodSandboxStats) {
 	s.podSandboxStats = stats
@@ -73,6 +112,25 @@ func (s *Server) SetEmittedEvents(events []*runtimeapi.ContainerEventResponse) {
 	s.emittedEvents = events
 }
 
+// SetContainerResources sets the resources reported by ContainerStatus for a container.
+func (s *Server) SetContainerResources(containerID string, resources *runtimeapi.LinuxContainerResources) {
+	if s.containerResources == nil {
+		s.containerResources = make(map[string]*runtimeapi.LinuxContainerResources)
+	}
+
+	s.containerResources[containerID] = resources
+}
+
+// SetPodSandboxConfig sets the config reported in the verbose info of
+// PodSandboxStatus for a pod sandbox, like containerd does.
+func (s *Server) SetPodSandboxConfig(podSandboxID string, config *runtimeapi.PodSandboxConfig) {
+	if s.podSandboxConfigs == nil {
+		s.podSandboxConfigs = make(map[string]*runtimeapi.PodSandboxConfig)
+	}
+
+	s.podSandboxConfigs[podSandboxID] = config
+}
+
 // Version returns a fake version.
 func (s *Server) Version(_ context.Context, _ *runtimeapi.VersionRequest) (*runtimeapi.VersionResponse, error) {
 	return &runtimeapi.VersionResponse{
@@ -85,6 +143,8 @@ func (s *Server) Version(_ context.Context, _ *runtimeapi.VersionRequest) (*runt
 
 // ListContainers returns a fake list of containers.
 func (s *Server) ListContainers(_ context.Context, req *runtimeapi.ListContainersRequest) (*runtimeapi.ListContainersResponse, error) {
+	s.listContainersCalls.Add(1)
+
 	if req.GetFilter() == nil {
 		return &runtimeapi.ListContainersResponse{
 			Containers: s.containers,
@@ -110,8 +170,18 @@ func (s *Server) ListContainers(_ context.Context, req *runtimeapi.ListContainer
 	}, nil
 }
 
+// ListContainersCalls returns the number of ListContainers calls received.
+func (s *Server) ListContainersCalls() int64 {
+	return s.listContainersCalls.Load()
+}
+
 // ContainerStatus returns a fake container status.
 func (s *Server) ContainerStatus(_ context.Context, req *runtimeapi.ContainerStatusRequest) (*runtimeapi.ContainerStatusResponse, error) {
+	var resources *runtimeapi.ContainerResources
+	if linux, ok := s.containerResources[req.GetContainerId()]; ok {
+		resources = &runtimeapi.ContainerResources{Linux: linux}
+	}
+
 	return &runtimeapi.ContainerStatusResponse{
 		Status: &runtimeapi.ContainerStatus{
 			Id: req.GetContainerId(),
@@ -122,7 +192,8 @@ func (s *Server) ContainerStatus(_ context.Context, req *runtimeapi.ContainerSta
 			Image: &runtimeapi.ImageSpec{
 				Image: "test-image",
 			},
-			State: runtimeapi.ContainerState_CONTAINER_RUNNING,
+			State:     runtimeapi.ContainerState_CONTAINER_RUNNING,
+			Resources: resources,
 		},
 	}, nil
 }
@@ -137,12 +208,7 @@ func (s *Server) RunPodSandbox(_ context.Context, _ *runtimeapi.RunPodSandboxReq
 // ListImages returns a fake list of images.
 func (s *Server) ListImages(_ context.Context, _ *runtimeapi.ListImagesRequest) (*runtimeapi.ListImagesResponse, error) {
 	return &runtimeapi.ListImagesResponse{
-		Images: []*runtimeapi.Image{
-			{
-				Id:       "sha256:12345",
-				RepoTags: []string{"fake-image:latest"},
-			},
-		},
+		Images: s.images,
 	}, nil
 }
 
@@ -152,12 +218,19 @@ func (s *Server) ImageFsInfo(_ context.Context, _ *runtimeapi.ImageFsInfoRequest
 }
 
 // PullImage is a fake implementation.
-func (s *Server) PullImage(_ context.Context, _ *runtimeapi.PullImageRequest) (*runtimeapi.PullImageResponse, error) {
+func (s *Server) PullImage(_ context.Context, req *runtimeapi.PullImageRequest) (*runtimeapi.PullImageResponse, error) {
+	s.lastPullImage = req
+
 	return &runtimeapi.PullImageResponse{
 		ImageRef: "sha256:12345",
 	}, nil
 }
 
+// LastPullImageRequest returns the last PullImage request received by the fake server.
+func (s *Server) LastPullImageRequest() *runtimeapi.PullImageRequest {
+	return s.lastPullImage
+}
+
 // Status returns a fake status.
 func (s *Server) Status(_ context.Context, _ *runtimeapi.StatusRequest) (*runtimeapi.StatusResponse, error) {
 	return &runtimeapi.StatusResponse{
@@ -240,21 +313,185 @@ func (s *Server) GetContainerEvents(_ *runtimeapi.GetEventsRequest, stream runti
 }
 
 // ListPodSandbox returns a fake list of pod sandboxes.
-func (s *Server) ListPodSandbox(_ context.Context, _ *runtimeapi.ListPodSandboxRequest) (*runtimeapi.ListPodSandboxResponse, error) {
-	return &runtimeapi.ListPodSandboxResponse{}, nil
+func (s *Server) ListPodSandbox(_ context.Context, req *runtimeapi.ListPodSandboxRequest) (*runtimeapi.ListPodSandboxResponse, error) {
+	s.lastListPodSandbox = req
+
+	if req.GetFilter().GetId() == "" {
+		return &runtimeapi.ListPodSandboxResponse{
+			Items: s.podSandboxes,
+		}, nil
+	}
+
+	var filtered []*runtimeapi.PodSandbox
+
+	for _, podSandbox := range s.podSandboxes {
+		if podSandbox.GetId() == req.GetFilter().GetId() {
+			filtered = append(filtered, podSandbox)
+		}
+	}
+
+	return &runtimeapi.ListPodSandboxResponse{
+		Items: filtered,
+	}, nil
+}
+
+// LastListPodSandboxRequest returns the last ListPodSandbox request received by the fake server.
+func (s *Server) LastListPodSandboxRequest() *runtimeapi.ListPodSandboxRequest {
+	return s.lastListPodSandbox
+}
+
+// ListPodSandboxMetrics returns fake pod sandbox metrics.
+func (s *Server) ListPodSandboxMetrics(_ context.Context, _ *runtimeapi.ListPodSandboxMetricsRequest) (*runtimeapi.ListPodSandboxMetricsResponse, error) {
+	return &runtimeapi.ListPodSandboxMetricsResponse{
+		PodMetrics: s.podSandboxMetrics,
+	}, nil
 }
 
-// PodSandboxStatus returns a fake pod sandbox status.
-func (s *Server) PodSandboxStatus(_ context.Context, _ *runtimeapi.PodSandboxStatusRequest) (*runtimeapi.PodSandboxStatusResponse, error) {
+// PodSandboxStatus returns the status of a pod sandbox set with SetPodSandboxes,
+// or an empty status for unknown pod sandboxes.
+func (s *Server) PodSandboxStatus(_ context.Context, req *runtimeapi.PodSandboxStatusRequest) (*runtimeapi.PodSandboxStatusResponse, error) {
+	for _, podSandbox := range s.podSandboxes {
+		if podSandbox.GetId() == req.GetPodSandboxId() {
+			var info map[string]string
+
+			if config, ok := s.podSandboxConfigs[podSandbox.GetId()]; ok && req.GetVerbose() {
+				data, err := json.Marshal(map[string]interface{}{"config": config})
+				if err != nil {
+					return nil, fmt.Errorf("failed to marshal pod sandbox info: %w", err)
+				}
+
+				info = map[string]string{"info": string(data)}
+			}
+
+			return &runtimeapi.PodSandboxStatusResponse{
+				Info: info,
+				Status: &runtimeapi.PodSandboxStatus{
+					Id:          podSandbox.GetId(),
+					Metadata:    podSandbox.GetMetadata(),
+					State:       podSandbox.GetState(),
+					CreatedAt:   podSandbox.GetCreatedAt(),
+					Labels:      podSandbox.GetLabels(),
+					Annotations: podSandbox.GetAnnotations(),
+				},
+			}, nil
+		}
+	}
+
 	return &runtimeapi.PodSandboxStatusResponse{}, nil
 }
 
-// ImageStatus returns a fake image status.
-func (s *Server) ImageStatus(_ context.Context, _ *runtimeapi.ImageStatusRequest) (*runtimeapi.ImageStatusResponse, error) {
+// ImageStatus returns the status of the image with the requested ID, tag or digest.
+func (s *Server) ImageStatus(_ context.Context, req *runtimeapi.ImageStatusRequest) (*runtimeapi.ImageStatusResponse, error) {
+	name := req.GetImage().GetImage()
+
+	for _, image := range s.images {
+		if image.GetId() == name || slices.Contains(image.GetRepoTags(), name) || slices.Contains(image.GetRepoDigests(), name) {
+			return &runtimeapi.ImageStatusResponse{Image: image}, nil
+		}
+	}
+
 	return &runtimeapi.ImageStatusResponse{}, nil
 }
 
+// RemoveContainer removes a container from the list of containers.
+func (s *Server) RemoveContainer(_ context.Context, req *runtimeapi.RemoveContainerRequest) (*runtimeapi.RemoveContainerResponse, error) {
+	s.removeContainerCalls.Add(1)
+
+	s.containers = slices.DeleteFunc(slices.Clone(s.containers), func(c *runtimeapi.Container) bool {
+		return c.GetId() == req.GetContainerId()
+	})
+
+	return &runtimeapi.RemoveContainerResponse{}, nil
+}
+
+// RemoveContainerCalls returns the number of RemoveContainer calls received.
+func (s *Server) RemoveContainerCalls() int64 {
+	return s.removeContainerCalls.Load()
+}
+
+// RemoveImage is a fake implementation.
+func (s *Server) RemoveImage(_ context.Context, _ *runtimeapi.RemoveImageRequest) (*runtimeapi.RemoveImageResponse, error) {
+	return &runtimeapi.RemoveImageResponse{}, nil
+}
+
 // PortForward is a fake implementation.
 func (s *Server) PortForward(_ context.Context, _ *runtimeapi.PortForwardRequest) (*runtimeapi.PortForwardResponse, error) {
 	return &runtimeapi.PortForwardResponse{}, nil
 }
+
+// Exec is a fake implementation.
+func (s *Server) Exec(_ context.Context, _ *runtimeapi.ExecRequest) (*runtimeapi.ExecResponse, error) {
+	return &runtimeapi.ExecResponse{Url: "http://127.0.0.1:10010/exec/fake"}, nil
+}
+
+// ReopenContainerLog is a fake implementation.
+func (s *Server) ReopenContainerLog(_ context.Context, _ *runtimeapi.ReopenContainerLogRequest) (*runtimeapi.ReopenContainerLogResponse, error) {
+	return &runtimeapi.ReopenContainerLogResponse{}, nil
+}
+
+// RuntimeConfig is a fake implementation.
+func (s *Server) RuntimeConfig(_ context.Context, _ *runtimeapi.RuntimeConfigRequest) (*runtimeapi.RuntimeConfigResponse, error) {
+	return &runtimeapi.RuntimeConfigResponse{}, nil
+}
+
+// ListMetricDescriptors is a fake implementation.
+func (s *Server) ListMetricDescriptors(_ context.Context, _ *runtimeapi.ListMetricDescriptorsRequest) (*runtimeapi.ListMetricDescriptorsResponse, error) {
+	return &runtimeapi.ListMetricDescriptorsResponse{}, nil
+}
+
+// CreateContainer is a fake implementation.
+func (s *Server) CreateContainer(_ context.Context, req *runtimeapi.CreateContainerRequest) (*runtimeapi.CreateContainerResponse, error) {
+	return &runtimeapi.CreateContainerResponse{ContainerId: req.GetConfig().GetMetadata().GetName() + "-id"}, nil
+}
+
+// ExecSync is a fake implementation. It returns the response set with
+// SetExecSyncResponse after the delay set with SetExecSyncDelay.
+func (s *Server) ExecSync(ctx context.Context, req *runtimeapi.ExecSyncRequest) (*runtimeapi.ExecSyncResponse, error) {
+	s.lastExecSync = req
+
+	select {
+	case <-time.After(s.execSyncDelay):
+	case <-ctx.Done():
+		return nil, fmt.Errorf("exec sync canceled: %w", ctx.Err())
+	}
+
+	if s.execSyncResponse != nil {
+		return s.execSyncResponse, nil
+	}
+
+	return &runtimeapi.ExecSyncResponse{}, nil
+}
+
+// SetExecSyncResponse sets the response of ExecSync.
+func (s *Server) SetExecSyncResponse(resp *runtimeapi.ExecSyncResponse) {
+	s.execSyncResponse = resp
+}
+
+// SetExecSyncDelay sets how long ExecSync takes to return.
+func (s *Server) SetExecSyncDelay(delay time.Duration) {
+	s.execSyncDelay = delay
+}
+
+// LastExecSyncRequest returns the last ExecSync request received by the fake server.
+func (s *Server) LastExecSyncRequest() *runtimeapi.ExecSyncRequest {
+	return s.lastExecSync
+}
+
+// UpdateContainerResources is a fake implementation.
+func (s *Server) UpdateContainerResources(_ context.Context, req *runtimeapi.UpdateContainerResourcesRequest) (*runtimeapi.UpdateContainerResourcesResponse, error) {
+	s.SetContainerResources(req.GetContainerId(), req.GetLinux())
+
+	return &runtimeapi.UpdateContainerResourcesResponse{}, nil
+}
+
+// UpdatePodSandboxResources is a fake implementation.
+func (s *Server) UpdatePodSandboxResources(_ context.Context, req *runtimeapi.UpdatePodSandboxResourcesRequest) (*runtimeapi.UpdatePodSandboxResourcesResponse, error) {
+	s.SetPodSandboxConfig(req.GetPodSandboxId(), &runtimeapi.PodSandboxConfig{
+		Linux: &runtimeapi.LinuxPodSandboxConfig{
+			Overhead:  req.GetOverhead(),
+			Resources: req.GetResources(),
+		},
+	})
+
+	return &runtimeapi.UpdatePodSandboxResourcesResponse{}, nil
+}
@@ -0,0 +1,115 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+// PolicyAnnotation is the pod annotation listing the policies a pod is
+// authorized to use, as a comma-separated list of policy names.
+const PolicyAnnotation = "cri-lite.io/policy"
+
+var ErrNotAuthorizedByAnnotation = errors.New("pod is not authorized to use policy")
+
+// annotationAuthorizedPolicy only lets a request reach the wrapped policy if
+// the pod of the caller is annotated with the name of the policy.
+type annotationAuthorizedPolicy struct {
+	policy        Policy
+	name          string
+	runtimeClient runtimeapi.RuntimeServiceClient
+}
+
+// NewAnnotationAuthorizedPolicy wraps a policy so that it can only be used by
+// pods whose PolicyAnnotation contains name.
+func NewAnnotationAuthorizedPolicy(name string, p Policy, runtimeClient runtimeapi.RuntimeServiceClient) Policy {
+	return &annotationAuthorizedPolicy{
+		policy:        p,
+		name:          name,
+		runtimeClient: runtimeClient,
+	}
+}
+
+// Name implements the Policy interface.
+func (p *annotationAuthorizedPolicy) Name() string {
+	return p.policy.Name()
+}
+
+// UnaryInterceptor implements the Policy interface.
+func (p *annotationAuthorizedPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	next := p.policy.UnaryInterceptor()
+
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		err := p.authorize(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return next(ctx, req, info, handler)
+	}
+}
+
+// StreamInterceptor implements the Policy interface.
+func (p *annotationAuthorizedPolicy) StreamInterceptor() grpc.StreamServerInterceptor {
+	next := p.policy.StreamInterceptor()
+
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		err := p.authorize(ss.Context())
+		if err != nil {
+			return err
+		}
+
+		return next(srv, ss, info, handler)
+	}
+}
+
+// TODO: when it will become a problem we should cache the annotations of the pod.
+func (p *annotationAuthorizedPolicy) authorize(ctx context.Context) error {
+	logger := klog.FromContext(ctx)
+
+	pid, err := callerPID(ctx)
+	if err != nil {
+		return err
+	}
+
+	podSandboxID, err := podSandboxIDFromPID(ctx, p.runtimeClient, pid)
+	if err != nil {
+		logger.V(4).Info("failed to get pod sandbox ID of caller", "pid", pid, "err", err)
+
+		return newError(codes.PermissionDenied, ReasonNotAuthorizedByAnnotation, nil, "%s: %s", ErrNotAuthorizedByAnnotation, p.name)
+	}
+
+	resp, err := p.runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{
+		PodSandboxId: podSandboxID,
+	})
+	if err != nil {
+		return newError(codes.Internal, ReasonRuntimeLookupFailed, nil, "failed to get pod sandbox status: %v", err)
+	}
+
+	annotation := resp.GetStatus().GetAnnotations()[PolicyAnnotation]
+	for _, name := range strings.Split(annotation, ",") {
+		if p.name != "" && strings.TrimSpace(name) == p.name {
+			return nil
+		}
+	}
+
+	logger.V(4).Info("pod is not annotated with policy", "podSandboxID", podSandboxID, "policy", p.name, "annotation", annotation)
+
+	return newError(codes.PermissionDenied, ReasonNotAuthorizedByAnnotation, nil, "%s: %s", ErrNotAuthorizedByAnnotation, p.name)
+}
@@ -0,0 +1,92 @@
+package policy_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/fake"
+	"cri-lite/pkg/policy"
+	"cri-lite/pkg/proxy"
+)
+
+var errNotInPod = errors.New("caller is not in a pod")
+
+var _ = Describe("Annotation Authorized Policy", func() {
+	var (
+		runtimeClient runtimeapi.RuntimeServiceClient
+		cleanup       func()
+		callerPod     string
+		restore       func()
+	)
+
+	BeforeEach(func() {
+		callerPod = ""
+		restore = policy.SetPodSandboxIDFromPID(func(context.Context, runtimeapi.RuntimeServiceClient, int32) (string, error) {
+			if callerPod == "" {
+				return "", errNotInPod
+			}
+
+			return callerPod, nil
+		})
+
+		var mock *fake.Server
+
+		runtimeClient, _, mock, cleanup = setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+			return []policy.Policy{policy.NewAnnotationAuthorizedPolicy("ReadOnly", policy.NewReadOnlyPolicy(), proxyServer.GetRuntimeClient())}
+		})
+
+		mock.SetPodSandboxes([]*runtimeapi.PodSandbox{
+			{Id: "authorized-pod", Annotations: map[string]string{policy.PolicyAnnotation: "ImageManagement, ReadOnly"}},
+			{Id: "other-policy-pod", Annotations: map[string]string{policy.PolicyAnnotation: "ImageManagement"}},
+			{Id: "unannotated-pod"},
+		})
+	})
+
+	AfterEach(func() {
+		restore()
+		cleanup()
+	})
+
+	It("should let pods annotated with the policy through to the policy", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		callerPod = "authorized-pod"
+
+		_, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+		Expect(err).NotTo(HaveOccurred())
+
+		By("still enforcing the policy")
+		_, err = runtimeClient.StopContainer(ctx, &runtimeapi.StopContainerRequest{ContainerId: "container-id"})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		Expect(err.Error()).To(ContainSubstring("method not allowed by policy"))
+	})
+
+	DescribeTable("should deny callers not authorized by annotation",
+		func(pod string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			callerPod = pod
+
+			_, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(err.Error()).To(ContainSubstring(policy.ErrNotAuthorizedByAnnotation.Error()))
+
+			stream, err := runtimeClient.GetContainerEvents(ctx, &runtimeapi.GetEventsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = stream.Recv()
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		},
+		Entry("a pod annotated with another policy", "other-policy-pod"),
+		Entry("a pod without the annotation", "unannotated-pod"),
+		Entry("a process outside of a pod", ""),
+	)
+})
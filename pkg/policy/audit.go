@@ -0,0 +1,281 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"expvar"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/klog/v2"
+)
+
+// auditDenials counts the denials of the audited policies of all endpoints,
+// keyed by endpoint, policy and reason. It is published with expvar.
+var auditDenials = expvar.NewMap("auditDenials")
+
+// auditKey identifies a kind of denial of an audited policy.
+type auditKey struct {
+	method string
+	reason string
+}
+
+// auditPolicy evaluates a policy without enforcing it: the denials, request
+// changes and response filters of the policy are logged, and the requests
+// and responses are passed through unchanged.
+type auditPolicy struct {
+	endpoint string
+	policy   Policy
+
+	mu      sync.Mutex
+	denials map[auditKey]int
+}
+
+// NewAuditPolicy wraps a policy of an endpoint so that it is evaluated but
+// not enforced.
+func NewAuditPolicy(endpoint string, p Policy) Policy {
+	return &auditPolicy{
+		endpoint: endpoint,
+		policy:   p,
+		denials:  make(map[auditKey]int),
+	}
+}
+
+// Name implements the Policy interface.
+func (p *auditPolicy) Name() string {
+	return p.policy.Name() + " (audit)"
+}
+
+// UnaryInterceptor implements the Policy interface. The wrapped policy sees a
+// copy of the request, and a copy of the response of the next handler.
+func (p *auditPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	next := p.policy.UnaryInterceptor()
+
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		var (
+			called     bool
+			resp       interface{}
+			handlerErr error
+		)
+
+		audited, err := next(ctx, cloneMessage(req), info, func(_ context.Context, auditedReq interface{}) (interface{}, error) {
+			called = true
+
+			if !messagesEqual(auditedReq, req) {
+				klog.FromContext(ctx).Info("policy would modify request", "policy", p.policy.Name(), "method", info.FullMethod)
+			}
+
+			resp, handlerErr = handler(ctx, req)
+			if handlerErr != nil {
+				return nil, handlerErr
+			}
+
+			return cloneMessage(resp), nil
+		})
+
+		switch {
+		case !called:
+			p.deny(ctx, info.FullMethod, err)
+
+			return handler(ctx, req)
+		case handlerErr != nil:
+			return nil, handlerErr
+		case err != nil:
+			p.deny(ctx, info.FullMethod, err)
+		case !messagesEqual(audited, resp):
+			klog.FromContext(ctx).Info("policy would filter response", "policy", p.policy.Name(), "method", info.FullMethod)
+		}
+
+		return resp, nil
+	}
+}
+
+// StreamInterceptor implements the Policy interface. The wrapped policy sees
+// copies of the messages of the stream, and the messages it would send are
+// discarded.
+func (p *auditPolicy) StreamInterceptor() grpc.StreamServerInterceptor {
+	next := p.policy.StreamInterceptor()
+
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		var (
+			called     bool
+			handlerErr error
+		)
+
+		sink := &auditSink{ServerStream: ss}
+		stream := &auditedStream{
+			ServerStream: ss,
+			sink:         sink,
+			policy:       p,
+			method:       info.FullMethod,
+		}
+
+		err := next(srv, sink, info, func(srv interface{}, audited grpc.ServerStream) error {
+			called = true
+			stream.audited = audited
+			handlerErr = handler(srv, stream)
+
+			return handlerErr
+		})
+
+		switch {
+		case !called:
+			p.deny(ss.Context(), info.FullMethod, err)
+
+			return handler(srv, ss)
+		case handlerErr == nil && err != nil:
+			stream.deny(err)
+		}
+
+		return handlerErr
+	}
+}
+
+// deny logs and counts a denial of the audited policy.
+func (p *auditPolicy) deny(ctx context.Context, method string, err error) {
+	st := status.Convert(err)
+	message := st.Message()
+	// Denials are formatted as "<error>: <details>", so the error is the
+	// reason and the details are left out of the counters.
+	reason, _, _ := strings.Cut(message, ": ")
+
+	p.mu.Lock()
+	key := auditKey{method: method, reason: reason}
+	p.denials[key]++
+	count := p.denials[key]
+	p.mu.Unlock()
+
+	// The published counters use the reason of the ErrorInfo, which does not
+	// depend on the wording of the error.
+	if info := errorInfo(st); info != nil {
+		reason = info.GetReason()
+	}
+
+	auditDenials.Add(auditDenialsKey(p.endpoint, p.policy.Name(), reason), 1)
+
+	klog.FromContext(ctx).Info("policy would deny request", "policy", p.policy.Name(), "method", method, "reason", message, "count", count)
+}
+
+// auditDenialsKey returns the key of the denials of a policy of an endpoint
+// for a reason in auditDenials.
+func auditDenialsKey(endpoint, policy, reason string) string {
+	return "endpoint=" + endpoint + ",policy=" + policy + ",reason=" + reason
+}
+
+// auditedStream passes the messages of a stream through, after they went
+// through the streams of the audited policy. Once the policy denied the
+// stream, which would have ended it, its messages are no longer audited.
+type auditedStream struct {
+	grpc.ServerStream
+
+	audited grpc.ServerStream
+	sink    *auditSink
+	policy  *auditPolicy
+	method  string
+	denied  atomic.Bool
+}
+
+// deny records the first denial of the stream.
+func (s *auditedStream) deny(err error) {
+	if s.denied.Swap(true) {
+		return
+	}
+
+	s.policy.deny(s.Context(), s.method, err)
+}
+
+func (s *auditedStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil || s.denied.Load() {
+		return err
+	}
+
+	s.sink.received = m
+
+	err = s.audited.RecvMsg(cloneMessage(m))
+	if err != nil {
+		s.deny(err)
+	}
+
+	return nil
+}
+
+func (s *auditedStream) SendMsg(m interface{}) error {
+	if s.denied.Load() {
+		return s.ServerStream.SendMsg(m)
+	}
+
+	s.sink.sent = false
+
+	err := s.audited.SendMsg(cloneMessage(m))
+	if err != nil {
+		s.deny(err)
+	} else if !s.sink.sent {
+		klog.FromContext(s.Context()).Info("policy would filter message", "policy", s.policy.policy.Name(), "method", s.method)
+	}
+
+	return s.ServerStream.SendMsg(m)
+}
+
+// auditSink is the stream below the streams of the audited policy. It records
+// the messages that the policy lets through instead of sending them, and
+// replays the messages received by the auditedStream.
+type auditSink struct {
+	grpc.ServerStream
+
+	received interface{}
+	sent     bool
+}
+
+func (s *auditSink) RecvMsg(m interface{}) error {
+	dst, ok := m.(proto.Message)
+	src, isMessage := s.received.(proto.Message)
+
+	if ok && isMessage {
+		proto.Reset(dst)
+		proto.Merge(dst, src)
+	}
+
+	return nil
+}
+
+func (s *auditSink) SendMsg(interface{}) error {
+	s.sent = true
+
+	return nil
+}
+
+// cloneMessage returns a deep copy of a protobuf message, or m itself if it is
+// not one.
+func cloneMessage(m interface{}) interface{} {
+	if message, ok := m.(proto.Message); ok {
+		return proto.Clone(message)
+	}
+
+	return m
+}
+
+func messagesEqual(a, b interface{}) bool {
+	x, ok := a.(proto.Message)
+	y, isMessage := b.(proto.Message)
+
+	if !ok || !isMessage {
+		return a == b
+	}
+
+	return proto.Equal(x, y)
+}
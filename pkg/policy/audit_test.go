@@ -0,0 +1,130 @@
+package policy_test
+
+import (
+	"context"
+	"io"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/fake"
+	"cri-lite/pkg/policy"
+	"cri-lite/pkg/proxy"
+)
+
+var _ = Describe("Audit Policy", func() {
+	var (
+		mock          *fake.Server
+		runtimeClient runtimeapi.RuntimeServiceClient
+		imageClient   runtimeapi.ImageServiceClient
+		cleanup       func()
+		newPolicy     func(runtimeapi.RuntimeServiceClient) policy.Policy
+		p             policy.Policy
+	)
+
+	JustBeforeEach(func() {
+		runtimeClient, imageClient, mock, cleanup = setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+			p = policy.NewAuditPolicy("audit.sock", newPolicy(proxyServer.GetRuntimeClient()))
+
+			return []policy.Policy{p}
+		})
+
+		mock.SetContainers([]*runtimeapi.Container{
+			{Id: "own-container", PodSandboxId: "own-pod"},
+			{Id: "other-container", PodSandboxId: "other-pod"},
+		})
+		mock.SetEmittedEvents([]*runtimeapi.ContainerEventResponse{
+			{ContainerId: "own-container"},
+			{ContainerId: "other-container"},
+		})
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	Context("with a PodScoped policy", func() {
+		BeforeEach(func() {
+			newPolicy = func(runtimeClient runtimeapi.RuntimeServiceClient) policy.Policy {
+				return policy.NewPodScopedPolicy("own-pod", false, runtimeClient)
+			}
+		})
+
+		It("should forward and count the calls the policy denies", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			// The published counters are shared by the specs.
+			notAllowed := policy.PublishedAuditDenials("audit.sock", "podScoped", policy.ReasonMethodNotAllowed)
+			notInPod := policy.PublishedAuditDenials("audit.sock", "podScoped", policy.ReasonContainerNotInPod)
+
+			for range 2 {
+				_, err := imageClient.ListImages(ctx, &runtimeapi.ListImagesRequest{})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			Expect(policy.AuditDenials(p, "/runtime.v1.ImageService/ListImages", policy.ErrMethodNotAllowed.Error())).To(Equal(2))
+
+			resp, err := runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: "other-container"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetStatus().GetId()).To(Equal("other-container"))
+			Expect(policy.AuditDenials(p, "/runtime.v1.RuntimeService/ContainerStatus", policy.ErrMethodNotAllowed.Error())).To(Equal(1))
+			Expect(policy.PublishedAuditDenials("audit.sock", "podScoped", policy.ReasonMethodNotAllowed)).To(Equal(notAllowed + 2))
+			Expect(policy.PublishedAuditDenials("audit.sock", "podScoped", policy.ReasonContainerNotInPod)).To(Equal(notInPod + 1))
+		})
+
+		It("should not filter the responses", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			resp, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetContainers()).To(HaveLen(2))
+
+			stream, err := runtimeClient.GetContainerEvents(ctx, &runtimeapi.GetEventsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, id := range []string{"own-container", "other-container"} {
+				event, err := stream.Recv()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(event.GetContainerId()).To(Equal(id))
+			}
+
+			_, err = stream.Recv()
+			Expect(err).To(MatchError(io.EOF))
+		})
+	})
+
+	Context("with a declarative policy denying streams", func() {
+		BeforeEach(func() {
+			newPolicy = func(runtimeClient runtimeapi.RuntimeServiceClient) policy.Policy {
+				p, err := policy.NewDeclarativePolicy("version-only", []policy.Rule{
+					{Method: "/runtime.v1.RuntimeService/Version", Action: policy.ActionAllow},
+				}, runtimeClient)
+				Expect(err).NotTo(HaveOccurred())
+
+				return p
+			}
+		})
+
+		It("should forward the messages of the stream and count its denial once", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			stream, err := runtimeClient.GetContainerEvents(ctx, &runtimeapi.GetEventsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			for range 2 {
+				_, err := stream.Recv()
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			_, err = stream.Recv()
+			Expect(err).To(MatchError(io.EOF))
+			// The stream is denied once, not for every message.
+			Expect(policy.AuditDenials(p, "/runtime.v1.RuntimeService/GetContainerEvents", policy.ErrMethodNotAllowed.Error())).To(Equal(1))
+		})
+	})
+})
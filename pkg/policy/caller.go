@@ -0,0 +1,162 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+// peerCredentials is the auth info attached to connections by creds.PIDCreds.
+type peerCredentials interface {
+	GetPID() int32
+	GetUID() uint32
+	GetGID() uint32
+	GetGroups() []uint32
+	GetNamespaceUID() uint32
+	GetNamespaceGID() uint32
+	GetExecutable() string
+	GetExecutableDigest() string
+	GetUnit() string
+}
+
+// callerCredentials returns the credentials of the process on the other side of the connection.
+func callerCredentials(ctx context.Context) (peerCredentials, error) {
+	peerInfo, isPeer := peer.FromContext(ctx)
+	if !isPeer {
+		return nil, newError(codes.InvalidArgument, ReasonCallerUnknown, nil, "failed to get peer from context")
+	}
+
+	authInfo, ok := peerInfo.AuthInfo.(peerCredentials)
+	if !ok {
+		return nil, newError(codes.InvalidArgument, ReasonCallerUnknown, nil, "failed to get auth info from context")
+	}
+
+	return authInfo, nil
+}
+
+// callerPID returns the PID of the process on the other side of the connection.
+func callerPID(ctx context.Context) (int32, error) {
+	authInfo, err := callerCredentials(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return authInfo.GetPID(), nil
+}
+
+// podSandboxIDFromPID maps the PID of a caller to its pod sandbox. Tests replace
+// it as they do not run in a pod.
+var podSandboxIDFromPID = getPodSandboxIDFromPID
+
+// TODO: when it will become a problem we should add caching here.
+func getPodSandboxIDFromPID(ctx context.Context, runtimeClient runtimeapi.RuntimeServiceClient, pid int32) (string, error) {
+	klog.FromContext(ctx).V(4).Info("mapping pid to sandbox id", "pid", pid)
+
+	containerID, err := containerIDFromPID(ctx, pid)
+	if err != nil {
+		return "", err
+	}
+
+	podSandboxID, err := getPodSandboxIDFromContainerID(ctx, runtimeClient, containerID)
+	if err != nil {
+		return "", &containerLookupError{containerID: containerID, err: err}
+	}
+
+	return podSandboxID, nil
+}
+
+// containerLookupError is returned when the container of a caller is known but
+// its pod sandbox could not be looked up.
+type containerLookupError struct {
+	containerID string
+	err         error
+}
+
+func (e *containerLookupError) Error() string {
+	return fmt.Sprintf("container %s: %v", e.containerID, e.err)
+}
+
+func (e *containerLookupError) Unwrap() error {
+	return e.err
+}
+
+// pidResolutionError returns the error of failing to map the PID of a caller
+// to its pod sandbox, naming the container of the caller when it is known.
+func pidResolutionError(err error) error {
+	var metadata map[string]string
+
+	var lookupErr *containerLookupError
+	if errors.As(err, &lookupErr) {
+		metadata = map[string]string{MetadataContainerID: lookupErr.containerID}
+	}
+
+	return newError(codes.Internal, ReasonPIDResolutionFailed, metadata, "%s: %v", ErrPIDResolutionFailed, err)
+}
+
+// containerIDFromPID maps the PID of a caller to its container. Tests replace
+// it as they do not run in a container.
+var containerIDFromPID = getContainerIDFromPID
+
+func getContainerIDFromPID(ctx context.Context, pid int32) (string, error) {
+	logger := klog.FromContext(ctx)
+
+	cgroupFile, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", fmt.Errorf("failed to open cgroup file: %w", err)
+	}
+
+	defer func() {
+		err := cgroupFile.Close()
+		if err != nil {
+			logger.Error(err, "failed to close cgroup file")
+		}
+	}()
+
+	scanner := bufio.NewScanner(cgroupFile)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// This regex is designed to extract a container ID from a cgroup line.
+		r := regexp.MustCompile(`([0-9a-f]{64})`)
+
+		matches := r.FindStringSubmatch(line)
+		if len(matches) == 2 {
+			containerID := matches[1]
+			logger.V(4).Info("found container id for pid", "containerID", containerID, "pid", pid)
+
+			return containerID, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read cgroup file: %w", err)
+	}
+
+	return "", fmt.Errorf("failed to find container ID for pid %d", pid)
+}
+
+// TODO: when it will become a problem we should add caching here.
+func getPodSandboxIDFromContainerID(ctx context.Context, runtimeClient runtimeapi.RuntimeServiceClient, containerID string) (string, error) {
+	resp, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{
+		Filter: &runtimeapi.ContainerFilter{
+			Id: containerID,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	if len(resp.GetContainers()) != 1 {
+		return "", fmt.Errorf("%w: expected 1, got %d", ErrUnexpectedNumberOfContainers, len(resp.GetContainers()))
+	}
+
+	return resp.GetContainers()[0].GetPodSandboxId(), nil
+}
@@ -0,0 +1,96 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"k8s.io/klog/v2"
+)
+
+// ErrCallerNotAllowed is the error of the calls of callers whose UID and GIDs
+// are not allowed.
+var ErrCallerNotAllowed = errors.New("caller not allowed")
+
+// callerIDPolicy allows the calls of callers with an allowed UID, or with an
+// allowed primary or supplementary GID. The IDs are the IDs of the callers as
+// seen by cri-lite, not in their own user namespace.
+type callerIDPolicy struct {
+	uids []uint32
+	gids []uint32
+}
+
+// NewCallerIDPolicy creates a policy allowing the callers whose UID is one of
+// uids, or whose primary or supplementary GID is one of gids.
+func NewCallerIDPolicy(uids, gids []uint32) Policy {
+	return &callerIDPolicy{
+		uids: uids,
+		gids: gids,
+	}
+}
+
+// Name implements the Policy interface.
+func (p *callerIDPolicy) Name() string {
+	return "callerID"
+}
+
+// UnaryInterceptor implements the Policy interface.
+func (p *callerIDPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		err := p.verifyCaller(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor implements the Policy interface.
+func (p *callerIDPolicy) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		err := p.verifyCaller(ss.Context())
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+func (p *callerIDPolicy) verifyCaller(ctx context.Context) error {
+	authInfo, err := callerCredentials(ctx)
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(p.uids, authInfo.GetUID()) || slices.Contains(p.gids, authInfo.GetGID()) {
+		return nil
+	}
+
+	for _, gid := range authInfo.GetGroups() {
+		if slices.Contains(p.gids, gid) {
+			return nil
+		}
+	}
+
+	klog.FromContext(ctx).V(4).Info("caller not allowed", "pid", authInfo.GetPID(), "uid", authInfo.GetUID(), "gid", authInfo.GetGID())
+
+	return newError(codes.PermissionDenied, ReasonCallerNotAllowed, map[string]string{
+		MetadataUID: strconv.FormatUint(uint64(authInfo.GetUID()), 10),
+	}, "%s: uid %d, gid %d", ErrCallerNotAllowed, authInfo.GetUID(), authInfo.GetGID())
+}
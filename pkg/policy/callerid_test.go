@@ -0,0 +1,125 @@
+package policy_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/policy"
+)
+
+var _ = Describe("CallerID Policy", func() {
+	var (
+		runtimeClient runtimeapi.RuntimeServiceClient
+		cleanup       func()
+		policies      []policy.Policy
+	)
+
+	JustBeforeEach(func() {
+		runtimeClient, _, _, cleanup = setupTestEnvironment(withPolicies(policies...))
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	Context("with the UID of the caller", func() {
+		BeforeEach(func() {
+			policies = []policy.Policy{policy.NewCallerIDPolicy([]uint32{uint32(os.Getuid())}, nil)}
+		})
+
+		It("should allow the caller", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			stream, err := runtimeClient.GetContainerEvents(ctx, &runtimeapi.GetEventsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = stream.Recv()
+			Expect(err).To(MatchError(io.EOF))
+		})
+	})
+
+	Context("with the GID of the caller", func() {
+		BeforeEach(func() {
+			policies = []policy.Policy{policy.NewCallerIDPolicy([]uint32{uint32(os.Getuid()) + 1}, []uint32{uint32(os.Getgid())})}
+		})
+
+		It("should allow the caller", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("with other IDs", func() {
+		BeforeEach(func() {
+			policies = []policy.Policy{
+				policy.NewCallerIDPolicy([]uint32{uint32(os.Getuid()) + 1}, []uint32{uint32(os.Getgid()) + 1}),
+				policy.NewReadOnlyPolicy(),
+			}
+		})
+
+		It("should deny the caller before the other policies", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(errors.Is(policy.FromError(err), policy.ErrCallerNotAllowed)).To(BeTrue())
+
+			stream, err := runtimeClient.GetContainerEvents(ctx, &runtimeapi.GetEventsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = stream.Recv()
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+	})
+
+	Context("with a declarative policy on the IDs of the caller", func() {
+		BeforeEach(func() {
+			p, err := policy.NewDeclarativePolicy("ids", []policy.Rule{
+				{
+					Method: "/runtime.v1.RuntimeService/Version",
+					Action: policy.ActionAllow,
+					Conditions: []policy.Condition{
+						{Expression: fmt.Sprintf("caller.namespaceUid == %d && caller.namespaceGid == %d", os.Getuid(), os.Getgid())},
+					},
+				},
+				{
+					Method: "/runtime.v1.RuntimeService/Status",
+					Action: policy.ActionAllow,
+					Conditions: []policy.Condition{
+						{Expression: "caller.groups.exists(g, g == 4294967294)"},
+					},
+				},
+			}, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			policies = []policy.Policy{p}
+		})
+
+		It("should evaluate the IDs of the caller", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = runtimeClient.Status(ctx, &runtimeapi.StatusRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+	})
+})
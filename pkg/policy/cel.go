@@ -0,0 +1,164 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/ext"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// Names of the variables available to CEL expressions.
+const (
+	celRequestVariable = "request"
+	celCallerVariable  = "caller"
+	celMethodVariable  = "method"
+)
+
+// Caller is the identity of the process calling cri-lite, exposed to CEL
+// expressions as the caller variable. UID, GID and Groups are the IDs seen by
+// cri-lite, while NamespaceUID and NamespaceGID are the IDs of the caller in
+// its own user namespace. Executable, ExecutableDigest and Unit identify the
+// callers running on the host, see Executable. Executable and ExecutableDigest
+// are empty for the callers in another mount namespace than cri-lite.
+type Caller struct {
+	PID              int64             `cel:"pid"`
+	UID              int64             `cel:"uid"`
+	GID              int64             `cel:"gid"`
+	Groups           []int64           `cel:"groups"`
+	NamespaceUID     int64             `cel:"namespaceUid"`
+	NamespaceGID     int64             `cel:"namespaceGid"`
+	Executable       string            `cel:"executable"`
+	ExecutableDigest string            `cel:"executableDigest"`
+	Unit             string            `cel:"unit"`
+	PodSandboxID     string            `cel:"podSandboxId"`
+	PodLabels        map[string]string `cel:"podLabels"`
+}
+
+// celEnv is the environment shared by all expressions. The request variable
+// is declared per rule, since its type depends on the method.
+var celEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.TypeDescs(runtimeapi.File_staging_src_k8s_io_cri_api_pkg_apis_runtime_v1_api_proto),
+		ext.NativeTypes(reflect.TypeFor[Caller](), ext.ParseStructTags(true)),
+		cel.Variable(celCallerVariable, cel.ObjectType("policy.Caller")),
+		cel.Variable(celMethodVariable, cel.StringType),
+	)
+})
+
+// expression is a compiled CEL condition.
+type expression struct {
+	program cel.Program
+	// needsPod is set when the expression reads the pod of the caller,
+	// which takes calls to the runtime to resolve.
+	needsPod bool
+	// needsDigest is set when the expression reads the digest of the
+	// executable of the caller, which takes reading the executable.
+	needsDigest bool
+}
+
+// compileExpression type-checks a CEL condition against the request type of
+// the rule. The request is dynamically typed when input is nil, e.g. for rules
+// matching several methods.
+func compileExpression(source string, input protoreflect.MessageDescriptor) (*expression, error) {
+	env, err := celEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
+	}
+
+	requestType := cel.DynType
+	if input != nil {
+		requestType = cel.ObjectType(string(input.FullName()))
+	}
+
+	env, err = env.Extend(cel.Variable(celRequestVariable, requestType))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
+	}
+
+	checked, issues := env.Compile(source)
+	if issues.Err() != nil {
+		return nil, fmt.Errorf("%w: expression %q: %w", ErrInvalidRule, source, issues.Err())
+	}
+
+	if checked.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("%w: expression %q must evaluate to bool, got %s", ErrInvalidRule, source, checked.OutputType())
+	}
+
+	program, err := env.Program(checked)
+	if err != nil {
+		return nil, fmt.Errorf("%w: expression %q: %w", ErrInvalidRule, source, err)
+	}
+
+	return &expression{
+		program:     program,
+		needsPod:    referencesPod(checked),
+		needsDigest: referencesCallerField(checked, "executableDigest"),
+	}, nil
+}
+
+// referencesPod reports whether an expression uses anything of the caller
+// beyond its process credentials.
+func referencesPod(checked *cel.Ast) bool {
+	root := ast.NavigateAST(checked.NativeRep())
+
+	for _, ident := range ast.MatchDescendants(root, ast.KindMatcher(ast.IdentKind)) {
+		if ident.AsIdent() != celCallerVariable {
+			continue
+		}
+
+		parent, ok := ident.Parent()
+		if !ok || parent.Kind() != ast.SelectKind {
+			return true
+		}
+
+		switch parent.AsSelect().FieldName() {
+		case "pid", "uid", "gid", "groups", "namespaceUid", "namespaceGid", "executable", "executableDigest", "unit":
+		default:
+			return true
+		}
+	}
+
+	return false
+}
+
+// referencesCallerField reports whether an expression reads a field of the
+// caller, or the whole caller.
+func referencesCallerField(checked *cel.Ast, field string) bool {
+	root := ast.NavigateAST(checked.NativeRep())
+
+	for _, ident := range ast.MatchDescendants(root, ast.KindMatcher(ast.IdentKind)) {
+		if ident.AsIdent() != celCallerVariable {
+			continue
+		}
+
+		parent, ok := ident.Parent()
+		if !ok || parent.Kind() != ast.SelectKind || parent.AsSelect().FieldName() == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// eval evaluates the expression for a request made by caller.
+func (x *expression) eval(ctx context.Context, method string, req interface{}, caller *Caller) (bool, error) {
+	out, _, err := x.program.ContextEval(ctx, map[string]interface{}{
+		celRequestVariable: req,
+		celCallerVariable:  caller,
+		celMethodVariable:  method,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	result, ok := out.Value().(bool)
+
+	return ok && result, nil
+}
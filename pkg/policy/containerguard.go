@@ -0,0 +1,394 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+var (
+	ErrInvalidContainerGuard     = errors.New("invalid container guard")
+	ErrContainerConfigNotAllowed = errors.New("container config not allowed")
+)
+
+// Guardrail profiles of the ContainerGuard policy, modeled after the Pod
+// Security Standards.
+const (
+	// ProfileBaseline prevents known privilege escalations.
+	ProfileBaseline = "baseline"
+	// ProfileRestricted additionally enforces hardening best practices.
+	ProfileRestricted = "restricted"
+)
+
+// baselineCapabilities are the capabilities that the baseline Pod Security
+// Standard allows to add.
+var baselineCapabilities = []string{
+	"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD",
+	"NET_BIND_SERVICE", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+}
+
+// restrictedCapabilities are the capabilities that the restricted Pod
+// Security Standard allows to add.
+var restrictedCapabilities = []string{"NET_BIND_SERVICE"}
+
+// baselineSELinuxTypes are the SELinux types that the baseline Pod Security
+// Standard allows.
+var baselineSELinuxTypes = []string{"", "container_t", "container_init_t", "container_kvm_t", "container_engine_t"}
+
+// ContainerGuard is the configuration of the ContainerGuard policy.
+type ContainerGuard struct {
+	// Profile is either ProfileBaseline or ProfileRestricted. It defaults to
+	// ProfileRestricted.
+	Profile string `yaml:"profile,omitempty"`
+	// AllowedHostPaths are the host paths, and their subdirectories, that can be mounted.
+	AllowedHostPaths []string `yaml:"allowed-host-paths,omitempty"`
+	// AllowedDevices are the host paths of the devices that can be added.
+	AllowedDevices []string `yaml:"allowed-devices,omitempty"`
+	// AllowedCDIDevices are the fully qualified names of the CDI devices,
+	// e.g. vendor.com/gpu=0, that can be requested, whether in the CDI
+	// devices of the config or in cdi.k8s.io/ annotations. CDI devices can
+	// add host devices, mounts and hooks to the container.
+	AllowedCDIDevices []string `yaml:"allowed-cdi-devices,omitempty"`
+	// AllowedCapabilities are added to the capabilities allowed by the profile.
+	AllowedCapabilities []string `yaml:"allowed-capabilities,omitempty"`
+	// DeniedEnvs are the names of the environment variables that cannot be set.
+	DeniedEnvs []string `yaml:"denied-envs,omitempty"`
+}
+
+// containerGuardPolicy validates the config of the containers created with
+// CreateContainer. Other methods are passed through, so it is meant to be
+// combined with a policy scoping the pod sandboxes, e.g. PodScoped.
+type containerGuardPolicy struct {
+	guard        ContainerGuard
+	capabilities []string
+	// resolvedHostPaths are the allowed host paths with their symbolic
+	// links resolved.
+	resolvedHostPaths []string
+}
+
+// NewContainerGuardPolicy creates a new ContainerGuard policy.
+func NewContainerGuardPolicy(guard ContainerGuard) (Policy, error) {
+	var capabilities []string
+
+	switch guard.Profile {
+	case "", ProfileRestricted:
+		guard.Profile = ProfileRestricted
+		capabilities = slices.Clone(restrictedCapabilities)
+	case ProfileBaseline:
+		capabilities = slices.Clone(baselineCapabilities)
+	default:
+		return nil, fmt.Errorf("%w: profile must be %q or %q, got %q", ErrInvalidContainerGuard, ProfileBaseline, ProfileRestricted, guard.Profile)
+	}
+
+	for _, c := range guard.AllowedCapabilities {
+		capabilities = append(capabilities, normalizeCapability(c))
+	}
+
+	guard.AllowedHostPaths = slices.Clone(guard.AllowedHostPaths)
+	guard.AllowedDevices = slices.Clone(guard.AllowedDevices)
+
+	for _, paths := range [][]string{guard.AllowedHostPaths, guard.AllowedDevices} {
+		for i, path := range paths {
+			if !filepath.IsAbs(path) {
+				return nil, fmt.Errorf("%w: path %q must be absolute", ErrInvalidContainerGuard, path)
+			}
+
+			paths[i] = filepath.Clean(path)
+		}
+	}
+
+	resolvedHostPaths := make([]string, 0, len(guard.AllowedHostPaths))
+
+	for _, path := range guard.AllowedHostPaths {
+		resolved, err := resolveSymlinks(path)
+		if err != nil {
+			resolved = path
+		}
+
+		resolvedHostPaths = append(resolvedHostPaths, resolved)
+	}
+
+	return &containerGuardPolicy{
+		guard:             guard,
+		capabilities:      capabilities,
+		resolvedHostPaths: resolvedHostPaths,
+	}, nil
+}
+
+// Name implements the Policy interface.
+func (p *containerGuardPolicy) Name() string {
+	return "containerGuard"
+}
+
+// UnaryInterceptor implements the Policy interface.
+func (p *containerGuardPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		interceptor := func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			r, ok := req.(*runtimeapi.CreateContainerRequest)
+			if !ok {
+				return handler(ctx, req)
+			}
+
+			violations := p.violations(r.GetConfig())
+			if len(violations) > 0 {
+				klog.FromContext(ctx).V(4).Info("container config not allowed", "podSandboxID", r.GetPodSandboxId(), "violations", violations)
+
+				return nil, newError(codes.PermissionDenied, ReasonContainerConfigNotAllowed, nil, "%s: %s", ErrContainerConfigNotAllowed, strings.Join(violations, "; "))
+			}
+
+			return handler(ctx, req)
+		}
+
+		return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return loggingInterceptor(ctx, req, info, handler)
+		})
+	}
+}
+
+// StreamInterceptor implements the Policy interface.
+func (p *containerGuardPolicy) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		return handler(srv, ss)
+	}
+}
+
+// violations returns a description of every rule of the guard that the
+// container config violates.
+func (p *containerGuardPolicy) violations(config *runtimeapi.ContainerConfig) []string {
+	var violations []string
+
+	for _, m := range config.GetMounts() {
+		if !p.hostPathAllowed(m.GetHostPath()) {
+			violations = append(violations, fmt.Sprintf("host path %s is not allowed", m.GetHostPath()))
+		}
+
+		if m.GetPropagation() == runtimeapi.MountPropagation_PROPAGATION_BIDIRECTIONAL {
+			violations = append(violations, fmt.Sprintf("bidirectional mount propagation of %s is not allowed", m.GetHostPath()))
+		}
+	}
+
+	for _, d := range config.GetDevices() {
+		if !slices.Contains(p.guard.AllowedDevices, filepath.Clean(d.GetHostPath())) {
+			violations = append(violations, fmt.Sprintf("device %s is not allowed", d.GetHostPath()))
+		}
+	}
+
+	for _, d := range config.GetCDIDevices() {
+		if !slices.Contains(p.guard.AllowedCDIDevices, d.GetName()) {
+			violations = append(violations, fmt.Sprintf("CDI device %s is not allowed", d.GetName()))
+		}
+	}
+
+	violations = append(violations, p.cdiAnnotationViolations(config.GetAnnotations())...)
+
+	for _, env := range config.GetEnvs() {
+		if slices.Contains(p.guard.DeniedEnvs, env.GetKey()) {
+			violations = append(violations, fmt.Sprintf("environment variable %s is not allowed", env.GetKey()))
+		}
+	}
+
+	if config.GetWindows().GetSecurityContext().GetHostProcess() {
+		violations = append(violations, "host process containers are not allowed")
+	}
+
+	return append(violations, p.securityContextViolations(config.GetLinux().GetSecurityContext())...)
+}
+
+// cdiAnnotationPrefix is the prefix of the annotations requesting CDI
+// devices, whose values are comma-separated device names.
+const cdiAnnotationPrefix = "cdi.k8s.io/"
+
+func (p *containerGuardPolicy) cdiAnnotationViolations(annotations map[string]string) []string {
+	var violations []string
+
+	for _, key := range slices.Sorted(maps.Keys(annotations)) {
+		if !strings.HasPrefix(key, cdiAnnotationPrefix) {
+			continue
+		}
+
+		for _, name := range strings.Split(annotations[key], ",") {
+			name = strings.TrimSpace(name)
+			if !slices.Contains(p.guard.AllowedCDIDevices, name) {
+				violations = append(violations, fmt.Sprintf("CDI device %q of annotation %s is not allowed", name, key))
+			}
+		}
+	}
+
+	return violations
+}
+
+func (p *containerGuardPolicy) securityContextViolations(sc *runtimeapi.LinuxContainerSecurityContext) []string {
+	var violations []string
+
+	if sc.GetPrivileged() {
+		violations = append(violations, "privileged containers are not allowed")
+	}
+
+	for _, ns := range []struct {
+		name string
+		mode runtimeapi.NamespaceMode
+	}{
+		{"network", sc.GetNamespaceOptions().GetNetwork()},
+		{"pid", sc.GetNamespaceOptions().GetPid()},
+		{"ipc", sc.GetNamespaceOptions().GetIpc()},
+	} {
+		switch ns.mode {
+		case runtimeapi.NamespaceMode_NODE:
+			violations = append(violations, fmt.Sprintf("host %s namespace is not allowed", ns.name))
+		case runtimeapi.NamespaceMode_TARGET:
+			// The target is any container of the node, as nothing ties it
+			// to the pod sandbox of the new container.
+			violations = append(violations, fmt.Sprintf("%s namespace of a target container is not allowed", ns.name))
+		case runtimeapi.NamespaceMode_POD, runtimeapi.NamespaceMode_CONTAINER:
+		}
+	}
+
+	// Runtimes leave /proc unmasked when no paths are given, which is what
+	// the Unmasked proc mount type of Kubernetes does.
+	if len(sc.GetMaskedPaths()) == 0 {
+		violations = append(violations, "masked paths must be set")
+	}
+
+	if len(sc.GetReadonlyPaths()) == 0 {
+		violations = append(violations, "readonly paths must be set")
+	}
+
+	capabilities := slices.Concat(sc.GetCapabilities().GetAddCapabilities(), sc.GetCapabilities().GetAddAmbientCapabilities())
+	for _, c := range capabilities {
+		if !slices.Contains(p.capabilities, normalizeCapability(c)) {
+			violations = append(violations, fmt.Sprintf("capability %s is not allowed", c))
+		}
+	}
+
+	if sc.GetSeccomp().GetProfileType() == runtimeapi.SecurityProfile_Unconfined || sc.GetSeccompProfilePath() == "unconfined" {
+		violations = append(violations, "unconfined seccomp profile is not allowed")
+	}
+
+	if sc.GetApparmor().GetProfileType() == runtimeapi.SecurityProfile_Unconfined || sc.GetApparmorProfile() == "unconfined" {
+		violations = append(violations, "unconfined apparmor profile is not allowed")
+	}
+
+	if opt := sc.GetSelinuxOptions(); opt != nil {
+		if !slices.Contains(baselineSELinuxTypes, opt.GetType()) {
+			violations = append(violations, fmt.Sprintf("selinux type %s is not allowed", opt.GetType()))
+		}
+
+		if opt.GetUser() != "" || opt.GetRole() != "" {
+			violations = append(violations, "selinux user and role are not allowed")
+		}
+	}
+
+	if p.guard.Profile == ProfileRestricted {
+		violations = append(violations, restrictedViolations(sc)...)
+	}
+
+	return violations
+}
+
+// restrictedViolations checks the hardening rules of the restricted profile.
+func restrictedViolations(sc *runtimeapi.LinuxContainerSecurityContext) []string {
+	var violations []string
+
+	if sc.GetRunAsUser() == nil || sc.GetRunAsUser().GetValue() == 0 {
+		violations = append(violations, "run_as_user must be set to a non-root user")
+	}
+
+	if !sc.GetNoNewPrivs() {
+		violations = append(violations, "no_new_privs must be set")
+	}
+
+	if !slices.ContainsFunc(sc.GetCapabilities().GetDropCapabilities(), func(c string) bool {
+		return normalizeCapability(c) == "ALL"
+	}) {
+		violations = append(violations, "all capabilities must be dropped")
+	}
+
+	if sc.GetSeccomp() == nil && sc.GetSeccompProfilePath() == "" {
+		violations = append(violations, "a seccomp profile must be set")
+	}
+
+	return violations
+}
+
+// hostPathAllowed reports whether path is one of the allowed host paths or
+// below one. The symbolic links of the path are resolved, so that a link
+// below an allowed path does not lead out of it. A link created after the
+// check is still followed by the runtime, so the allowed paths must not be
+// writable by the callers.
+func (p *containerGuardPolicy) hostPathAllowed(path string) bool {
+	if !pathAllowed(filepath.Clean(path), p.guard.AllowedHostPaths) {
+		return false
+	}
+
+	resolved, err := resolveSymlinks(path)
+	if err != nil {
+		return false
+	}
+
+	return pathAllowed(resolved, p.resolvedHostPaths)
+}
+
+// resolveSymlinks resolves the symbolic links of the longest existing parent
+// of path.
+func resolveSymlinks(path string) (string, error) {
+	path = filepath.Clean(path)
+	rest := ""
+
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+
+		parent := filepath.Dir(path)
+		if !errors.Is(err, fs.ErrNotExist) || parent == path {
+			return "", err
+		}
+
+		rest = filepath.Join(filepath.Base(path), rest)
+		path = parent
+	}
+}
+
+// pathAllowed reports whether the clean path is one of the allowed paths or
+// below one.
+func pathAllowed(path string, allowed []string) bool {
+	for _, a := range allowed {
+		if path == a || strings.HasPrefix(path, strings.TrimSuffix(a, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeCapability returns the name of a capability without the CAP_ prefix.
+func normalizeCapability(c string) string {
+	return strings.TrimPrefix(strings.ToUpper(c), "CAP_")
+}
@@ -0,0 +1,232 @@
+package policy_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/policy"
+)
+
+// restrictedContainerConfig returns a container config that complies with the restricted profile.
+func restrictedContainerConfig() *runtimeapi.ContainerConfig {
+	return &runtimeapi.ContainerConfig{
+		Metadata: &runtimeapi.ContainerMetadata{Name: "sidecar"},
+		Mounts: []*runtimeapi.Mount{
+			{ContainerPath: "/data", HostPath: "/var/lib/kubelet/pods/uid/volumes/data"},
+		},
+		Linux: &runtimeapi.LinuxContainerConfig{
+			SecurityContext: &runtimeapi.LinuxContainerSecurityContext{
+				RunAsUser:  &runtimeapi.Int64Value{Value: 1000},
+				NoNewPrivs: true,
+				Capabilities: &runtimeapi.Capability{
+					DropCapabilities: []string{"ALL"},
+				},
+				Seccomp:       &runtimeapi.SecurityProfile{ProfileType: runtimeapi.SecurityProfile_RuntimeDefault},
+				MaskedPaths:   []string{"/proc/kcore"},
+				ReadonlyPaths: []string{"/proc/sys"},
+			},
+		},
+	}
+}
+
+var _ = Describe("ContainerGuard Policy", func() {
+	var runtimeClient runtimeapi.RuntimeServiceClient
+
+	startProxy := func(guard policy.ContainerGuard) {
+		p, err := policy.NewContainerGuardPolicy(guard)
+		Expect(err).NotTo(HaveOccurred())
+
+		var cleanup func()
+
+		runtimeClient, _, _, cleanup = setupTestEnvironment(withPolicies(p))
+		DeferCleanup(cleanup)
+	}
+
+	createContainer := func(config *runtimeapi.ContainerConfig) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := runtimeClient.CreateContainer(ctx, &runtimeapi.CreateContainerRequest{
+			PodSandboxId: "test-sandbox-id",
+			Config:       config,
+		})
+
+		return err
+	}
+
+	Context("with the restricted profile", func() {
+		BeforeEach(func() {
+			startProxy(policy.ContainerGuard{
+				AllowedHostPaths:  []string{"/var/lib/kubelet/pods/"},
+				AllowedCDIDevices: []string{"vendor.com/gpu=0"},
+				DeniedEnvs:        []string{"LD_PRELOAD"},
+			})
+		})
+
+		It("should allow compliant containers", func() {
+			Expect(createContainer(restrictedContainerConfig())).To(Succeed())
+		})
+
+		It("should allow allowed CDI devices", func() {
+			config := restrictedContainerConfig()
+			config.CDIDevices = []*runtimeapi.CDIDevice{{Name: "vendor.com/gpu=0"}}
+			config.Annotations = map[string]string{"cdi.k8s.io/gpu": "vendor.com/gpu=0"}
+
+			Expect(createContainer(config)).To(Succeed())
+		})
+
+		It("should pass other calls through", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		DescribeTable("should deny containers violating the guard",
+			func(mutate func(*runtimeapi.ContainerConfig), violations ...string) {
+				config := restrictedContainerConfig()
+				mutate(config)
+
+				err := createContainer(config)
+				Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+				Expect(err.Error()).To(ContainSubstring(policy.ErrContainerConfigNotAllowed.Error()))
+
+				for _, v := range violations {
+					Expect(err.Error()).To(ContainSubstring(v))
+				}
+			},
+			Entry("privileged", func(c *runtimeapi.ContainerConfig) {
+				c.Linux.SecurityContext.Privileged = true
+			}, "privileged containers are not allowed"),
+			Entry("host path", func(c *runtimeapi.ContainerConfig) {
+				c.Mounts = append(c.Mounts,
+					&runtimeapi.Mount{HostPath: "/"},
+					&runtimeapi.Mount{HostPath: "/var/lib/kubelet/pods/../../../etc"},
+					&runtimeapi.Mount{HostPath: "/var/lib/kubelet/pods-other"},
+				)
+			}, "host path / is not allowed", "host path /var/lib/kubelet/pods/../../../etc is not allowed", "host path /var/lib/kubelet/pods-other is not allowed"),
+			Entry("device", func(c *runtimeapi.ContainerConfig) {
+				c.Devices = []*runtimeapi.Device{{HostPath: "/dev/sda"}}
+			}, "device /dev/sda is not allowed"),
+			Entry("environment variable", func(c *runtimeapi.ContainerConfig) {
+				c.Envs = []*runtimeapi.KeyValue{{Key: "PATH", Value: "/bin"}, {Key: "LD_PRELOAD", Value: "/tmp/x.so"}}
+			}, "environment variable LD_PRELOAD is not allowed"),
+			Entry("host namespaces", func(c *runtimeapi.ContainerConfig) {
+				c.Linux.SecurityContext.NamespaceOptions = &runtimeapi.NamespaceOption{
+					Network: runtimeapi.NamespaceMode_NODE,
+					Pid:     runtimeapi.NamespaceMode_NODE,
+				}
+			}, "host network namespace is not allowed", "host pid namespace is not allowed"),
+			Entry("target pid namespace", func(c *runtimeapi.ContainerConfig) {
+				c.Linux.SecurityContext.NamespaceOptions = &runtimeapi.NamespaceOption{
+					Pid:      runtimeapi.NamespaceMode_TARGET,
+					TargetId: "other-container-id",
+				}
+			}, "pid namespace of a target container is not allowed"),
+			Entry("unmasked proc", func(c *runtimeapi.ContainerConfig) {
+				c.Linux.SecurityContext.MaskedPaths = nil
+				c.Linux.SecurityContext.ReadonlyPaths = nil
+			}, "masked paths must be set", "readonly paths must be set"),
+			Entry("capabilities", func(c *runtimeapi.ContainerConfig) {
+				c.Linux.SecurityContext.Capabilities.AddCapabilities = []string{"CAP_NET_BIND_SERVICE", "SYS_ADMIN"}
+			}, "capability SYS_ADMIN is not allowed"),
+			Entry("unconfined seccomp", func(c *runtimeapi.ContainerConfig) {
+				c.Linux.SecurityContext.Seccomp = &runtimeapi.SecurityProfile{ProfileType: runtimeapi.SecurityProfile_Unconfined}
+			}, "unconfined seccomp profile is not allowed"),
+			Entry("root user", func(c *runtimeapi.ContainerConfig) {
+				c.Linux.SecurityContext.RunAsUser = nil
+			}, "run_as_user must be set to a non-root user"),
+			Entry("CDI device", func(c *runtimeapi.ContainerConfig) {
+				c.CDIDevices = []*runtimeapi.CDIDevice{{Name: "vendor.com/gpu=all"}}
+			}, "CDI device vendor.com/gpu=all is not allowed"),
+			Entry("CDI annotation", func(c *runtimeapi.ContainerConfig) {
+				c.Annotations = map[string]string{"cdi.k8s.io/hooks": "vendor.com/gpu=0,vendor.com/hook=root"}
+			}, `CDI device "vendor.com/hook=root" of annotation cdi.k8s.io/hooks is not allowed`),
+			Entry("multiple violations", func(c *runtimeapi.ContainerConfig) {
+				c.Linux.SecurityContext = nil
+			}, "run_as_user must be set", "no_new_privs must be set", "all capabilities must be dropped", "a seccomp profile must be set"),
+		)
+	})
+
+	Context("with an allowed host path containing a symbolic link", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = GinkgoT().TempDir()
+			Expect(os.Symlink("/etc", filepath.Join(dir, "etc"))).To(Succeed())
+
+			startProxy(policy.ContainerGuard{AllowedHostPaths: []string{dir}})
+		})
+
+		It("should deny the paths the link leads out of the allowed path", func() {
+			for _, hostPath := range []string{filepath.Join(dir, "etc"), filepath.Join(dir, "etc", "missing")} {
+				config := restrictedContainerConfig()
+				config.Mounts = []*runtimeapi.Mount{{ContainerPath: "/data", HostPath: hostPath}}
+
+				err := createContainer(config)
+				Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+				Expect(err.Error()).To(ContainSubstring("host path %s is not allowed", hostPath))
+			}
+
+			config := restrictedContainerConfig()
+			config.Mounts = []*runtimeapi.Mount{{ContainerPath: "/data", HostPath: filepath.Join(dir, "data")}}
+			Expect(createContainer(config)).To(Succeed())
+		})
+	})
+
+	Context("with the baseline profile", func() {
+		BeforeEach(func() {
+			startProxy(policy.ContainerGuard{
+				Profile:             policy.ProfileBaseline,
+				AllowedCapabilities: []string{"NET_RAW"},
+			})
+		})
+
+		It("should not require hardening", func() {
+			Expect(createContainer(&runtimeapi.ContainerConfig{
+				Metadata: &runtimeapi.ContainerMetadata{Name: "sidecar"},
+				Linux: &runtimeapi.LinuxContainerConfig{
+					SecurityContext: &runtimeapi.LinuxContainerSecurityContext{
+						Capabilities:  &runtimeapi.Capability{AddCapabilities: []string{"CHOWN", "NET_RAW"}},
+						MaskedPaths:   []string{"/proc/kcore"},
+						ReadonlyPaths: []string{"/proc/sys"},
+					},
+				},
+			})).To(Succeed())
+		})
+
+		It("should deny known privilege escalations", func() {
+			err := createContainer(&runtimeapi.ContainerConfig{
+				Metadata: &runtimeapi.ContainerMetadata{Name: "sidecar"},
+				Linux: &runtimeapi.LinuxContainerConfig{
+					SecurityContext: &runtimeapi.LinuxContainerSecurityContext{
+						Capabilities:   &runtimeapi.Capability{AddCapabilities: []string{"SYS_ADMIN"}},
+						SelinuxOptions: &runtimeapi.SELinuxOption{Type: "spc_t"},
+					},
+				},
+			})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(err.Error()).To(ContainSubstring("capability SYS_ADMIN is not allowed"))
+			Expect(err.Error()).To(ContainSubstring("selinux type spc_t is not allowed"))
+		})
+	})
+})
+
+var _ = Describe("NewContainerGuardPolicy", func() {
+	It("should reject invalid guards", func() {
+		_, err := policy.NewContainerGuardPolicy(policy.ContainerGuard{Profile: "privileged"})
+		Expect(err).To(MatchError(policy.ErrInvalidContainerGuard))
+
+		_, err = policy.NewContainerGuardPolicy(policy.ContainerGuard{AllowedHostPaths: []string{"data"}})
+		Expect(err).To(MatchError(policy.ErrInvalidContainerGuard))
+	})
+})
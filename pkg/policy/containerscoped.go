@@ -0,0 +1,296 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+// containerListMethods are the list methods that return containers rather
+// than pod sandboxes.
+var containerListMethods = map[string]bool{
+	"/runtime.v1.RuntimeService/ListContainers":     true,
+	"/runtime.v1.RuntimeService/ListContainerStats": true,
+}
+
+// containerScopedPolicy restricts RuntimeService calls to the container of
+// the caller, or to the other containers of its pod sandbox.
+type containerScopedPolicy struct {
+	siblings      bool
+	runtimeClient runtimeapi.RuntimeServiceClient
+}
+
+// NewContainerScopedPolicy creates a new ContainerScoped policy, which
+// restricts RuntimeService calls to the container of the caller. With
+// siblings, the calls are restricted to the other containers of the pod
+// sandbox of the caller instead, so that a sidecar can stop and restart the
+// containers next to it but not itself.
+func NewContainerScopedPolicy(siblings bool, runtimeClient runtimeapi.RuntimeServiceClient) Policy {
+	return &containerScopedPolicy{
+		siblings:      siblings,
+		runtimeClient: runtimeClient,
+	}
+}
+
+// Name implements the Policy interface.
+func (p *containerScopedPolicy) Name() string {
+	return "containerScoped"
+}
+
+// UnaryInterceptor implements the Policy interface.
+func (p *containerScopedPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		interceptor := func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			scope := methodScopes[info.FullMethod]
+			if scope == scopeNode {
+				return handler(ctx, req)
+			}
+
+			// Pod sandboxes are beyond the scope of a container.
+			if scope != scopeContainer && !containerListMethods[info.FullMethod] {
+				return nil, newError(codes.PermissionDenied, ReasonMethodNotAllowed, nil, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
+			}
+
+			s, err := p.callerScope(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			err = s.verifyRequest(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+
+			resp, err := handler(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+
+			err = s.filterResponse(ctx, resp)
+			if err != nil {
+				return nil, err
+			}
+
+			return resp, nil
+		}
+
+		return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return loggingInterceptor(ctx, req, info, handler)
+		})
+	}
+}
+
+// StreamInterceptor implements the Policy interface.
+func (p *containerScopedPolicy) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if info.FullMethod != "/runtime.v1.RuntimeService/GetContainerEvents" {
+			return newError(codes.PermissionDenied, ReasonMethodNotAllowed, nil, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
+		}
+
+		s, err := p.callerScope(ss.Context())
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &containerScopedStream{
+			ServerStream: ss,
+			scope:        s,
+		})
+	}
+}
+
+// callerScope returns the containers the caller of a request has access to.
+func (p *containerScopedPolicy) callerScope(ctx context.Context) (*containerScope, error) {
+	pid, err := callerPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	containerID, err := containerIDFromPID(ctx, pid)
+	if err != nil {
+		return nil, newError(codes.Internal, ReasonPIDResolutionFailed, nil, "failed to get container ID from PID: %v", err)
+	}
+
+	s := &containerScope{
+		self:          containerID,
+		runtimeClient: p.runtimeClient,
+	}
+
+	if p.siblings {
+		s.podSandboxID, err = getPodSandboxIDFromContainerID(ctx, p.runtimeClient, containerID)
+		if err != nil {
+			return nil, newError(codes.Internal, ReasonPIDResolutionFailed, map[string]string{MetadataContainerID: containerID},
+				"%s: %v", ErrPIDResolutionFailed, err)
+		}
+	}
+
+	return s, nil
+}
+
+// containerScope is the container of a caller or, when podSandboxID is set,
+// the other containers of its pod sandbox.
+type containerScope struct {
+	self          string
+	podSandboxID  string
+	runtimeClient runtimeapi.RuntimeServiceClient
+}
+
+// contains reports whether a container of a pod sandbox is in scope.
+func (s *containerScope) contains(containerID, podSandboxID string) bool {
+	if s.podSandboxID == "" {
+		return containerID == s.self
+	}
+
+	return containerID != s.self && podSandboxID == s.podSandboxID
+}
+
+// containerInScope looks up the pod sandbox of a container, when it is needed
+// to tell whether the container is in scope.
+func (s *containerScope) containerInScope(ctx context.Context, containerID string) bool {
+	if s.podSandboxID == "" || containerID == s.self {
+		return s.contains(containerID, "")
+	}
+
+	podSandboxID, err := getPodSandboxIDFromContainerID(ctx, s.runtimeClient, containerID)
+	if err != nil {
+		klog.FromContext(ctx).V(4).Info("failed to get pod sandbox ID from container ID", "containerID", containerID, "err", err)
+
+		return false
+	}
+
+	return s.contains(containerID, podSandboxID)
+}
+
+// verifyRequest checks the container of a request, and narrows the filters of
+// list requests to the containers in scope.
+func (s *containerScope) verifyRequest(ctx context.Context, req interface{}) error {
+	switch r := req.(type) {
+	case *runtimeapi.ListContainersRequest:
+		if r.GetFilter() == nil {
+			r.Filter = &runtimeapi.ContainerFilter{}
+		}
+
+		return s.narrowFilter(&r.Filter.Id, &r.Filter.PodSandboxId, "ListContainersRequest.Filter")
+	case *runtimeapi.ListContainerStatsRequest:
+		if r.GetFilter() == nil {
+			r.Filter = &runtimeapi.ContainerStatsFilter{}
+		}
+
+		return s.narrowFilter(&r.Filter.Id, &r.Filter.PodSandboxId, "ListContainerStatsRequest.Filter")
+	case interface{ GetContainerId() string }:
+		if !s.containerInScope(ctx, r.GetContainerId()) {
+			return newError(codes.PermissionDenied, ReasonContainerNotInPod, map[string]string{MetadataContainerID: r.GetContainerId()},
+				"%s: container %s is out of scope", ErrMethodNotAllowed, r.GetContainerId())
+		}
+
+		return nil
+	default:
+		return newError(codes.Internal, ReasonEvaluationFailed, nil, "request %T has no container ID", req)
+	}
+}
+
+// narrowFilter restricts the container and pod sandbox IDs of a list filter
+// to the scope, and rejects filters selecting containers out of scope.
+func (s *containerScope) narrowFilter(id, podSandboxID *string, field string) error {
+	if s.podSandboxID == "" {
+		if *id != "" && *id != s.self {
+			return newError(codes.PermissionDenied, ReasonMethodNotAllowed, nil, "%s: %s.Id does not match", ErrMethodNotAllowed, field)
+		}
+
+		*id = s.self
+
+		return nil
+	}
+
+	if *podSandboxID != "" && *podSandboxID != s.podSandboxID {
+		return newError(codes.PermissionDenied, ReasonMethodNotAllowed, map[string]string{MetadataPodSandboxID: s.podSandboxID},
+			"%s: %s.PodSandboxId does not match", ErrMethodNotAllowed, field)
+	}
+
+	*podSandboxID = s.podSandboxID
+
+	return nil
+}
+
+// filterResponse removes the containers out of scope from list responses,
+// in case the runtime does not honor the narrowed filters.
+func (s *containerScope) filterResponse(ctx context.Context, resp interface{}) error {
+	switch r := resp.(type) {
+	case *runtimeapi.ListContainersResponse:
+		r.Containers, _ = filterInScope(r.GetContainers(), func(c *runtimeapi.Container) (bool, error) {
+			return s.contains(c.GetId(), c.GetPodSandboxId()), nil
+		})
+	case *runtimeapi.ListContainerStatsResponse:
+		if s.podSandboxID == "" {
+			r.Stats, _ = filterInScope(r.GetStats(), func(stats *runtimeapi.ContainerStats) (bool, error) {
+				return stats.GetAttributes().GetId() == s.self, nil
+			})
+
+			return nil
+		}
+
+		if len(r.GetStats()) == 0 {
+			return nil
+		}
+
+		containerIDs, err := podSandboxContainerIDs(ctx, s.runtimeClient, s.podSandboxID)
+		if err != nil {
+			return err
+		}
+
+		r.Stats, _ = filterInScope(r.GetStats(), func(stats *runtimeapi.ContainerStats) (bool, error) {
+			id := stats.GetAttributes().GetId()
+
+			return containerIDs[id] && s.contains(id, s.podSandboxID), nil
+		})
+	}
+
+	return nil
+}
+
+// containerScopedStream drops the container events of the containers out of scope.
+type containerScopedStream struct {
+	grpc.ServerStream
+
+	scope *containerScope
+}
+
+func (s *containerScopedStream) SendMsg(m interface{}) error {
+	event, ok := m.(*runtimeapi.ContainerEventResponse)
+	if !ok {
+		return newError(codes.Internal, ReasonEvaluationFailed, nil, "cannot filter message of type %T", m)
+	}
+
+	var inScope bool
+
+	if status := event.GetPodSandboxStatus(); status != nil {
+		inScope = s.scope.contains(event.GetContainerId(), status.GetId())
+	} else {
+		inScope = s.scope.containerInScope(s.Context(), event.GetContainerId())
+	}
+
+	if !inScope {
+		return nil
+	}
+
+	return s.ServerStream.SendMsg(m)
+}
@@ -0,0 +1,189 @@
+package policy_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/fake"
+	"cri-lite/pkg/policy"
+	"cri-lite/pkg/proxy"
+)
+
+var _ = Describe("ContainerScoped Policy", func() {
+	var (
+		mock          *fake.Server
+		runtimeClient runtimeapi.RuntimeServiceClient
+		cleanup       func()
+		siblings      bool
+		callerID      string
+		restore       func()
+	)
+
+	BeforeEach(func() {
+		siblings = false
+		callerID = "self"
+		restore = policy.SetContainerIDFromPID(func(context.Context, int32) (string, error) {
+			if callerID == "" {
+				return "", errNotInPod
+			}
+
+			return callerID, nil
+		})
+	})
+
+	JustBeforeEach(func() {
+		runtimeClient, _, mock, cleanup = setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+			return []policy.Policy{policy.NewContainerScopedPolicy(siblings, proxyServer.GetRuntimeClient())}
+		})
+
+		mock.SetContainers([]*runtimeapi.Container{
+			{Id: "self", PodSandboxId: "own-pod"},
+			{Id: "sibling", PodSandboxId: "own-pod"},
+			{Id: "other", PodSandboxId: "other-pod"},
+		})
+		mock.SetEmittedEvents([]*runtimeapi.ContainerEventResponse{
+			{ContainerId: "self"},
+			{ContainerId: "sibling", PodSandboxStatus: &runtimeapi.PodSandboxStatus{Id: "own-pod"}},
+			{ContainerId: "other"},
+		})
+	})
+
+	AfterEach(func() {
+		restore()
+		cleanup()
+	})
+
+	containerIDs := func(containers []*runtimeapi.Container) []string {
+		ids := make([]string, 0, len(containers))
+		for _, c := range containers {
+			ids = append(ids, c.GetId())
+		}
+
+		return ids
+	}
+
+	eventIDs := func(ctx context.Context) []string {
+		stream, err := runtimeClient.GetContainerEvents(ctx, &runtimeapi.GetEventsRequest{})
+		Expect(err).NotTo(HaveOccurred())
+
+		var ids []string
+
+		for {
+			event, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return ids
+			}
+
+			Expect(err).NotTo(HaveOccurred())
+
+			ids = append(ids, event.GetContainerId())
+		}
+	}
+
+	It("should allow the calls on the container of the caller", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: "self"})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should deny the calls on other containers", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		for _, id := range []string{"sibling", "other"} {
+			_, err := runtimeClient.StopContainer(ctx, &runtimeapi.StopContainerRequest{ContainerId: id})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(errors.Is(policy.FromError(err), policy.ErrMethodNotAllowed)).To(BeTrue())
+		}
+	})
+
+	It("should deny the calls on pod sandboxes", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{PodSandboxId: "own-pod"})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+		_, err = runtimeClient.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+	})
+
+	It("should filter the lists and events to the container of the caller", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		resp, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(containerIDs(resp.GetContainers())).To(Equal([]string{"self"}))
+
+		_, err = runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{
+			Filter: &runtimeapi.ContainerFilter{Id: "other"},
+		})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+		Expect(eventIDs(ctx)).To(Equal([]string{"self"}))
+	})
+
+	Context("with a caller outside of a container", func() {
+		BeforeEach(func() {
+			callerID = ""
+		})
+
+		It("should deny the calls", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: "self"})
+			Expect(status.Code(err)).To(Equal(codes.Internal))
+			Expect(errors.Is(policy.FromError(err), policy.ErrPIDResolutionFailed)).To(BeTrue())
+		})
+	})
+
+	Context("with siblings", func() {
+		BeforeEach(func() {
+			siblings = true
+		})
+
+		It("should allow the calls on the other containers of the pod sandbox only", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: "sibling"})
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, id := range []string{"self", "other"} {
+				_, err := runtimeClient.StopContainer(ctx, &runtimeapi.StopContainerRequest{ContainerId: id})
+				Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			}
+		})
+
+		It("should filter the lists and events to the other containers of the pod sandbox", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			resp, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(containerIDs(resp.GetContainers())).To(Equal([]string{"sibling"}))
+
+			_, err = runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{
+				Filter: &runtimeapi.ContainerFilter{PodSandboxId: "other-pod"},
+			})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			Expect(eventIDs(ctx)).To(Equal([]string{"sibling"}))
+		})
+	})
+})
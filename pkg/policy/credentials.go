@@ -0,0 +1,142 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+// dockerConfig is the subset of a docker config.json file holding registry credentials.
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+type dockerAuth struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	RegistryToken string `json:"registrytoken,omitempty"`
+}
+
+// registryCredentials are the credentials of a docker config file, keyed by
+// registry. The file is reloaded when it changes.
+type registryCredentials struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	auths   map[string]*runtimeapi.AuthConfig
+}
+
+// newRegistryCredentials loads the credentials of a docker config file.
+func newRegistryCredentials(path string) (*registryCredentials, error) {
+	c := &registryCredentials{path: path}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat credentials file: %w", err)
+	}
+
+	err = c.load(info)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// lookup returns the credentials of a registry, or nil if there are none.
+func (c *registryCredentials) lookup(ctx context.Context, registry string) *runtimeapi.AuthConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(c.path)
+	if err != nil {
+		klog.FromContext(ctx).Error(err, "failed to stat credentials file, using the last loaded credentials", "path", c.path)
+	} else if !info.ModTime().Equal(c.modTime) || info.Size() != c.size {
+		err = c.load(info)
+		if err != nil {
+			klog.FromContext(ctx).Error(err, "failed to reload credentials file, using the last loaded credentials", "path", c.path)
+		}
+	}
+
+	return c.auths[registry]
+}
+
+func (c *registryCredentials) load(info os.FileInfo) error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to read credentials file: %w", err)
+	}
+
+	var config dockerConfig
+
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return fmt.Errorf("failed to parse credentials file: %w", err)
+	}
+
+	auths := make(map[string]*runtimeapi.AuthConfig, len(config.Auths))
+
+	for key, auth := range config.Auths {
+		registry := normalizeRegistry(key)
+
+		authConfig := &runtimeapi.AuthConfig{
+			Username:      auth.Username,
+			Password:      auth.Password,
+			Auth:          auth.Auth,
+			ServerAddress: registry,
+			IdentityToken: auth.IdentityToken,
+			RegistryToken: auth.RegistryToken,
+		}
+
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return fmt.Errorf("failed to decode auth of registry %s: %w", key, err)
+			}
+
+			username, password, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return fmt.Errorf("%w: auth of registry %s must be of the form username:password", ErrInvalidImageManagement, key)
+			}
+
+			authConfig.Username = username
+			authConfig.Password = password
+		}
+
+		auths[registry] = authConfig
+	}
+
+	c.auths = auths
+	c.modTime = info.ModTime()
+	c.size = info.Size()
+
+	return nil
+}
+
+// normalizeRegistry turns the keys of docker config files, which may be URLs
+// like https://index.docker.io/v1/, into registry domains like docker.io.
+func normalizeRegistry(key string) string {
+	key = strings.TrimPrefix(key, "https://")
+	key = strings.TrimPrefix(key, "http://")
+	key, _, _ = strings.Cut(key, "/")
+
+	switch key {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	default:
+		return key
+	}
+}
@@ -0,0 +1,786 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+var (
+	ErrInvalidRule     = errors.New("invalid policy rule")
+	ErrUnknownSource   = errors.New("unknown source")
+	ErrUnknownOperator = errors.New("unknown operator")
+)
+
+// Action is what happens to a request matched by a rule.
+type Action string
+
+const (
+	// ActionAllow lets the request through, subject to the rule's conditions.
+	ActionAllow Action = "allow"
+	// ActionDeny rejects the request with PermissionDenied.
+	ActionDeny Action = "deny"
+)
+
+// Operator is the comparison performed by a condition or a filter.
+type Operator string
+
+const (
+	// OperatorEquals requires the field to be equal to the expected value.
+	OperatorEquals Operator = "equals"
+	// OperatorBelongsToPod requires the field to be the ID of a container in the expected pod sandbox.
+	OperatorBelongsToPod Operator = "belongsToPod"
+)
+
+// Source is a value derived from the context of the request.
+type Source string
+
+const (
+	// SourcePodSandboxIDFromPID is the pod sandbox ID of the calling process.
+	SourcePodSandboxIDFromPID Source = "podSandboxIdFromPID"
+)
+
+// Rule is a single entry of a declarative policy. Rules are evaluated in order
+// and the first rule matching the request decides its fate. The ID names the
+// rule in the details of the errors it causes, and defaults to its index.
+type Rule struct {
+	ID         string      `yaml:"id,omitempty"`
+	Method     string      `yaml:"method"`
+	Action     Action      `yaml:"action"`
+	Conditions []Condition `yaml:"conditions,omitempty"`
+	Rewrites   []Rewrite   `yaml:"rewrites,omitempty"`
+	Filters    []Filter    `yaml:"filters,omitempty"`
+
+	index int
+}
+
+// Condition is a check that must hold for a rule to match. It either compares
+// a request field using an operator, or evaluates a CEL expression over the
+// request, the caller and the method.
+type Condition struct {
+	Field      string      `yaml:"field,omitempty"`
+	Operator   Operator    `yaml:"operator,omitempty"`
+	Source     Source      `yaml:"source,omitempty"`
+	Value      interface{} `yaml:"value,omitempty"`
+	Expression string      `yaml:"expression,omitempty"`
+
+	expression *expression
+}
+
+// Rewrite sets a string field of the request of an allowed call before it is
+// sent to the runtime, e.g. to narrow the filter of a list request to the pod
+// sandbox of the caller. The unset messages along the field path are created.
+type Rewrite struct {
+	Field  string      `yaml:"field"`
+	Source Source      `yaml:"source,omitempty"`
+	Value  interface{} `yaml:"value,omitempty"`
+}
+
+// Filter prunes the elements of a repeated response field that do not match.
+// Field is a dot-separated path that may traverse repeated fields. When Field
+// is empty the filter applies to the message itself: streamed messages that do
+// not match are dropped and unary responses are denied. As the call already ran
+// when its response is denied, such filters are only allowed on read methods.
+//
+//nolint:tagliatelle // The declarative policy schema uses camelCase keys.
+type Filter struct {
+	Field       string      `yaml:"field"`
+	FilterField string      `yaml:"filterField"`
+	Operator    Operator    `yaml:"operator"`
+	Source      Source      `yaml:"source,omitempty"`
+	Value       interface{} `yaml:"value,omitempty"`
+}
+
+// declarativePolicy is a policy built from a list of rules.
+type declarativePolicy struct {
+	name          string
+	rules         []Rule
+	runtimeClient runtimeapi.RuntimeServiceClient
+}
+
+// NewDeclarativePolicy creates a new policy from a list of rules. Requests not
+// matched by any rule are denied.
+func NewDeclarativePolicy(name string, rules []Rule, runtimeClient runtimeapi.RuntimeServiceClient) (Policy, error) {
+	for i := range rules {
+		rules[i].index = i
+
+		err := validateRule(&rules[i])
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i, rules[i].Method, err)
+		}
+	}
+
+	return &declarativePolicy{
+		name:          name,
+		rules:         rules,
+		runtimeClient: runtimeClient,
+	}, nil
+}
+
+// Name implements the Policy interface.
+func (p *declarativePolicy) Name() string {
+	return p.name
+}
+
+// UnaryInterceptor implements the Policy interface.
+func (p *declarativePolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		interceptor := func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			e := p.newEvaluation()
+
+			rule, err := e.authorize(ctx, info.FullMethod, req)
+			if err != nil {
+				return nil, err
+			}
+
+			err = e.applyRewrites(ctx, rule, req)
+			if err != nil {
+				return nil, err
+			}
+
+			resp, err := handler(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+
+			kept, err := e.applyFilters(ctx, rule, resp)
+			if err != nil {
+				return nil, err
+			}
+
+			if !kept {
+				return nil, newError(codes.PermissionDenied, ReasonMethodNotAllowed, e.metadata(rule), "%s: %s", ErrMethodNotAllowed, info.FullMethod)
+			}
+
+			return resp, nil
+		}
+
+		return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return loggingInterceptor(ctx, req, info, handler)
+		})
+	}
+}
+
+// StreamInterceptor implements the Policy interface.
+func (p *declarativePolicy) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		// The request of a streaming call is only known once the handler
+		// receives it, so the rules are evaluated from RecvMsg.
+		return handler(srv, &declarativeStream{
+			ServerStream: ss,
+			method:       info.FullMethod,
+			evaluation:   p.newEvaluation(),
+		})
+	}
+}
+
+func (p *declarativePolicy) newEvaluation() *evaluation {
+	return &evaluation{
+		policy:        p,
+		containerPods: map[string]string{},
+	}
+}
+
+// maxContainerPods bounds the pod sandboxes of containers remembered by an
+// evaluation, which lives as long as a stream.
+const maxContainerPods = 1024
+
+// evaluation holds the state of evaluating the rules for a single request, so
+// that dynamic sources are resolved at most once.
+type evaluation struct {
+	policy             *declarativePolicy
+	podSandboxID       string
+	podSandboxResolved bool
+	containerPods      map[string]string
+	caller             *Caller
+	callerPodResolved  bool
+}
+
+// authorize returns the allow rule matching the request or a PermissionDenied error.
+func (e *evaluation) authorize(ctx context.Context, method string, req interface{}) (*Rule, error) {
+	rule, err := e.match(ctx, method, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if rule == nil || rule.Action != ActionAllow {
+		return nil, newError(codes.PermissionDenied, ReasonMethodNotAllowed, e.metadata(rule), "%s: %s", ErrMethodNotAllowed, method)
+	}
+
+	return rule, nil
+}
+
+// metadata returns the metadata of the errors of the evaluation, naming the
+// rule when there is one and the pod sandbox of the caller once resolved.
+func (e *evaluation) metadata(rule *Rule) map[string]string {
+	metadata := map[string]string{}
+	if rule != nil {
+		metadata = rule.metadata()
+	}
+
+	if e.podSandboxResolved {
+		metadata[MetadataPodSandboxID] = e.podSandboxID
+	}
+
+	return metadata
+}
+
+// metadata returns the metadata naming the rule in the details of an error.
+func (r *Rule) metadata() map[string]string {
+	id := r.ID
+	if id == "" {
+		id = strconv.Itoa(r.index)
+	}
+
+	return map[string]string{MetadataRule: id}
+}
+
+// match returns the first rule matching the request, or nil if there is none.
+func (e *evaluation) match(ctx context.Context, method string, req interface{}) (*Rule, error) {
+	for i := range e.policy.rules {
+		rule := &e.policy.rules[i]
+
+		if ok, _ := path.Match(rule.Method, method); !ok {
+			continue
+		}
+
+		ok, err := e.conditionsMet(ctx, method, rule, req)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			return rule, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (e *evaluation) conditionsMet(ctx context.Context, method string, rule *Rule, req interface{}) (bool, error) {
+	if len(rule.Conditions) == 0 {
+		return true, nil
+	}
+
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return false, nil
+	}
+
+	for _, c := range rule.Conditions {
+		if c.expression != nil {
+			ok, err := e.evalExpression(ctx, rule, c.expression, method, msg)
+			if err != nil || !ok {
+				return false, err
+			}
+
+			continue
+		}
+
+		actual, err := lookupString(msg.ProtoReflect(), c.Field)
+		if err != nil {
+			klog.FromContext(ctx).V(4).Info("condition field not found", "field", c.Field, "err", err)
+
+			if !failedConditionMet(rule) {
+				return false, nil
+			}
+
+			continue
+		}
+
+		expected, err := e.expected(ctx, c.Source, c.Value)
+		if err != nil {
+			return false, err
+		}
+
+		if !e.compare(ctx, c.Operator, actual, expected) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// applyRewrites sets the fields of the request according to the rewrites of
+// the rule.
+func (e *evaluation) applyRewrites(ctx context.Context, rule *Rule, req interface{}) error {
+	if len(rule.Rewrites) == 0 {
+		return nil
+	}
+
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return newError(codes.Internal, ReasonEvaluationFailed, nil, "cannot rewrite request of type %T", req)
+	}
+
+	for _, r := range rule.Rewrites {
+		value, err := e.expected(ctx, r.Source, r.Value)
+		if err != nil {
+			return err
+		}
+
+		err = setString(msg.ProtoReflect(), r.Field, value)
+		if err != nil {
+			return newError(codes.Internal, ReasonEvaluationFailed, nil, "failed to rewrite %s: %v", r.Field, err)
+		}
+	}
+
+	return nil
+}
+
+// applyFilters prunes a response or a streamed message according to the
+// filters of the rule. It returns false if a filter without a field rejects
+// the message as a whole.
+func (e *evaluation) applyFilters(ctx context.Context, rule *Rule, m interface{}) (bool, error) {
+	if len(rule.Filters) == 0 {
+		return true, nil
+	}
+
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return false, newError(codes.Internal, ReasonEvaluationFailed, nil, "cannot filter message of type %T", m)
+	}
+
+	for i := range rule.Filters {
+		f := &rule.Filters[i]
+
+		expected, err := e.expected(ctx, f.Source, f.Value)
+		if err != nil {
+			return false, err
+		}
+
+		keep := func(elem protoreflect.Message) (bool, error) {
+			actual, err := lookupString(elem, f.FilterField)
+			if err != nil {
+				return false, err
+			}
+
+			return e.compare(ctx, f.Operator, actual, expected), nil
+		}
+
+		if f.Field == "" {
+			kept, err := keep(msg.ProtoReflect())
+			if err != nil {
+				return false, newError(codes.Internal, ReasonEvaluationFailed, nil, "failed to filter message: %v", err)
+			}
+
+			if !kept {
+				return false, nil
+			}
+
+			continue
+		}
+
+		err = pruneRepeated(msg.ProtoReflect(), strings.Split(f.Field, "."), keep)
+		if err != nil {
+			return false, newError(codes.Internal, ReasonEvaluationFailed, nil, "failed to filter %s: %v", f.Field, err)
+		}
+	}
+
+	return true, nil
+}
+
+// expected returns the value a field is compared against.
+func (e *evaluation) expected(ctx context.Context, source Source, value interface{}) (string, error) {
+	if source == "" {
+		return fmt.Sprint(value), nil
+	}
+
+	switch source {
+	case SourcePodSandboxIDFromPID:
+		return e.callerPodSandboxID(ctx)
+	default:
+		return "", newError(codes.Internal, ReasonEvaluationFailed, nil, "%s: %s", ErrUnknownSource, source)
+	}
+}
+
+// callerPodSandboxID returns the pod sandbox ID of the calling process.
+func (e *evaluation) callerPodSandboxID(ctx context.Context) (string, error) {
+	if !e.podSandboxResolved {
+		pid, err := callerPID(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		e.podSandboxID, err = podSandboxIDFromPID(ctx, e.policy.runtimeClient, pid)
+		if err != nil {
+			return "", pidResolutionError(err)
+		}
+
+		e.podSandboxResolved = true
+	}
+
+	return e.podSandboxID, nil
+}
+
+// failedConditionMet returns whether a condition of a rule that could not be
+// evaluated is met. It is for deny rules, so that they fail closed.
+func failedConditionMet(rule *Rule) bool {
+	return rule.Action == ActionDeny
+}
+
+// evalExpression evaluates a CEL condition. The pod of the caller and the
+// digest of its executable are only resolved when the expression uses them.
+func (e *evaluation) evalExpression(ctx context.Context, rule *Rule, x *expression, method string, req interface{}) (bool, error) {
+	if e.caller == nil {
+		creds, err := callerCredentials(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		groups := make([]int64, 0, len(creds.GetGroups()))
+		for _, gid := range creds.GetGroups() {
+			groups = append(groups, int64(gid))
+		}
+
+		e.caller = &Caller{
+			PID:          int64(creds.GetPID()),
+			UID:          int64(creds.GetUID()),
+			GID:          int64(creds.GetGID()),
+			Groups:       groups,
+			NamespaceUID: int64(creds.GetNamespaceUID()),
+			NamespaceGID: int64(creds.GetNamespaceGID()),
+			Executable:   creds.GetExecutable(),
+			Unit:         creds.GetUnit(),
+		}
+	}
+
+	if x.needsDigest && e.caller.ExecutableDigest == "" {
+		creds, err := callerCredentials(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		e.caller.ExecutableDigest = creds.GetExecutableDigest()
+	}
+
+	if x.needsPod && !e.callerPodResolved {
+		podSandboxID, err := e.callerPodSandboxID(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		resp, err := e.policy.runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{
+			PodSandboxId: podSandboxID,
+		})
+		if err != nil {
+			return false, newError(codes.Internal, ReasonRuntimeLookupFailed, map[string]string{MetadataPodSandboxID: podSandboxID}, "failed to get pod sandbox status: %v", err)
+		}
+
+		e.caller.PodSandboxID = podSandboxID
+		e.caller.PodLabels = resp.GetStatus().GetLabels()
+		e.callerPodResolved = true
+	}
+
+	ok, err := x.eval(ctx, method, req, e.caller)
+	if err != nil {
+		klog.FromContext(ctx).V(4).Info("failed to evaluate condition expression", "method", method, "err", err)
+
+		return failedConditionMet(rule), nil
+	}
+
+	return ok, nil
+}
+
+func (e *evaluation) compare(ctx context.Context, operator Operator, actual, expected string) bool {
+	switch operator {
+	case OperatorEquals:
+		return actual == expected
+	case OperatorBelongsToPod:
+		podSandboxID, ok := e.containerPods[actual]
+		if !ok {
+			var err error
+
+			podSandboxID, err = getPodSandboxIDFromContainerID(ctx, e.policy.runtimeClient, actual)
+			if err != nil {
+				klog.FromContext(ctx).V(4).Info("failed to get pod sandbox ID from container ID", "containerID", actual, "err", err)
+
+				return false
+			}
+
+			if len(e.containerPods) >= maxContainerPods {
+				clear(e.containerPods)
+			}
+
+			e.containerPods[actual] = podSandboxID
+		}
+
+		return podSandboxID != "" && podSandboxID == expected
+	default:
+		return false
+	}
+}
+
+// declarativeStream evaluates the rules against the request of a streaming call
+// and applies the filters of the matching rule to every message sent back.
+type declarativeStream struct {
+	grpc.ServerStream
+
+	method     string
+	evaluation *evaluation
+	rule       *Rule
+}
+
+func (s *declarativeStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil || s.rule != nil {
+		return err
+	}
+
+	rule, err := s.evaluation.authorize(s.Context(), s.method, m)
+	if err != nil {
+		return err
+	}
+
+	err = s.evaluation.applyRewrites(s.Context(), rule, m)
+	if err != nil {
+		return err
+	}
+
+	s.rule = rule
+
+	return nil
+}
+
+func (s *declarativeStream) SendMsg(m interface{}) error {
+	if s.rule == nil {
+		return newError(codes.PermissionDenied, ReasonMethodNotAllowed, s.evaluation.metadata(nil), "%s: %s", ErrMethodNotAllowed, s.method)
+	}
+
+	kept, err := s.evaluation.applyFilters(s.Context(), s.rule, m)
+	if err != nil {
+		return err
+	}
+
+	if !kept {
+		klog.FromContext(s.Context()).V(5).Info("message filtered by policy", "method", s.method)
+
+		return nil
+	}
+
+	return s.ServerStream.SendMsg(m)
+}
+
+func validateRule(rule *Rule) error {
+	if rule.Method == "" {
+		return fmt.Errorf("%w: method is required", ErrInvalidRule)
+	}
+
+	if _, err := path.Match(rule.Method, ""); err != nil {
+		return fmt.Errorf("%w: invalid method pattern: %w", ErrInvalidRule, err)
+	}
+
+	if rule.Action != ActionAllow && rule.Action != ActionDeny {
+		return fmt.Errorf("%w: action must be %q or %q, got %q", ErrInvalidRule, ActionAllow, ActionDeny, rule.Action)
+	}
+
+	if rule.Action == ActionDeny && len(rule.Filters) > 0 {
+		return fmt.Errorf("%w: filters are only allowed on allow rules", ErrInvalidRule)
+	}
+
+	if rule.Action == ActionDeny && len(rule.Rewrites) > 0 {
+		return fmt.Errorf("%w: rewrites are only allowed on allow rules", ErrInvalidRule)
+	}
+
+	// Field paths can only be checked when the method, and hence the
+	// request and response types, are known.
+	var input, output protoreflect.MessageDescriptor
+
+	if !strings.ContainsAny(rule.Method, `*?[\`) {
+		md, err := methodDescriptor(rule.Method)
+		if err != nil {
+			return err
+		}
+
+		input, output = md.Input(), md.Output()
+	}
+
+	for i := range rule.Conditions {
+		c := &rule.Conditions[i]
+
+		if c.Expression != "" {
+			if c.Field != "" || c.Operator != "" || c.Source != "" || c.Value != nil {
+				return fmt.Errorf("%w: an expression condition cannot have a field, operator, source or value", ErrInvalidRule)
+			}
+
+			var err error
+
+			c.expression, err = compileExpression(c.Expression, input)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		err := validateComparison(c.Field, c.Operator, c.Source, c.Value)
+		if err != nil {
+			return err
+		}
+
+		if input != nil {
+			_, err := resolveFieldPath(input, c.Field)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, r := range rule.Rewrites {
+		err := validateRewrite(&r, input)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, f := range rule.Filters {
+		if f.Field == "" {
+			err := validateMessageFilter(rule.Method)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := validateFilter(&f, output)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readMethods are the unary methods that change nothing, whose responses can
+// be denied as a whole once the call ran.
+var readMethods = map[string]bool{
+	"/runtime.v1.RuntimeService/Version":               true,
+	"/runtime.v1.RuntimeService/Status":                true,
+	"/runtime.v1.RuntimeService/RuntimeConfig":         true,
+	"/runtime.v1.RuntimeService/ListContainers":        true,
+	"/runtime.v1.RuntimeService/ContainerStatus":       true,
+	"/runtime.v1.RuntimeService/ListPodSandbox":        true,
+	"/runtime.v1.RuntimeService/PodSandboxStatus":      true,
+	"/runtime.v1.RuntimeService/ContainerStats":        true,
+	"/runtime.v1.RuntimeService/ListContainerStats":    true,
+	"/runtime.v1.RuntimeService/PodSandboxStats":       true,
+	"/runtime.v1.RuntimeService/ListPodSandboxStats":   true,
+	"/runtime.v1.RuntimeService/ListMetricDescriptors": true,
+	"/runtime.v1.RuntimeService/ListPodSandboxMetrics": true,
+	"/runtime.v1.ImageService/ListImages":              true,
+	"/runtime.v1.ImageService/ImageStatus":             true,
+	"/runtime.v1.ImageService/ImageFsInfo":             true,
+}
+
+// validateMessageFilter rejects filters without a field on rules matching a
+// unary method that is not a read method.
+func validateMessageFilter(pattern string) error {
+	for _, service := range []*grpc.ServiceDesc{&runtimeapi.RuntimeService_ServiceDesc, &runtimeapi.ImageService_ServiceDesc} {
+		for _, m := range service.Methods {
+			method := "/" + service.ServiceName + "/" + m.MethodName
+
+			if ok, _ := path.Match(pattern, method); ok && !readMethods[method] {
+				return fmt.Errorf("%w: a filter without a field cannot deny the response of %s once it ran", ErrInvalidRule, method)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateFilter(f *Filter, output protoreflect.MessageDescriptor) error {
+	err := validateComparison(f.FilterField, f.Operator, f.Source, f.Value)
+	if err != nil {
+		return err
+	}
+
+	if output == nil {
+		return nil
+	}
+
+	elem, err := resolveFilterPath(output, f.Field)
+	if err != nil {
+		return err
+	}
+
+	_, err = resolveFieldPath(elem, f.FilterField)
+
+	return err
+}
+
+// validateRewrite checks that a rewrite sets a string field of the request.
+// As the request type must be known, rewrites are rejected on glob rules.
+func validateRewrite(r *Rewrite, input protoreflect.MessageDescriptor) error {
+	if r.Field == "" {
+		return fmt.Errorf("%w: field is required", ErrInvalidRule)
+	}
+
+	if (r.Source == "") == (r.Value == nil) {
+		return fmt.Errorf("%w: exactly one of source or value must be set for %s", ErrInvalidRule, r.Field)
+	}
+
+	if r.Source != "" && r.Source != SourcePodSandboxIDFromPID {
+		return fmt.Errorf("%w: %q", ErrUnknownSource, r.Source)
+	}
+
+	if input == nil {
+		return fmt.Errorf("%w: rewrites require a method without globs", ErrInvalidRule)
+	}
+
+	fds, err := resolveFieldPath(input, r.Field)
+	if err != nil {
+		return err
+	}
+
+	fd := fds[len(fds)-1]
+	if fd.Kind() != protoreflect.StringKind || fd.IsList() || fd.IsMap() {
+		return fmt.Errorf("%w: %s is not a string field", ErrInvalidRule, r.Field)
+	}
+
+	return nil
+}
+
+func validateComparison(field string, operator Operator, source Source, value interface{}) error {
+	if field == "" {
+		return fmt.Errorf("%w: field is required", ErrInvalidRule)
+	}
+
+	if operator != OperatorEquals && operator != OperatorBelongsToPod {
+		return fmt.Errorf("%w: %q", ErrUnknownOperator, operator)
+	}
+
+	if (source == "") == (value == nil) {
+		return fmt.Errorf("%w: exactly one of source or value must be set for %s", ErrInvalidRule, field)
+	}
+
+	if source != "" && source != SourcePodSandboxIDFromPID {
+		return fmt.Errorf("%w: %q", ErrUnknownSource, source)
+	}
+
+	return nil
+}
@@ -0,0 +1,884 @@
+package policy_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/fake"
+	"cri-lite/pkg/policy"
+	"cri-lite/pkg/proxy"
+)
+
+const policiesDir = "../../design/proposals/declarative-policy-config/policies"
+
+var _ = Describe("Declarative Policy", func() {
+	Context("loading the shipped policy files", func() {
+		DescribeTable("should load",
+			func(file, name string) {
+				p, err := policy.NewFromConfig(filepath.Join(policiesDir, file), nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(p.Name()).To(Equal(name))
+			},
+			Entry("readonly", "readonly.yaml", "readonly"),
+			Entry("image management", "image_management.yaml", "image_management"),
+			Entry("pod scoped", "podscoped.yaml", "podscoped"),
+		)
+	})
+
+	Context("validating rules", func() {
+		DescribeTable("should reject",
+			func(rule policy.Rule, expected error) {
+				_, err := policy.NewDeclarativePolicy("test", []policy.Rule{rule}, nil)
+				Expect(err).To(MatchError(expected))
+			},
+			Entry("an unknown action",
+				policy.Rule{Method: "/runtime.v1.RuntimeService/Version", Action: "permit"},
+				policy.ErrInvalidRule),
+			Entry("an unknown method",
+				policy.Rule{Method: "/runtime.v1.RuntimeService/UpdatePodSandbox", Action: policy.ActionAllow},
+				policy.ErrUnknownMethod),
+			Entry("an unknown condition field",
+				policy.Rule{
+					Method: "/runtime.v1.RuntimeService/StopContainer",
+					Action: policy.ActionAllow,
+					Conditions: []policy.Condition{
+						{Field: "PodSandboxId", Operator: policy.OperatorEquals, Value: "id"},
+					},
+				},
+				policy.ErrUnknownField),
+			Entry("an unknown operator",
+				policy.Rule{
+					Method: "/runtime.v1.RuntimeService/StopContainer",
+					Action: policy.ActionAllow,
+					Conditions: []policy.Condition{
+						{Field: "ContainerId", Operator: "matches", Value: "id"},
+					},
+				},
+				policy.ErrUnknownOperator),
+			Entry("an unknown source",
+				policy.Rule{
+					Method: "/runtime.v1.RuntimeService/StopContainer",
+					Action: policy.ActionAllow,
+					Conditions: []policy.Condition{
+						{Field: "ContainerId", Operator: policy.OperatorEquals, Source: "podSandboxIdFromUID"},
+					},
+				},
+				policy.ErrUnknownSource),
+			Entry("a condition with both a source and a value",
+				policy.Rule{
+					Method: "/runtime.v1.RuntimeService/StopContainer",
+					Action: policy.ActionAllow,
+					Conditions: []policy.Condition{
+						{Field: "ContainerId", Operator: policy.OperatorEquals, Source: policy.SourcePodSandboxIDFromPID, Value: "id"},
+					},
+				},
+				policy.ErrInvalidRule),
+			Entry("a filter on a non-repeated field",
+				policy.Rule{
+					Method: "/runtime.v1.RuntimeService/ContainerStatus",
+					Action: policy.ActionAllow,
+					Filters: []policy.Filter{
+						{Field: "Status", FilterField: "Id", Operator: policy.OperatorEquals, Value: "id"},
+					},
+				},
+				policy.ErrInvalidRule),
+			Entry("a filter path through a non-message field",
+				policy.Rule{
+					Method: "/runtime.v1.RuntimeService/ListContainers",
+					Action: policy.ActionAllow,
+					Filters: []policy.Filter{
+						{Field: "Containers.Id", FilterField: "Id", Operator: policy.OperatorEquals, Value: "id"},
+					},
+				},
+				policy.ErrInvalidRule),
+			Entry("a filter without a field on a method changing state",
+				policy.Rule{
+					Method: "/runtime.v1.RuntimeService/StopContainer",
+					Action: policy.ActionAllow,
+					Filters: []policy.Filter{
+						{FilterField: "ContainerId", Operator: policy.OperatorEquals, Value: "id"},
+					},
+				},
+				policy.ErrInvalidRule),
+			Entry("a filter without a field on a pattern matching methods changing state",
+				policy.Rule{
+					Method: "/runtime.v1.RuntimeService/*",
+					Action: policy.ActionAllow,
+					Filters: []policy.Filter{
+						{FilterField: "ContainerId", Operator: policy.OperatorEquals, Value: "id"},
+					},
+				},
+				policy.ErrInvalidRule),
+			Entry("a filter on a deny rule",
+				policy.Rule{
+					Method: "/runtime.v1.RuntimeService/ListContainers",
+					Action: policy.ActionDeny,
+					Filters: []policy.Filter{
+						{Field: "Containers", FilterField: "Id", Operator: policy.OperatorEquals, Value: "id"},
+					},
+				},
+				policy.ErrInvalidRule),
+			Entry("a rewrite on a deny rule",
+				policy.Rule{
+					Method:   "/runtime.v1.RuntimeService/ListContainers",
+					Action:   policy.ActionDeny,
+					Rewrites: []policy.Rewrite{{Field: "Filter.PodSandboxId", Value: "id"}},
+				},
+				policy.ErrInvalidRule),
+			Entry("a rewrite on a pattern",
+				policy.Rule{
+					Method:   "/runtime.v1.RuntimeService/List*",
+					Action:   policy.ActionAllow,
+					Rewrites: []policy.Rewrite{{Field: "Filter.PodSandboxId", Value: "id"}},
+				},
+				policy.ErrInvalidRule),
+			Entry("a rewrite of a field that is not a string",
+				policy.Rule{
+					Method:   "/runtime.v1.RuntimeService/ListContainers",
+					Action:   policy.ActionAllow,
+					Rewrites: []policy.Rewrite{{Field: "Filter.State", Value: "id"}},
+				},
+				policy.ErrInvalidRule),
+			Entry("a rewrite of an unknown field",
+				policy.Rule{
+					Method:   "/runtime.v1.RuntimeService/ListContainers",
+					Action:   policy.ActionAllow,
+					Rewrites: []policy.Rewrite{{Field: "Filter.Name", Value: "id"}},
+				},
+				policy.ErrUnknownField),
+			Entry("an expression that does not compile",
+				policy.Rule{
+					Method: "/runtime.v1.RuntimeService/ExecSync",
+					Action: policy.ActionAllow,
+					Conditions: []policy.Condition{
+						{Expression: "request.cmd[0] in"},
+					},
+				},
+				policy.ErrInvalidRule),
+			Entry("an expression using an unknown request field",
+				policy.Rule{
+					Method: "/runtime.v1.RuntimeService/ExecSync",
+					Action: policy.ActionAllow,
+					Conditions: []policy.Condition{
+						{Expression: "request.command[0] == '/bin/sh'"},
+					},
+				},
+				policy.ErrInvalidRule),
+			Entry("an expression that does not evaluate to bool",
+				policy.Rule{
+					Method: "/runtime.v1.RuntimeService/ExecSync",
+					Action: policy.ActionAllow,
+					Conditions: []policy.Condition{
+						{Expression: "request.timeout + 1"},
+					},
+				},
+				policy.ErrInvalidRule),
+			Entry("an expression with a field",
+				policy.Rule{
+					Method: "/runtime.v1.RuntimeService/ExecSync",
+					Action: policy.ActionAllow,
+					Conditions: []policy.Condition{
+						{Field: "ContainerId", Expression: "true"},
+					},
+				},
+				policy.ErrInvalidRule),
+		)
+	})
+
+	Context("with CEL conditions", func() {
+		var (
+			runtimeClient runtimeapi.RuntimeServiceClient
+			imageClient   runtimeapi.ImageServiceClient
+			cleanup       func()
+		)
+
+		BeforeEach(func() {
+			p, err := policy.NewDeclarativePolicy("cel", []policy.Rule{
+				{
+					Method: "/runtime.v1.RuntimeService/ExecSync",
+					Action: policy.ActionAllow,
+					Conditions: []policy.Condition{
+						{Expression: "size(request.cmd) > 0 && request.cmd[0] in ['/bin/healthcheck']"},
+					},
+				},
+				{
+					Method: "/runtime.v1.RuntimeService/UpdateContainerResources",
+					Action: policy.ActionAllow,
+					Conditions: []policy.Condition{
+						{Expression: "request.linux.memory_limit_in_bytes <= 2 * 1024 * 1024 * 1024"},
+					},
+				},
+				{
+					Method: "/runtime.v1.RuntimeService/Version",
+					Action: policy.ActionAllow,
+					Conditions: []policy.Condition{
+						{Expression: fmt.Sprintf("caller.pid == %d && caller.uid == %d", os.Getpid(), os.Getuid())},
+					},
+				},
+				{
+					Method: "/runtime.v1.ImageService/*",
+					Action: policy.ActionAllow,
+					Conditions: []policy.Condition{
+						{Expression: "method.endsWith('/ImageStatus') && request.image.image == 'allowed'"},
+					},
+				},
+			}, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			runtimeClient, imageClient, _, cleanup = setupTestEnvironment(withPolicies(p))
+		})
+
+		AfterEach(func() {
+			cleanup()
+		})
+
+		It("should evaluate expressions over repeated request fields", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.ExecSync(ctx, &runtimeapi.ExecSyncRequest{Cmd: []string{"/bin/healthcheck"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = runtimeClient.ExecSync(ctx, &runtimeapi.ExecSyncRequest{Cmd: []string{"/bin/sh"}})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			_, err = runtimeClient.ExecSync(ctx, &runtimeapi.ExecSyncRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+
+		It("should evaluate expressions over nested request fields", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.UpdateContainerResources(ctx, &runtimeapi.UpdateContainerResourcesRequest{
+				Linux: &runtimeapi.LinuxContainerResources{MemoryLimitInBytes: 1 << 30},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = runtimeClient.UpdateContainerResources(ctx, &runtimeapi.UpdateContainerResourcesRequest{
+				Linux: &runtimeapi.LinuxContainerResources{MemoryLimitInBytes: 4 << 30},
+			})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+
+		It("should expose the caller credentials", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should expose the method and a dynamically typed request for glob rules", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := imageClient.ImageStatus(ctx, &runtimeapi.ImageStatusRequest{Image: &runtimeapi.ImageSpec{Image: "allowed"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = imageClient.ImageStatus(ctx, &runtimeapi.ImageStatusRequest{Image: &runtimeapi.ImageSpec{Image: "other"}})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			_, err = imageClient.ListImages(ctx, &runtimeapi.ListImagesRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+
+		It("should deny the requests whose deny expressions fail to evaluate", func() {
+			p, err := policy.NewDeclarativePolicy("cel", []policy.Rule{
+				{
+					Method: "/runtime.v1.RuntimeService/UpdateContainerResources",
+					Action: policy.ActionDeny,
+					Conditions: []policy.Condition{
+						{Expression: "request.annotations['tier'] == 'untrusted'"},
+					},
+				},
+				{
+					Method: "/runtime.v1.RuntimeService/UpdateContainerResources",
+					Action: policy.ActionAllow,
+				},
+			}, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			runtimeClient, _, _, cleanup := setupTestEnvironment(withPolicies(p))
+			DeferCleanup(cleanup)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err = runtimeClient.UpdateContainerResources(ctx, &runtimeapi.UpdateContainerResourcesRequest{
+				Annotations: map[string]string{"tier": "trusted"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = runtimeClient.UpdateContainerResources(ctx, &runtimeapi.UpdateContainerResourcesRequest{
+				Annotations: map[string]string{"tier": "untrusted"},
+			})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			By("leaving out the annotation read by the expression")
+			_, err = runtimeClient.UpdateContainerResources(ctx, &runtimeapi.UpdateContainerResourcesRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+	})
+
+	Context("with the readonly policy file", func() {
+		var (
+			runtimeClient runtimeapi.RuntimeServiceClient
+			imageClient   runtimeapi.ImageServiceClient
+			cleanup       func()
+		)
+
+		BeforeEach(func() {
+			p, err := policy.NewFromConfig(filepath.Join(policiesDir, "readonly.yaml"), nil)
+			Expect(err).NotTo(HaveOccurred())
+			runtimeClient, imageClient, _, cleanup = setupTestEnvironment(withPolicies(p))
+		})
+
+		AfterEach(func() {
+			cleanup()
+		})
+
+		It("should allow the same calls as the built-in ReadOnly policy", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = runtimeClient.Status(ctx, &runtimeapi.StatusRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = imageClient.ListImages(ctx, &runtimeapi.ListImagesRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = imageClient.ImageFsInfo(ctx, &runtimeapi.ImageFsInfoRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			stream, err := runtimeClient.GetContainerEvents(ctx, &runtimeapi.GetEventsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = stream.Recv()
+			Expect(err).To(MatchError(io.EOF))
+		})
+
+		It("should deny the same calls as the built-in ReadOnly policy", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.StopContainer(ctx, &runtimeapi.StopContainerRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(err.Error()).To(ContainSubstring("method not allowed by policy"))
+
+			_, err = runtimeClient.ExecSync(ctx, &runtimeapi.ExecSyncRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			_, err = imageClient.PullImage(ctx, &runtimeapi.PullImageRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			_, err = imageClient.RemoveImage(ctx, &runtimeapi.RemoveImageRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+	})
+
+	Context("with the image management policy file", func() {
+		var (
+			runtimeClient runtimeapi.RuntimeServiceClient
+			imageClient   runtimeapi.ImageServiceClient
+			cleanup       func()
+		)
+
+		BeforeEach(func() {
+			p, err := policy.NewFromConfig(filepath.Join(policiesDir, "image_management.yaml"), nil)
+			Expect(err).NotTo(HaveOccurred())
+			runtimeClient, imageClient, _, cleanup = setupTestEnvironment(withPolicies(p))
+		})
+
+		AfterEach(func() {
+			cleanup()
+		})
+
+		It("should allow image calls and deny runtime calls", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := imageClient.PullImage(ctx, &runtimeapi.PullImageRequest{Image: &runtimeapi.ImageSpec{Image: "busybox"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			stream, err := runtimeClient.GetContainerEvents(ctx, &runtimeapi.GetEventsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = stream.Recv()
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+	})
+
+	Context("with the pod scoped policy file", func() {
+		type clients struct {
+			runtime runtimeapi.RuntimeServiceClient
+			image   runtimeapi.ImageServiceClient
+			mock    *fake.Server
+		}
+
+		var builtin, declarative clients
+
+		// seed gives the fake runtime the resources of the pod sandbox of the
+		// caller and of another pod sandbox.
+		seed := func(mock *fake.Server) {
+			mock.SetContainers([]*runtimeapi.Container{
+				{Id: "test-container-id", PodSandboxId: "test-sandbox-id"},
+				{Id: "other-container-id", PodSandboxId: "other-sandbox-id"},
+			})
+			mock.SetPodSandboxes([]*runtimeapi.PodSandbox{
+				{Id: "test-sandbox-id"},
+				{Id: "other-sandbox-id"},
+			})
+			mock.SetContainerStats([]*runtimeapi.ContainerStats{
+				{Attributes: &runtimeapi.ContainerAttributes{Id: "test-container-id", Metadata: &runtimeapi.ContainerMetadata{Name: "container-1"}}},
+				{Attributes: &runtimeapi.ContainerAttributes{Id: "other-container-id", Metadata: &runtimeapi.ContainerMetadata{Name: "container-2"}}},
+			})
+			mock.SetPodSandboxStats([]*runtimeapi.PodSandboxStats{
+				{Attributes: &runtimeapi.PodSandboxAttributes{Id: "test-sandbox-id"}},
+				{Attributes: &runtimeapi.PodSandboxAttributes{Id: "other-sandbox-id"}},
+			})
+			mock.SetPodSandboxMetrics([]*runtimeapi.PodSandboxMetrics{
+				{PodSandboxId: "test-sandbox-id"},
+				{PodSandboxId: "other-sandbox-id"},
+			})
+			mock.SetEmittedEvents([]*runtimeapi.ContainerEventResponse{
+				{ContainerId: "test-container-id"},
+				{ContainerId: "other-container-id"},
+			})
+		}
+
+		BeforeEach(func() {
+			// The caller runs in the container of the test pod sandbox, which
+			// both policies look up in the runtime.
+			DeferCleanup(policy.SetContainerIDFromPID(func(context.Context, int32) (string, error) {
+				return "test-container-id", nil
+			}))
+
+			var cleanup func()
+
+			builtin.runtime, builtin.image, builtin.mock, cleanup = setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+				return []policy.Policy{policy.NewPodScopedPolicy("", true, proxyServer.GetRuntimeClient())}
+			})
+			DeferCleanup(cleanup)
+
+			declarative.runtime, declarative.image, declarative.mock, cleanup = setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+				p, err := policy.NewFromConfig(filepath.Join(policiesDir, "podscoped.yaml"), proxyServer.GetRuntimeClient())
+				Expect(err).NotTo(HaveOccurred())
+
+				return []policy.Policy{p}
+			})
+			DeferCleanup(cleanup)
+
+			seed(builtin.mock)
+			seed(declarative.mock)
+		})
+
+		DescribeTable("should handle calls like the built-in PodScoped policy",
+			func(call func(context.Context, clients) (proto.Message, error), expected codes.Code) {
+				ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+				defer cancel()
+
+				builtinResp, err := call(ctx, builtin)
+				Expect(status.Code(err)).To(Equal(expected), "built-in policy: %v", err)
+
+				declarativeResp, err := call(ctx, declarative)
+				Expect(status.Code(err)).To(Equal(expected), "declarative policy: %v", err)
+
+				if expected == codes.OK {
+					Expect(proto.Equal(declarativeResp, builtinResp)).To(BeTrue(),
+						"declarative response %v, built-in response %v", declarativeResp, builtinResp)
+				}
+			},
+			Entry("Status", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.Status(ctx, &runtimeapi.StatusRequest{})
+			}, codes.OK),
+			Entry("RuntimeConfig", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.RuntimeConfig(ctx, &runtimeapi.RuntimeConfigRequest{})
+			}, codes.OK),
+			Entry("ListMetricDescriptors", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ListMetricDescriptors(ctx, &runtimeapi.ListMetricDescriptorsRequest{})
+			}, codes.OK),
+			Entry("ImageFsInfo", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.image.ImageFsInfo(ctx, &runtimeapi.ImageFsInfoRequest{})
+			}, codes.OK),
+			Entry("ListImages", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.image.ListImages(ctx, &runtimeapi.ListImagesRequest{})
+			}, codes.PermissionDenied),
+			Entry("PullImage", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.image.PullImage(ctx, &runtimeapi.PullImageRequest{Image: &runtimeapi.ImageSpec{Image: "busybox"}})
+			}, codes.PermissionDenied),
+			Entry("RunPodSandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.RunPodSandbox(ctx, &runtimeapi.RunPodSandboxRequest{})
+			}, codes.PermissionDenied),
+			Entry("UpdateRuntimeConfig", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.UpdateRuntimeConfig(ctx, &runtimeapi.UpdateRuntimeConfigRequest{})
+			}, codes.PermissionDenied),
+			Entry("CheckpointContainer", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.CheckpointContainer(ctx, &runtimeapi.CheckpointContainerRequest{ContainerId: "test-container-id"})
+			}, codes.PermissionDenied),
+			Entry("ContainerStatus of the pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: "test-container-id"})
+			}, codes.OK),
+			Entry("ContainerStatus of another pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: "other-container-id"})
+			}, codes.PermissionDenied),
+			Entry("ExecSync in the pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ExecSync(ctx, &runtimeapi.ExecSyncRequest{ContainerId: "test-container-id"})
+			}, codes.OK),
+			Entry("ExecSync in another pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ExecSync(ctx, &runtimeapi.ExecSyncRequest{ContainerId: "other-container-id"})
+			}, codes.PermissionDenied),
+			Entry("Exec in the pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.Exec(ctx, &runtimeapi.ExecRequest{ContainerId: "test-container-id"})
+			}, codes.OK),
+			Entry("Exec in another pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.Exec(ctx, &runtimeapi.ExecRequest{ContainerId: "other-container-id"})
+			}, codes.PermissionDenied),
+			Entry("ReopenContainerLog of the pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ReopenContainerLog(ctx, &runtimeapi.ReopenContainerLogRequest{ContainerId: "test-container-id"})
+			}, codes.OK),
+			Entry("ReopenContainerLog of another pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ReopenContainerLog(ctx, &runtimeapi.ReopenContainerLogRequest{ContainerId: "other-container-id"})
+			}, codes.PermissionDenied),
+			Entry("RemoveContainer of another pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.RemoveContainer(ctx, &runtimeapi.RemoveContainerRequest{ContainerId: "other-container-id"})
+			}, codes.PermissionDenied),
+			Entry("CreateContainer in the pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.CreateContainer(ctx, &runtimeapi.CreateContainerRequest{
+					PodSandboxId: "test-sandbox-id",
+					Config:       &runtimeapi.ContainerConfig{Metadata: &runtimeapi.ContainerMetadata{Name: "new"}},
+				})
+			}, codes.OK),
+			Entry("CreateContainer in another pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.CreateContainer(ctx, &runtimeapi.CreateContainerRequest{PodSandboxId: "other-sandbox-id"})
+			}, codes.PermissionDenied),
+			Entry("PodSandboxStatus of the pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{PodSandboxId: "test-sandbox-id"})
+			}, codes.OK),
+			Entry("PodSandboxStatus of another pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{PodSandboxId: "other-sandbox-id"})
+			}, codes.PermissionDenied),
+			Entry("PodSandboxStats of the pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.PodSandboxStats(ctx, &runtimeapi.PodSandboxStatsRequest{PodSandboxId: "test-sandbox-id"})
+			}, codes.OK),
+			Entry("PodSandboxStats of another pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.PodSandboxStats(ctx, &runtimeapi.PodSandboxStatsRequest{PodSandboxId: "other-sandbox-id"})
+			}, codes.PermissionDenied),
+			Entry("PortForward of another pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.PortForward(ctx, &runtimeapi.PortForwardRequest{PodSandboxId: "other-sandbox-id", Port: []int32{8080}})
+			}, codes.PermissionDenied),
+			Entry("ListContainers", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+			}, codes.OK),
+			Entry("ListContainers filtering on the pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ListContainers(ctx, &runtimeapi.ListContainersRequest{
+					Filter: &runtimeapi.ContainerFilter{PodSandboxId: "test-sandbox-id"},
+				})
+			}, codes.OK),
+			Entry("ListContainers filtering on another pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ListContainers(ctx, &runtimeapi.ListContainersRequest{
+					Filter: &runtimeapi.ContainerFilter{PodSandboxId: "other-sandbox-id"},
+				})
+			}, codes.PermissionDenied),
+			Entry("ListPodSandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{})
+			}, codes.OK),
+			Entry("ListPodSandbox filtering on another pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{
+					Filter: &runtimeapi.PodSandboxFilter{Id: "other-sandbox-id"},
+				})
+			}, codes.PermissionDenied),
+			Entry("ListContainerStats", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ListContainerStats(ctx, &runtimeapi.ListContainerStatsRequest{})
+			}, codes.OK),
+			Entry("ListContainerStats filtering on another pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ListContainerStats(ctx, &runtimeapi.ListContainerStatsRequest{
+					Filter: &runtimeapi.ContainerStatsFilter{PodSandboxId: "other-sandbox-id"},
+				})
+			}, codes.PermissionDenied),
+			Entry("ListPodSandboxStats", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ListPodSandboxStats(ctx, &runtimeapi.ListPodSandboxStatsRequest{})
+			}, codes.OK),
+			Entry("ListPodSandboxStats filtering on another pod sandbox", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ListPodSandboxStats(ctx, &runtimeapi.ListPodSandboxStatsRequest{
+					Filter: &runtimeapi.PodSandboxStatsFilter{Id: "other-sandbox-id"},
+				})
+			}, codes.PermissionDenied),
+			Entry("ListPodSandboxMetrics", func(ctx context.Context, c clients) (proto.Message, error) {
+				return c.runtime.ListPodSandboxMetrics(ctx, &runtimeapi.ListPodSandboxMetricsRequest{})
+			}, codes.OK),
+		)
+
+		It("should narrow the filters of list requests like the built-in PodScoped policy", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			for _, c := range []clients{builtin, declarative} {
+				_, err := c.runtime.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(c.mock.LastListPodSandboxRequest().GetFilter().GetId()).To(Equal("test-sandbox-id"))
+
+				_, err = c.runtime.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{
+					Filter: &runtimeapi.PodSandboxFilter{State: &runtimeapi.PodSandboxStateValue{}},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(c.mock.LastListPodSandboxRequest().GetFilter().GetId()).To(Equal("test-sandbox-id"))
+				Expect(c.mock.LastListPodSandboxRequest().GetFilter().GetState()).NotTo(BeNil())
+			}
+		})
+
+		It("should stream the events of the pod sandbox like the built-in PodScoped policy", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			for _, c := range []clients{builtin, declarative} {
+				stream, err := c.runtime.GetContainerEvents(ctx, &runtimeapi.GetEventsRequest{})
+				Expect(err).NotTo(HaveOccurred())
+
+				var containerIDs []string
+
+				for {
+					event, err := stream.Recv()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+
+					Expect(err).NotTo(HaveOccurred())
+
+					containerIDs = append(containerIDs, event.GetContainerId())
+				}
+
+				Expect(containerIDs).To(Equal([]string{"test-container-id"}))
+			}
+		})
+	})
+
+	Context("with pod scoped rules", func() {
+		var (
+			mock              *fake.Server
+			runtimeClient     runtimeapi.RuntimeServiceClient
+			imageClient       runtimeapi.ImageServiceClient
+			cleanup           func()
+			podSandboxID      = "test-sandbox-id"
+			otherPodSandboxID = "other-sandbox-id"
+			containerID1      = "container-id-1"
+			containerID2      = "container-id-2"
+		)
+
+		BeforeEach(func() {
+			runtimeClient, imageClient, mock, cleanup = setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+				p, err := policy.NewDeclarativePolicy("podscoped-static", []policy.Rule{
+					{Method: "/runtime.v1.ImageService/ImageFsInfo", Action: policy.ActionAllow},
+					{Method: "/runtime.v1.ImageService/*", Action: policy.ActionDeny},
+					{Method: "/runtime.v1.RuntimeService/Version", Action: policy.ActionAllow},
+					{
+						Method: "/runtime.v1.RuntimeService/StopContainer",
+						Action: policy.ActionAllow,
+						Conditions: []policy.Condition{
+							{Field: "ContainerId", Operator: policy.OperatorBelongsToPod, Value: podSandboxID},
+						},
+					},
+					{
+						Method: "/runtime.v1.RuntimeService/PodSandboxStatus",
+						Action: policy.ActionAllow,
+						Conditions: []policy.Condition{
+							{Field: "PodSandboxId", Operator: policy.OperatorEquals, Value: podSandboxID},
+						},
+					},
+					{
+						Method: "/runtime.v1.RuntimeService/ListContainers",
+						Action: policy.ActionAllow,
+						Filters: []policy.Filter{
+							{Field: "Containers", FilterField: "PodSandboxId", Operator: policy.OperatorEquals, Value: podSandboxID},
+						},
+					},
+					{
+						Method: "/runtime.v1.RuntimeService/ListPodSandboxMetrics",
+						Action: policy.ActionAllow,
+						Filters: []policy.Filter{
+							{Field: "PodMetrics", FilterField: "PodSandboxId", Operator: policy.OperatorEquals, Value: podSandboxID},
+							{Field: "PodMetrics.ContainerMetrics", FilterField: "ContainerId", Operator: policy.OperatorBelongsToPod, Value: podSandboxID},
+						},
+					},
+					{
+						Method: "/runtime.v1.RuntimeService/ListContainerStats",
+						Action: policy.ActionAllow,
+						Filters: []policy.Filter{
+							{Field: "Stats", FilterField: "Attributes.Id", Operator: policy.OperatorBelongsToPod, Value: podSandboxID},
+						},
+					},
+					{
+						Method: "/runtime.v1.RuntimeService/ContainerStatus",
+						Action: policy.ActionAllow,
+						Filters: []policy.Filter{
+							{FilterField: "Status.Id", Operator: policy.OperatorBelongsToPod, Value: podSandboxID},
+						},
+					},
+					{
+						Method: "/runtime.v1.RuntimeService/GetContainerEvents",
+						Action: policy.ActionAllow,
+						Filters: []policy.Filter{
+							{FilterField: "ContainerId", Operator: policy.OperatorBelongsToPod, Value: podSandboxID},
+						},
+					},
+				}, proxyServer.GetRuntimeClient())
+				Expect(err).NotTo(HaveOccurred())
+
+				return []policy.Policy{p}
+			})
+
+			mock.SetContainers([]*runtimeapi.Container{
+				{Id: containerID1, PodSandboxId: podSandboxID},
+				{Id: containerID2, PodSandboxId: otherPodSandboxID},
+			})
+		})
+
+		AfterEach(func() {
+			cleanup()
+		})
+
+		It("should evaluate rules in order", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := imageClient.ImageFsInfo(ctx, &runtimeapi.ImageFsInfoRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = imageClient.ListImages(ctx, &runtimeapi.ListImagesRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+
+		It("should deny methods not matched by any rule", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.Status(ctx, &runtimeapi.StatusRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(err.Error()).To(ContainSubstring("method not allowed by policy"))
+		})
+
+		It("should enforce equals conditions", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{PodSandboxId: podSandboxID})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{PodSandboxId: otherPodSandboxID})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+
+		It("should enforce belongsToPod conditions", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.StopContainer(ctx, &runtimeapi.StopContainerRequest{ContainerId: containerID2})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			_, err = runtimeClient.StopContainer(ctx, &runtimeapi.StopContainerRequest{ContainerId: "unknown-container"})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+
+		It("should filter list responses", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			resp, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetContainers()).To(HaveLen(1))
+			Expect(resp.GetContainers()[0].GetId()).To(Equal(containerID1))
+		})
+
+		It("should filter repeated fields by a nested field path", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			mock.SetContainerStats([]*runtimeapi.ContainerStats{
+				{Attributes: &runtimeapi.ContainerAttributes{Id: containerID1}},
+				{Attributes: &runtimeapi.ContainerAttributes{Id: containerID2}},
+			})
+
+			resp, err := runtimeClient.ListContainerStats(ctx, &runtimeapi.ListContainerStatsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetStats()).To(HaveLen(1))
+			Expect(resp.GetStats()[0].GetAttributes().GetId()).To(Equal(containerID1))
+		})
+
+		It("should filter repeated fields nested in repeated messages", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			mock.SetPodSandboxMetrics([]*runtimeapi.PodSandboxMetrics{
+				{
+					PodSandboxId: podSandboxID,
+					ContainerMetrics: []*runtimeapi.ContainerMetrics{
+						{ContainerId: containerID1},
+						{ContainerId: containerID2},
+					},
+				},
+				{PodSandboxId: otherPodSandboxID},
+			})
+
+			resp, err := runtimeClient.ListPodSandboxMetrics(ctx, &runtimeapi.ListPodSandboxMetricsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetPodMetrics()).To(HaveLen(1))
+			Expect(resp.GetPodMetrics()[0].GetPodSandboxId()).To(Equal(podSandboxID))
+			Expect(resp.GetPodMetrics()[0].GetContainerMetrics()).To(HaveLen(1))
+			Expect(resp.GetPodMetrics()[0].GetContainerMetrics()[0].GetContainerId()).To(Equal(containerID1))
+		})
+
+		It("should deny unary responses that do not match a whole-message filter", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: containerID1})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: containerID2})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+
+		It("should drop streamed messages that do not match a filter", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			mock.SetEmittedEvents([]*runtimeapi.ContainerEventResponse{
+				{ContainerId: containerID2},
+				{ContainerId: containerID1},
+				{ContainerId: containerID2},
+			})
+
+			stream, err := runtimeClient.GetContainerEvents(ctx, &runtimeapi.GetEventsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			event, err := stream.Recv()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(event.GetContainerId()).To(Equal(containerID1))
+
+			_, err = stream.Recv()
+			Expect(err).To(Equal(io.EOF))
+		})
+	})
+})
@@ -0,0 +1,241 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// ErrorDomain is the domain of the ErrorInfo details attached to the errors of
+// the policies.
+const ErrorDomain = "cri-lite.io"
+
+// Reasons of the ErrorInfo details attached to the errors of the policies.
+const (
+	ReasonMethodNotAllowed          = "METHOD_NOT_ALLOWED"
+	ReasonContainerNotInPod         = "CONTAINER_NOT_IN_POD"
+	ReasonPIDResolutionFailed       = "PID_RESOLUTION_FAILED"
+	ReasonCallerUnknown             = "CALLER_UNKNOWN"
+	ReasonCallerNotAllowed          = "CALLER_NOT_ALLOWED"
+	ReasonExecutableNotAllowed      = "EXECUTABLE_NOT_ALLOWED"
+	ReasonNotAuthorizedByAnnotation = "NOT_AUTHORIZED_BY_ANNOTATION"
+	ReasonCommandNotAllowed         = "COMMAND_NOT_ALLOWED"
+	ReasonContainerConfigNotAllowed = "CONTAINER_CONFIG_NOT_ALLOWED"
+	ReasonResourcesOutOfBounds      = "RESOURCES_OUT_OF_BOUNDS"
+	ReasonPortNotAllowed            = "PORT_NOT_ALLOWED"
+	ReasonImageNotAllowed           = "IMAGE_NOT_ALLOWED"
+	ReasonImageInUse                = "IMAGE_IN_USE"
+	ReasonImageProtected            = "IMAGE_PROTECTED"
+	ReasonRateLimited               = "RATE_LIMITED"
+	ReasonTooManyInFlight           = "TOO_MANY_IN_FLIGHT"
+	ReasonRuntimeLookupFailed       = "RUNTIME_LOOKUP_FAILED"
+	ReasonEvaluationFailed          = "EVALUATION_FAILED"
+)
+
+// Keys of the metadata of the ErrorInfo details.
+const (
+	MetadataPolicy       = "policy"
+	MetadataRule         = "rule"
+	MetadataPodSandboxID = "podSandboxId"
+	MetadataContainerID  = "containerId"
+	MetadataUID          = "uid"
+	MetadataPort         = "port"
+)
+
+// ErrPIDResolutionFailed is the error of the calls whose caller could not be
+// mapped to a pod sandbox.
+var ErrPIDResolutionFailed = errors.New("failed to get pod sandbox ID from PID")
+
+// reasonErrors are the errors that the errors of each reason match on the
+// client side, see FromError.
+var reasonErrors = map[string][]error{
+	ReasonMethodNotAllowed:          {ErrMethodNotAllowed},
+	ReasonContainerNotInPod:         {ErrContainerNotInPod, ErrMethodNotAllowed},
+	ReasonPIDResolutionFailed:       {ErrPIDResolutionFailed},
+	ReasonCallerNotAllowed:          {ErrCallerNotAllowed},
+	ReasonExecutableNotAllowed:      {ErrExecutableNotAllowed},
+	ReasonNotAuthorizedByAnnotation: {ErrNotAuthorizedByAnnotation},
+	ReasonCommandNotAllowed:         {ErrCommandNotAllowed},
+	ReasonContainerConfigNotAllowed: {ErrContainerConfigNotAllowed},
+	ReasonResourcesOutOfBounds:      {ErrResourcesOutOfBounds},
+	ReasonPortNotAllowed:            {ErrPortNotAllowed},
+	ReasonImageNotAllowed:           {ErrImageNotAllowed},
+	ReasonImageInUse:                {ErrImageInUse},
+	ReasonImageProtected:            {ErrImageProtected},
+	ReasonRateLimited:               {ErrRateLimited},
+	ReasonTooManyInFlight:           {ErrTooManyInFlight},
+}
+
+// Error is an error of a policy as received by a client.
+type Error struct {
+	// Reason is the reason of the ErrorInfo of the error.
+	Reason string
+	// Metadata is the metadata of the ErrorInfo of the error.
+	Metadata map[string]string
+
+	status *status.Status
+}
+
+// FromError returns the Error of a policy carried by the gRPC status of err,
+// or err itself if it carries none. The returned error matches the errors of
+// this package with errors.Is, e.g. ErrMethodNotAllowed.
+func FromError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	info := errorInfo(st)
+	if info == nil {
+		return err
+	}
+
+	return &Error{
+		Reason:   info.GetReason(),
+		Metadata: info.GetMetadata(),
+		status:   st,
+	}
+}
+
+func (e *Error) Error() string {
+	return e.status.Message()
+}
+
+// Unwrap returns the errors matched by the reason of e.
+func (e *Error) Unwrap() []error {
+	return reasonErrors[e.Reason]
+}
+
+// GRPCStatus returns the gRPC status of e.
+func (e *Error) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// newError returns a gRPC status error with an ErrorInfo of reason. Metadata
+// can be nil.
+func newError(code codes.Code, reason string, metadata map[string]string, format string, a ...interface{}) error {
+	st := status.Newf(code, format, a...)
+
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   ErrorDomain,
+		Metadata: metadata,
+	})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// errorInfo returns the ErrorInfo of the policies in st, or nil if there is none.
+func errorInfo(st *status.Status) *errdetails.ErrorInfo {
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.GetDomain() == ErrorDomain {
+			return info
+		}
+	}
+
+	return nil
+}
+
+// withPolicyName adds the name of the policy to the ErrorInfo of err, unless
+// it already names one. Errors without an ErrorInfo of the policies, like the
+// errors of the runtime, are returned as is.
+func withPolicyName(err error, name string) error {
+	st, ok := status.FromError(err)
+	if !ok || err == nil {
+		return err
+	}
+
+	proto := st.Proto()
+
+	for i, detail := range proto.GetDetails() {
+		var info errdetails.ErrorInfo
+
+		if detail.UnmarshalTo(&info) != nil || info.GetDomain() != ErrorDomain {
+			continue
+		}
+
+		if _, named := info.GetMetadata()[MetadataPolicy]; named {
+			return err
+		}
+
+		if info.Metadata == nil {
+			info.Metadata = make(map[string]string)
+		}
+
+		info.Metadata[MetadataPolicy] = name
+
+		named, marshalErr := anypb.New(&info)
+		if marshalErr != nil {
+			return err
+		}
+
+		proto.Details[i] = named
+
+		return status.FromProto(proto).Err()
+	}
+
+	return err
+}
+
+// Interceptors returns the interceptors of a policy, which add the name of
+// the policy to the errors it returns.
+func Interceptors(p Policy) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	return namedUnaryInterceptor(p), namedStreamInterceptor(p)
+}
+
+func namedUnaryInterceptor(p Policy) grpc.UnaryServerInterceptor {
+	next := p.UnaryInterceptor()
+
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		resp, err := next(ctx, req, info, handler)
+
+		return resp, withPolicyName(err, p.Name())
+	}
+}
+
+func namedStreamInterceptor(p Policy) grpc.StreamServerInterceptor {
+	next := p.StreamInterceptor()
+
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		err := next(srv, ss, info, func(srv interface{}, wrapped grpc.ServerStream) error {
+			return handler(srv, &namedStream{ServerStream: wrapped, name: p.Name()})
+		})
+
+		return withPolicyName(err, p.Name())
+	}
+}
+
+// namedStream adds the name of a policy to the errors of the stream wrapped
+// by the policy, so that the errors of its filters name it.
+type namedStream struct {
+	grpc.ServerStream
+
+	name string
+}
+
+func (s *namedStream) RecvMsg(m interface{}) error {
+	return withPolicyName(s.ServerStream.RecvMsg(m), s.name)
+}
+
+func (s *namedStream) SendMsg(m interface{}) error {
+	return withPolicyName(s.ServerStream.SendMsg(m), s.name)
+}
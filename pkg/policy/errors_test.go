@@ -0,0 +1,228 @@
+package policy_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/fake"
+	"cri-lite/pkg/policy"
+	"cri-lite/pkg/proxy"
+)
+
+var _ = Describe("Policy Errors", func() {
+	var (
+		mock          *fake.Server
+		runtimeClient runtimeapi.RuntimeServiceClient
+		imageClient   runtimeapi.ImageServiceClient
+		cleanup       func()
+		newPolicy     func(runtimeapi.RuntimeServiceClient) policy.Policy
+	)
+
+	JustBeforeEach(func() {
+		runtimeClient, imageClient, mock, cleanup = setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+			return []policy.Policy{newPolicy(proxyServer.GetRuntimeClient())}
+		})
+
+		mock.SetContainers([]*runtimeapi.Container{
+			{Id: "own-container", PodSandboxId: "own-pod"},
+			{Id: "other-container", PodSandboxId: "other-pod"},
+		})
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	errorInfo := func(err error) *errdetails.ErrorInfo {
+		for _, detail := range status.Convert(err).Details() {
+			if info, ok := detail.(*errdetails.ErrorInfo); ok {
+				return info
+			}
+		}
+
+		return nil
+	}
+
+	Context("with a PodScoped policy", func() {
+		BeforeEach(func() {
+			newPolicy = func(runtimeClient runtimeapi.RuntimeServiceClient) policy.Policy {
+				return policy.NewPodScopedPolicy("own-pod", false, runtimeClient)
+			}
+		})
+
+		It("should describe denied methods", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := imageClient.ListImages(ctx, &runtimeapi.ListImagesRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			info := errorInfo(err)
+			Expect(info).NotTo(BeNil())
+			Expect(info.GetDomain()).To(Equal(policy.ErrorDomain))
+			Expect(info.GetReason()).To(Equal(policy.ReasonMethodNotAllowed))
+			Expect(info.GetMetadata()).To(HaveKeyWithValue(policy.MetadataPolicy, "podScoped"))
+
+			Expect(errors.Is(policy.FromError(err), policy.ErrMethodNotAllowed)).To(BeTrue())
+			Expect(errors.Is(policy.FromError(err), policy.ErrContainerNotInPod)).To(BeFalse())
+		})
+
+		It("should describe containers of other pod sandboxes", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: "other-container"})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			info := errorInfo(err)
+			Expect(info).NotTo(BeNil())
+			Expect(info.GetReason()).To(Equal(policy.ReasonContainerNotInPod))
+			Expect(info.GetMetadata()).To(HaveKeyWithValue(policy.MetadataContainerID, "other-container"))
+			Expect(info.GetMetadata()).To(HaveKeyWithValue(policy.MetadataPodSandboxID, "own-pod"))
+
+			Expect(errors.Is(policy.FromError(err), policy.ErrContainerNotInPod)).To(BeTrue())
+			Expect(errors.Is(policy.FromError(err), policy.ErrMethodNotAllowed)).To(BeTrue())
+			Expect(policy.FromError(err).Error()).To(Equal(status.Convert(err).Message()))
+		})
+	})
+
+	Context("with a declarative policy", func() {
+		var restore func()
+
+		BeforeEach(func() {
+			restore = policy.SetPodSandboxIDFromPID(func(context.Context, runtimeapi.RuntimeServiceClient, int32) (string, error) {
+				return "", errNotInPod
+			})
+
+			newPolicy = func(runtimeClient runtimeapi.RuntimeServiceClient) policy.Policy {
+				p, err := policy.NewDeclarativePolicy("rules", []policy.Rule{
+					{ID: "no-exec", Method: "/runtime.v1.RuntimeService/ExecSync", Action: policy.ActionDeny},
+					{Method: "/runtime.v1.RuntimeService/Attach", Action: policy.ActionDeny},
+					{
+						Method: "/runtime.v1.RuntimeService/ContainerStatus",
+						Action: policy.ActionAllow,
+						Conditions: []policy.Condition{
+							{Field: "ContainerId", Operator: policy.OperatorBelongsToPod, Source: policy.SourcePodSandboxIDFromPID},
+						},
+					},
+				}, runtimeClient)
+				Expect(err).NotTo(HaveOccurred())
+
+				return p
+			}
+		})
+
+		AfterEach(func() {
+			restore()
+		})
+
+		It("should name the rules denying calls", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.ExecSync(ctx, &runtimeapi.ExecSyncRequest{ContainerId: "own-container"})
+			Expect(errorInfo(err).GetMetadata()).To(HaveKeyWithValue(policy.MetadataRule, "no-exec"))
+			Expect(errorInfo(err).GetMetadata()).To(HaveKeyWithValue(policy.MetadataPolicy, "rules"))
+
+			_, err = runtimeClient.Attach(ctx, &runtimeapi.AttachRequest{ContainerId: "own-container"})
+			Expect(errorInfo(err).GetMetadata()).To(HaveKeyWithValue(policy.MetadataRule, "1"))
+
+			By("calling a method matched by no rule")
+			_, err = runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(errorInfo(err).GetReason()).To(Equal(policy.ReasonMethodNotAllowed))
+			Expect(errorInfo(err).GetMetadata()).NotTo(HaveKey(policy.MetadataRule))
+		})
+
+		It("should describe callers outside of pod sandboxes", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: "own-container"})
+			Expect(status.Code(err)).To(Equal(codes.Internal))
+			Expect(errorInfo(err).GetReason()).To(Equal(policy.ReasonPIDResolutionFailed))
+			Expect(errors.Is(policy.FromError(err), policy.ErrPIDResolutionFailed)).To(BeTrue())
+		})
+	})
+
+	Context("with a declarative policy resolving the pod sandbox of the caller", func() {
+		var restore func()
+
+		BeforeEach(func() {
+			restore = policy.SetPodSandboxIDFromPID(func(context.Context, runtimeapi.RuntimeServiceClient, int32) (string, error) {
+				return "own-pod", nil
+			})
+
+			newPolicy = func(runtimeClient runtimeapi.RuntimeServiceClient) policy.Policy {
+				p, err := policy.NewDeclarativePolicy("rules", []policy.Rule{
+					{
+						Method: "/runtime.v1.RuntimeService/ContainerStatus",
+						Action: policy.ActionAllow,
+						Conditions: []policy.Condition{
+							{Field: "ContainerId", Operator: policy.OperatorBelongsToPod, Source: policy.SourcePodSandboxIDFromPID},
+						},
+					},
+				}, runtimeClient)
+				Expect(err).NotTo(HaveOccurred())
+
+				return p
+			}
+		})
+
+		AfterEach(func() {
+			restore()
+		})
+
+		It("should name the pod sandbox of the caller", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: "other-container"})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(errorInfo(err).GetMetadata()).To(HaveKeyWithValue(policy.MetadataPodSandboxID, "own-pod"))
+		})
+	})
+
+	Context("with a PodScoped policy resolving the pod sandbox of the caller", func() {
+		var restore func()
+
+		BeforeEach(func() {
+			restore = policy.SetContainerIDFromPID(func(context.Context, int32) (string, error) {
+				return "removed-container", nil
+			})
+
+			newPolicy = func(runtimeClient runtimeapi.RuntimeServiceClient) policy.Policy {
+				return policy.NewPodScopedPolicy("", true, runtimeClient)
+			}
+		})
+
+		AfterEach(func() {
+			restore()
+		})
+
+		It("should name the container of callers whose pod sandbox is not found", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: "own-container"})
+			Expect(status.Code(err)).To(Equal(codes.Internal))
+			Expect(errorInfo(err).GetReason()).To(Equal(policy.ReasonPIDResolutionFailed))
+			Expect(errorInfo(err).GetMetadata()).To(HaveKeyWithValue(policy.MetadataContainerID, "removed-container"))
+		})
+	})
+})
+
+var _ = Describe("FromError", func() {
+	It("should return errors without details as is", func() {
+		err := status.Error(codes.Unavailable, "runtime is down")
+		Expect(policy.FromError(err)).To(BeIdenticalTo(err))
+		Expect(policy.FromError(nil)).To(Succeed())
+	})
+})
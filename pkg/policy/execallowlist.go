@@ -0,0 +1,249 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"gopkg.in/yaml.v3"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+var (
+	ErrInvalidExecAllowlist = errors.New("invalid exec allowlist")
+	ErrCommandNotAllowed    = errors.New("command not allowed")
+)
+
+// AnyContainer is the key of the commands allowed in containers that have no
+// commands of their own in an ExecAllowlist.
+const AnyContainer = "*"
+
+// ExecAllowlist is the configuration of the ExecAllowlist policy.
+type ExecAllowlist struct {
+	// MaxTimeoutSeconds caps the timeout of ExecSync calls. Zero means no cap.
+	MaxTimeoutSeconds int64 `yaml:"max-timeout-seconds,omitempty"`
+	// Containers maps container names to the commands that can be run in them.
+	Containers map[string][]ExecCommand `yaml:"containers"`
+}
+
+// ExecCommand is an argv pattern. A command matches if it has as many
+// arguments as the pattern and every argument matches its pattern.
+type ExecCommand []ArgPattern
+
+// ArgPattern matches a single argument. At most one of the fields can be set;
+// if neither Prefix nor Regex is, the argument must be equal to Exact.
+type ArgPattern struct {
+	Exact  string `yaml:"exact,omitempty"`
+	Prefix string `yaml:"prefix,omitempty"`
+	// Regex must match the whole argument.
+	Regex string `yaml:"regex,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// UnmarshalYAML allows an argument pattern to be written as a plain string,
+// which is matched exactly.
+func (a *ArgPattern) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		a.Exact = value.Value
+
+		return nil
+	}
+
+	type plain ArgPattern
+
+	return value.Decode((*plain)(a))
+}
+
+func (a *ArgPattern) compile() error {
+	set := 0
+
+	for _, v := range []string{a.Exact, a.Prefix, a.Regex} {
+		if v != "" {
+			set++
+		}
+	}
+
+	if set > 1 {
+		return fmt.Errorf("%w: only one of exact, prefix and regex can be set", ErrInvalidExecAllowlist)
+	}
+
+	if a.Regex != "" {
+		re, err := regexp.Compile("^(?:" + a.Regex + ")$")
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidExecAllowlist, err)
+		}
+
+		a.regex = re
+	}
+
+	return nil
+}
+
+func (a *ArgPattern) matches(arg string) bool {
+	switch {
+	case a.regex != nil:
+		return a.regex.MatchString(arg)
+	case a.Prefix != "":
+		return strings.HasPrefix(arg, a.Prefix)
+	default:
+		return arg == a.Exact
+	}
+}
+
+func (c ExecCommand) matches(cmd []string) bool {
+	if len(cmd) != len(c) {
+		return false
+	}
+
+	for i := range c {
+		if !c[i].matches(cmd[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// execAllowlistPolicy restricts the commands run by Exec and ExecSync. Other
+// methods are passed through, so it is meant to be combined with a policy
+// scoping the containers, e.g. PodScoped.
+type execAllowlistPolicy struct {
+	allowlist     ExecAllowlist
+	runtimeClient runtimeapi.RuntimeServiceClient
+}
+
+// NewExecAllowlistPolicy creates a new ExecAllowlist policy.
+func NewExecAllowlistPolicy(allowlist ExecAllowlist, runtimeClient runtimeapi.RuntimeServiceClient) (Policy, error) {
+	if allowlist.MaxTimeoutSeconds < 0 {
+		return nil, fmt.Errorf("%w: max-timeout-seconds must not be negative", ErrInvalidExecAllowlist)
+	}
+
+	for name, commands := range allowlist.Containers {
+		for _, command := range commands {
+			if len(command) == 0 {
+				return nil, fmt.Errorf("%w: empty command for container %q", ErrInvalidExecAllowlist, name)
+			}
+
+			for i := range command {
+				err := command[i].compile()
+				if err != nil {
+					return nil, fmt.Errorf("container %q: %w", name, err)
+				}
+			}
+		}
+	}
+
+	return &execAllowlistPolicy{
+		allowlist:     allowlist,
+		runtimeClient: runtimeClient,
+	}, nil
+}
+
+// Name implements the Policy interface.
+func (p *execAllowlistPolicy) Name() string {
+	return "execAllowlist"
+}
+
+// UnaryInterceptor implements the Policy interface.
+func (p *execAllowlistPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		interceptor := func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			switch r := req.(type) {
+			case *runtimeapi.ExecSyncRequest:
+				err := p.verifyCommand(ctx, r.GetContainerId(), r.GetCmd())
+				if err != nil {
+					return nil, err
+				}
+
+				maxTimeout := p.allowlist.MaxTimeoutSeconds
+				if maxTimeout > 0 && (r.GetTimeout() == 0 || r.GetTimeout() > maxTimeout) {
+					klog.FromContext(ctx).V(4).Info("capping ExecSync timeout", "timeout", r.GetTimeout(), "maxTimeout", maxTimeout)
+					r.Timeout = maxTimeout
+				}
+			case *runtimeapi.ExecRequest:
+				err := p.verifyCommand(ctx, r.GetContainerId(), r.GetCmd())
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			return handler(ctx, req)
+		}
+
+		return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return loggingInterceptor(ctx, req, info, handler)
+		})
+	}
+}
+
+// StreamInterceptor implements the Policy interface.
+func (p *execAllowlistPolicy) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		return handler(srv, ss)
+	}
+}
+
+// verifyCommand checks cmd against the commands allowed in the container.
+func (p *execAllowlistPolicy) verifyCommand(ctx context.Context, containerID string, cmd []string) error {
+	name, err := p.containerName(ctx, containerID)
+	if err != nil {
+		return err
+	}
+
+	patternSet := name
+
+	commands, ok := p.allowlist.Containers[name]
+	if !ok {
+		patternSet = AnyContainer
+		commands = p.allowlist.Containers[AnyContainer]
+	}
+
+	for _, command := range commands {
+		if command.matches(cmd) {
+			return nil
+		}
+	}
+
+	klog.FromContext(ctx).V(4).Info("command not allowed", "containerID", containerID, "containerName", name, "patternSet", patternSet, "cmd", cmd)
+
+	return newError(codes.PermissionDenied, ReasonCommandNotAllowed, map[string]string{MetadataContainerID: containerID, MetadataRule: patternSet}, "%s: %q in container %q does not match the commands of %q", ErrCommandNotAllowed, cmd, name, patternSet)
+}
+
+func (p *execAllowlistPolicy) containerName(ctx context.Context, containerID string) (string, error) {
+	resp, err := p.runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{
+		Filter: &runtimeapi.ContainerFilter{
+			Id: containerID,
+		},
+	})
+	if err != nil {
+		return "", newError(codes.Internal, ReasonRuntimeLookupFailed, nil, "failed to list containers: %v", err)
+	}
+
+	if len(resp.GetContainers()) != 1 {
+		return "", newError(codes.PermissionDenied, ReasonCommandNotAllowed, map[string]string{MetadataContainerID: containerID}, "%s: container %s not found", ErrCommandNotAllowed, containerID)
+	}
+
+	return resp.GetContainers()[0].GetMetadata().GetName(), nil
+}
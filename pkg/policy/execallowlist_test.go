@@ -0,0 +1,135 @@
+package policy_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/fake"
+	"cri-lite/pkg/policy"
+	"cri-lite/pkg/proxy"
+)
+
+const execAllowlistConfig = `
+max-timeout-seconds: 10
+containers:
+  probe:
+  - ["/bin/grpc_health_probe", {prefix: "-addr=localhost:"}]
+  - ["cat", {regex: "/tmp/[a-z]+\\.ready"}]
+  "*":
+  - ["/bin/true"]
+`
+
+var _ = Describe("ExecAllowlist Policy", func() {
+	var (
+		mock          *fake.Server
+		runtimeClient runtimeapi.RuntimeServiceClient
+		cleanup       func()
+	)
+
+	BeforeEach(func() {
+		var allowlist policy.ExecAllowlist
+		Expect(yaml.Unmarshal([]byte(execAllowlistConfig), &allowlist)).To(Succeed())
+
+		runtimeClient, _, mock, cleanup = setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+			p, err := policy.NewExecAllowlistPolicy(allowlist, proxyServer.GetRuntimeClient())
+			Expect(err).NotTo(HaveOccurred())
+
+			return []policy.Policy{p}
+		})
+
+		mock.SetContainers([]*runtimeapi.Container{
+			{Id: "probe-id", Metadata: &runtimeapi.ContainerMetadata{Name: "probe"}},
+			{Id: "app-id", Metadata: &runtimeapi.ContainerMetadata{Name: "app"}},
+		})
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	DescribeTable("ExecSync commands",
+		func(containerID string, cmd []string, allowed bool) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.ExecSync(ctx, &runtimeapi.ExecSyncRequest{ContainerId: containerID, Cmd: cmd, Timeout: 1})
+			if allowed {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+				Expect(err.Error()).To(ContainSubstring(policy.ErrCommandNotAllowed.Error()))
+			}
+		},
+		Entry("prefix argument", "probe-id", []string{"/bin/grpc_health_probe", "-addr=localhost:8080"}, true),
+		Entry("regex argument", "probe-id", []string{"cat", "/tmp/app.ready"}, true),
+		Entry("regex matching only part of the argument", "probe-id", []string{"cat", "/tmp/app.ready/../../etc/shadow"}, false),
+		Entry("prefix mismatch", "probe-id", []string{"/bin/grpc_health_probe", "-addr=evil:8080"}, false),
+		Entry("extra argument", "probe-id", []string{"/bin/grpc_health_probe", "-addr=localhost:8080", "-v"}, false),
+		Entry("command of another container", "app-id", []string{"cat", "/tmp/app.ready"}, false),
+		Entry("command of any container", "app-id", []string{"/bin/true"}, true),
+		Entry("commands of any container do not extend named ones", "probe-id", []string{"/bin/true"}, false),
+		Entry("unknown container", "unknown-id", []string{"/bin/true"}, false),
+	)
+
+	It("should report the pattern set consulted", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := runtimeClient.ExecSync(ctx, &runtimeapi.ExecSyncRequest{ContainerId: "app-id", Cmd: []string{"/bin/sh"}})
+		Expect(err).To(MatchError(ContainSubstring(`commands of "*"`)))
+
+		_, err = runtimeClient.ExecSync(ctx, &runtimeapi.ExecSyncRequest{ContainerId: "probe-id", Cmd: []string{"/bin/sh"}})
+		Expect(err).To(MatchError(ContainSubstring(`commands of "probe"`)))
+	})
+
+	It("should cap the ExecSync timeout", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		for _, timeout := range []int64{0, 60} {
+			_, err := runtimeClient.ExecSync(ctx, &runtimeapi.ExecSyncRequest{ContainerId: "app-id", Cmd: []string{"/bin/true"}, Timeout: timeout})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mock.LastExecSyncRequest().GetTimeout()).To(Equal(int64(10)))
+		}
+
+		_, err := runtimeClient.ExecSync(ctx, &runtimeapi.ExecSyncRequest{ContainerId: "app-id", Cmd: []string{"/bin/true"}, Timeout: 5})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mock.LastExecSyncRequest().GetTimeout()).To(Equal(int64(5)))
+	})
+
+	It("should deny Exec commands that are not allowed", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := runtimeClient.Exec(ctx, &runtimeapi.ExecRequest{ContainerId: "probe-id", Cmd: []string{"/bin/sh"}})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+	})
+})
+
+var _ = Describe("NewExecAllowlistPolicy", func() {
+	It("should reject invalid allowlists", func() {
+		_, err := policy.NewExecAllowlistPolicy(policy.ExecAllowlist{
+			Containers: map[string][]policy.ExecCommand{
+				"probe": {{{Prefix: "a", Regex: "b"}}},
+			},
+		}, nil)
+		Expect(err).To(MatchError(policy.ErrInvalidExecAllowlist))
+
+		_, err = policy.NewExecAllowlistPolicy(policy.ExecAllowlist{
+			Containers: map[string][]policy.ExecCommand{
+				"probe": {{{Regex: "("}}},
+			},
+		}, nil)
+		Expect(err).To(MatchError(policy.ErrInvalidExecAllowlist))
+
+		_, err = policy.NewExecAllowlistPolicy(policy.ExecAllowlist{MaxTimeoutSeconds: -1}, nil)
+		Expect(err).To(MatchError(policy.ErrInvalidExecAllowlist))
+	})
+})
@@ -0,0 +1,291 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+// ErrInvalidExecSyncAudit is returned for invalid ExecSync audit
+// configurations.
+var ErrInvalidExecSyncAudit = errors.New("invalid exec sync audit")
+
+// DefaultMaxOutputBytes is the number of bytes of the outputs of ExecSync
+// recorded when the configuration does not say.
+const DefaultMaxOutputBytes = 1024
+
+// ExecSyncAudit is the configuration of the audit of the ExecSync calls of an
+// endpoint.
+type ExecSyncAudit struct {
+	// Endpoint is recorded with every call, so that the records of several
+	// endpoints can be written to the same file.
+	Endpoint string
+	// File is the path of the file the records are appended to as JSON lines.
+	// When empty, the records are logged.
+	File string
+	// MaxOutputBytes is the number of bytes of Stdout and Stderr recorded.
+	// Longer outputs are truncated. Zero defaults to DefaultMaxOutputBytes.
+	MaxOutputBytes int
+	// HashOnly records the lengths and digests of the outputs but not the
+	// outputs themselves.
+	HashOnly bool
+	// OutputSampleRate is the fraction of the calls, between 0 and 1, whose
+	// outputs are recorded. Zero defaults to 1. The lengths and digests of the
+	// outputs are recorded for every call.
+	OutputSampleRate float64
+}
+
+// execSyncAuditPolicy records the ExecSync calls, with their caller and what
+// they returned. It denies nothing, so it goes first in the chain of an
+// endpoint to also record the calls denied by the other policies.
+type execSyncAuditPolicy struct {
+	audit ExecSyncAudit
+
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// NewExecSyncAuditPolicy creates a policy recording the ExecSync calls.
+func NewExecSyncAuditPolicy(audit ExecSyncAudit) (Policy, error) {
+	if audit.MaxOutputBytes < 0 {
+		return nil, fmt.Errorf("%w: max-output-bytes must not be negative", ErrInvalidExecSyncAudit)
+	}
+
+	if audit.OutputSampleRate < 0 || audit.OutputSampleRate > 1 {
+		return nil, fmt.Errorf("%w: output-sample-rate must be between 0 and 1", ErrInvalidExecSyncAudit)
+	}
+
+	if audit.MaxOutputBytes == 0 {
+		audit.MaxOutputBytes = DefaultMaxOutputBytes
+	}
+
+	if audit.OutputSampleRate == 0 {
+		audit.OutputSampleRate = 1
+	}
+
+	p := &execSyncAuditPolicy{audit: audit}
+
+	if audit.File != "" {
+		//nolint:gosec // The path comes from the configuration file.
+		file, err := os.OpenFile(audit.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open exec sync audit file: %w", err)
+		}
+
+		p.writer = file
+	}
+
+	return p, nil
+}
+
+// Name implements the Policy interface.
+func (p *execSyncAuditPolicy) Name() string {
+	return "execSyncAudit"
+}
+
+// UnaryInterceptor implements the Policy interface.
+func (p *execSyncAuditPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		r, ok := req.(*runtimeapi.ExecSyncRequest)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		// The request is recorded as the caller sent it, before the next
+		// policies get to change it.
+		record := p.newRecord(ctx, r)
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		record.DurationMs = time.Since(start).Milliseconds()
+		record.Code = status.Code(err).String()
+
+		if err != nil {
+			record.Error = status.Convert(err).Message()
+		} else if execResp, ok := resp.(*runtimeapi.ExecSyncResponse); ok {
+			record.ExitCode = execResp.GetExitCode()
+			sampled := p.sampleOutput()
+			record.Stdout = p.newOutput(execResp.GetStdout(), sampled)
+			record.Stderr = p.newOutput(execResp.GetStderr(), sampled)
+		}
+
+		p.write(ctx, record)
+
+		return resp, err
+	}
+}
+
+// StreamInterceptor implements the Policy interface.
+func (p *execSyncAuditPolicy) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		return handler(srv, ss)
+	}
+}
+
+// execSyncRecord is the record of an ExecSync call.
+type execSyncRecord struct {
+	Time        time.Time `json:"time"`
+	Endpoint    string    `json:"endpoint,omitempty"`
+	ContainerID string    `json:"containerId"`
+	Cmd         []string  `json:"cmd"`
+	Timeout     int64     `json:"timeout,omitempty"`
+
+	PID        int32  `json:"pid"`
+	UID        uint32 `json:"uid"`
+	GID        uint32 `json:"gid"`
+	Executable string `json:"executable,omitempty"`
+
+	// Code is the gRPC status code of the call, and Error its message when
+	// it failed or was denied.
+	Code       string          `json:"code"`
+	Error      string          `json:"error,omitempty"`
+	DurationMs int64           `json:"durationMs"`
+	ExitCode   int32           `json:"exitCode"`
+	Stdout     *execSyncOutput `json:"stdout,omitempty"`
+	Stderr     *execSyncOutput `json:"stderr,omitempty"`
+}
+
+// execSyncOutput is the record of the standard output or error of an
+// ExecSync call. Data is the beginning of the output when it is recorded.
+// Invalid UTF-8 in Data is replaced when encoded.
+type execSyncOutput struct {
+	Length    int    `json:"length"`
+	SHA256    string `json:"sha256"`
+	Data      string `json:"data,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+func (p *execSyncAuditPolicy) newRecord(ctx context.Context, r *runtimeapi.ExecSyncRequest) *execSyncRecord {
+	record := &execSyncRecord{
+		Time:        time.Now().UTC(),
+		Endpoint:    p.audit.Endpoint,
+		ContainerID: r.GetContainerId(),
+		Cmd:         slices.Clone(r.GetCmd()),
+		Timeout:     r.GetTimeout(),
+	}
+
+	if authInfo, err := callerCredentials(ctx); err == nil {
+		record.PID = authInfo.GetPID()
+		record.UID = authInfo.GetUID()
+		record.GID = authInfo.GetGID()
+		record.Executable = authInfo.GetExecutable()
+	}
+
+	return record
+}
+
+// sampleOutput tells whether the outputs of a call are recorded.
+func (p *execSyncAuditPolicy) sampleOutput() bool {
+	if p.audit.HashOnly {
+		return false
+	}
+
+	return p.audit.OutputSampleRate >= 1 || rand.Float64() < p.audit.OutputSampleRate //nolint:gosec // Sampling needs no secure randomness.
+}
+
+func (p *execSyncAuditPolicy) newOutput(data []byte, sampled bool) *execSyncOutput {
+	digest := sha256.Sum256(data)
+	output := &execSyncOutput{
+		Length: len(data),
+		SHA256: "sha256:" + hex.EncodeToString(digest[:]),
+	}
+
+	if sampled {
+		output.Truncated = len(data) > p.audit.MaxOutputBytes
+		output.Data = string(data[:min(len(data), p.audit.MaxOutputBytes)])
+	}
+
+	return output
+}
+
+// write appends a record to the audit file, or logs it. Failing to write a
+// record does not fail the call, which already ran.
+func (p *execSyncAuditPolicy) write(ctx context.Context, record *execSyncRecord) {
+	logger := klog.FromContext(ctx)
+
+	if p.writer == nil {
+		logger.Info("exec sync", record.keysAndValues()...)
+
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		logger.Error(err, "failed to encode exec sync audit record", "containerID", record.ContainerID)
+
+		return
+	}
+
+	p.mu.Lock()
+	_, err = p.writer.Write(append(line, '\n'))
+	p.mu.Unlock()
+
+	if err != nil {
+		logger.Error(err, "failed to write exec sync audit record", "containerID", record.ContainerID, "cmd", record.Cmd)
+	}
+}
+
+func (r *execSyncRecord) keysAndValues() []interface{} {
+	values := []interface{}{
+		"endpoint", r.Endpoint,
+		"containerID", r.ContainerID,
+		"cmd", r.Cmd,
+		"timeout", r.Timeout,
+		"pid", r.PID,
+		"uid", r.UID,
+		"gid", r.GID,
+		"executable", r.Executable,
+		"code", r.Code,
+		"durationMs", r.DurationMs,
+	}
+
+	if r.Error != "" {
+		return append(values, "error", r.Error)
+	}
+
+	values = append(values, "exitCode", r.ExitCode)
+
+	values = r.Stdout.appendKeysAndValues(values, "stdout")
+
+	return r.Stderr.appendKeysAndValues(values, "stderr")
+}
+
+func (o *execSyncOutput) appendKeysAndValues(values []interface{}, name string) []interface{} {
+	if o == nil {
+		return values
+	}
+
+	values = append(values, name+"Length", o.Length, name+"SHA256", o.SHA256)
+
+	if o.Data == "" && !o.Truncated {
+		return values
+	}
+
+	return append(values, name, o.Data, name+"Truncated", o.Truncated)
+}
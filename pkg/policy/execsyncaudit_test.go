@@ -0,0 +1,197 @@
+package policy_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/fake"
+	"cri-lite/pkg/policy"
+)
+
+var _ = Describe("ExecSyncAudit Policy", func() {
+	var (
+		runtimeClient runtimeapi.RuntimeServiceClient
+		cleanup       func()
+		auditFile     string
+		audit         policy.ExecSyncAudit
+		next          []policy.Policy
+	)
+
+	BeforeEach(func() {
+		audit = policy.ExecSyncAudit{Endpoint: "/run/cri-lite/audited.sock"}
+		next = nil
+	})
+
+	JustBeforeEach(func() {
+		auditFile = filepath.Join(GinkgoT().TempDir(), "exec-sync.log")
+		audit.File = auditFile
+
+		p, err := policy.NewExecSyncAuditPolicy(audit)
+		Expect(err).NotTo(HaveOccurred())
+
+		var mock *fake.Server
+
+		runtimeClient, _, mock, cleanup = setupTestEnvironment(withPolicies(append([]policy.Policy{p}, next...)...))
+
+		mock.SetExecSyncResponse(&runtimeapi.ExecSyncResponse{
+			Stdout:   []byte("hello world"),
+			Stderr:   []byte("oops"),
+			ExitCode: 3,
+		})
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	execSync := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := runtimeClient.ExecSync(ctx, &runtimeapi.ExecSyncRequest{
+			ContainerId: "test-container-id",
+			Cmd:         []string{"cat", "/etc/hostname"},
+			Timeout:     10,
+		})
+
+		return err
+	}
+
+	records := func() []map[string]interface{} {
+		file, err := os.Open(auditFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		defer file.Close()
+
+		var records []map[string]interface{}
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var record map[string]interface{}
+			Expect(json.Unmarshal(scanner.Bytes(), &record)).To(Succeed())
+			records = append(records, record)
+		}
+
+		Expect(scanner.Err()).NotTo(HaveOccurred())
+
+		return records
+	}
+
+	It("should record the call, its caller and its result", func() {
+		Expect(execSync()).To(Succeed())
+
+		Expect(records()).To(ConsistOf(SatisfyAll(
+			HaveKeyWithValue("endpoint", "/run/cri-lite/audited.sock"),
+			HaveKeyWithValue("containerId", "test-container-id"),
+			HaveKeyWithValue("cmd", ConsistOf("cat", "/etc/hostname")),
+			HaveKeyWithValue("timeout", BeEquivalentTo(10)),
+			HaveKeyWithValue("uid", BeEquivalentTo(os.Getuid())),
+			HaveKeyWithValue("pid", BeEquivalentTo(os.Getpid())),
+			HaveKeyWithValue("code", "OK"),
+			HaveKeyWithValue("exitCode", BeEquivalentTo(3)),
+			HaveKeyWithValue("stdout", SatisfyAll(
+				HaveKeyWithValue("length", BeEquivalentTo(11)),
+				HaveKeyWithValue("sha256", "sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"),
+				HaveKeyWithValue("data", "hello world"),
+				Not(HaveKey("truncated")),
+			)),
+			HaveKeyWithValue("stderr", HaveKeyWithValue("data", "oops")),
+		)))
+	})
+
+	It("should append the records of several calls", func() {
+		Expect(execSync()).To(Succeed())
+		Expect(execSync()).To(Succeed())
+
+		Expect(records()).To(HaveLen(2))
+	})
+
+	Context("with a size cap", func() {
+		BeforeEach(func() {
+			audit.MaxOutputBytes = 5
+		})
+
+		It("should truncate the outputs", func() {
+			Expect(execSync()).To(Succeed())
+
+			Expect(records()).To(ConsistOf(SatisfyAll(
+				HaveKeyWithValue("stdout", SatisfyAll(
+					HaveKeyWithValue("length", BeEquivalentTo(11)),
+					HaveKeyWithValue("sha256", "sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"),
+					HaveKeyWithValue("data", "hello"),
+					HaveKeyWithValue("truncated", true),
+				)),
+				HaveKeyWithValue("stderr", SatisfyAll(
+					HaveKeyWithValue("data", "oops"),
+					Not(HaveKey("truncated")),
+				)),
+			)))
+		})
+	})
+
+	Context("with hash-only", func() {
+		BeforeEach(func() {
+			audit.HashOnly = true
+		})
+
+		It("should only record the digests of the outputs", func() {
+			Expect(execSync()).To(Succeed())
+
+			Expect(records()).To(ConsistOf(HaveKeyWithValue("stdout", SatisfyAll(
+				HaveKeyWithValue("length", BeEquivalentTo(11)),
+				HaveKey("sha256"),
+				Not(HaveKey("data")),
+			))))
+		})
+	})
+
+	Context("with a policy denying the call", func() {
+		BeforeEach(func() {
+			next = []policy.Policy{policy.NewCallerIDPolicy([]uint32{uint32(os.Getuid()) + 1}, nil)}
+		})
+
+		It("should record the denial", func() {
+			err := execSync()
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			Expect(records()).To(ConsistOf(SatisfyAll(
+				HaveKeyWithValue("cmd", ConsistOf("cat", "/etc/hostname")),
+				HaveKeyWithValue("code", "PermissionDenied"),
+				HaveKey("error"),
+				Not(HaveKey("stdout")),
+			)))
+		})
+	})
+
+	It("should not record other calls", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(records()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("NewExecSyncAuditPolicy", func() {
+	DescribeTable("should reject invalid configurations",
+		func(audit policy.ExecSyncAudit) {
+			_, err := policy.NewExecSyncAuditPolicy(audit)
+			Expect(err).To(MatchError(policy.ErrInvalidExecSyncAudit))
+		},
+		Entry("negative size cap", policy.ExecSyncAudit{MaxOutputBytes: -1}),
+		Entry("negative sample rate", policy.ExecSyncAudit{OutputSampleRate: -0.5}),
+		Entry("sample rate above 1", policy.ExecSyncAudit{OutputSampleRate: 1.5}),
+	)
+})
@@ -0,0 +1,140 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// ErrExecutableNotAllowed is the error of the calls of callers running
+	// an executable that is not allowed.
+	ErrExecutableNotAllowed = errors.New("executable not allowed")
+	// ErrInvalidExecutable is returned for invalid allowed executables.
+	ErrInvalidExecutable = errors.New("invalid executable")
+)
+
+// Executable identifies the callers running on the host, like node agents,
+// for which there is no pod sandbox. A caller matches if it runs the
+// executable at Path, with the SHA-256 digest SHA256 and in the systemd unit
+// Unit when they are set. Only the callers in the mount namespace of cri-lite
+// match, since a container can put any executable at Path.
+type Executable struct {
+	Path   string `yaml:"path"`
+	SHA256 string `yaml:"sha256,omitempty"`
+	Unit   string `yaml:"unit,omitempty"`
+}
+
+// executablePolicy allows the calls of the callers matching one of its
+// executables.
+type executablePolicy struct {
+	executables []Executable
+}
+
+// NewExecutablePolicy creates a policy allowing the callers that match one of
+// executables.
+func NewExecutablePolicy(executables []Executable) (Policy, error) {
+	normalized := make([]Executable, 0, len(executables))
+
+	for i, e := range executables {
+		if !filepath.IsAbs(e.Path) {
+			return nil, fmt.Errorf("%w: executable %d: path %q must be absolute", ErrInvalidExecutable, i, e.Path)
+		}
+
+		if e.SHA256 != "" {
+			digest, err := hex.DecodeString(strings.TrimPrefix(e.SHA256, "sha256:"))
+			if err != nil || len(digest) != 32 {
+				return nil, fmt.Errorf("%w: executable %s: sha256 must be 64 hexadecimal digits", ErrInvalidExecutable, e.Path)
+			}
+
+			e.SHA256 = "sha256:" + hex.EncodeToString(digest)
+		}
+
+		normalized = append(normalized, e)
+	}
+
+	return &executablePolicy{executables: normalized}, nil
+}
+
+// Name implements the Policy interface.
+func (p *executablePolicy) Name() string {
+	return "executable"
+}
+
+// UnaryInterceptor implements the Policy interface.
+func (p *executablePolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		err := p.verifyCaller(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor implements the Policy interface.
+func (p *executablePolicy) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		err := p.verifyCaller(ss.Context())
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+func (p *executablePolicy) verifyCaller(ctx context.Context) error {
+	authInfo, err := callerCredentials(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range p.executables {
+		if e.matches(authInfo) {
+			return nil
+		}
+	}
+
+	// The digest is left out, as it is only computed for the executables
+	// allowed with a digest.
+	klog.FromContext(ctx).V(4).Info("executable not allowed",
+		"pid", authInfo.GetPID(), "executable", authInfo.GetExecutable(), "unit", authInfo.GetUnit())
+
+	return newError(codes.PermissionDenied, ReasonExecutableNotAllowed, nil,
+		"%s: %s in unit %q", ErrExecutableNotAllowed, authInfo.GetExecutable(), authInfo.GetUnit())
+}
+
+func (e *Executable) matches(authInfo peerCredentials) bool {
+	// The executable and unit are empty when they could not be resolved,
+	// which never matches since the path is always set. The executable is
+	// also empty for the callers in another mount namespace.
+	if authInfo.GetExecutable() != e.Path {
+		return false
+	}
+
+	if e.SHA256 != "" && authInfo.GetExecutableDigest() != e.SHA256 {
+		return false
+	}
+
+	return e.Unit == "" || authInfo.GetUnit() == e.Unit
+}
@@ -0,0 +1,151 @@
+package policy_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/policy"
+)
+
+var _ = Describe("Executable Policy", func() {
+	var (
+		runtimeClient runtimeapi.RuntimeServiceClient
+		cleanup       func()
+		executable    string
+		digest        string
+		newPolicy     func() policy.Policy
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		executable, err = os.Executable()
+		Expect(err).NotTo(HaveOccurred())
+
+		data, err := os.ReadFile(executable)
+		Expect(err).NotTo(HaveOccurred())
+
+		sum := sha256.Sum256(data)
+		digest = hex.EncodeToString(sum[:])
+	})
+
+	JustBeforeEach(func() {
+		runtimeClient, _, _, cleanup = setupTestEnvironment(withPolicies(newPolicy()))
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	executablePolicy := func(executables ...policy.Executable) func() policy.Policy {
+		return func() policy.Policy {
+			p, err := policy.NewExecutablePolicy(executables)
+			Expect(err).NotTo(HaveOccurred())
+
+			return p
+		}
+	}
+
+	Context("with the executable of the caller", func() {
+		BeforeEach(func() {
+			newPolicy = func() policy.Policy {
+				return executablePolicy(
+					policy.Executable{Path: "/usr/bin/node-agent"},
+					policy.Executable{Path: executable, SHA256: strings.ToUpper(digest)},
+				)()
+			}
+		})
+
+		It("should allow the caller", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	for _, other := range []struct {
+		name       string
+		executable func() policy.Executable
+	}{
+		{name: "another path", executable: func() policy.Executable {
+			return policy.Executable{Path: "/usr/bin/node-agent"}
+		}},
+		{name: "another digest", executable: func() policy.Executable {
+			return policy.Executable{Path: executable, SHA256: strings.Repeat("0", 64)}
+		}},
+		{name: "another unit", executable: func() policy.Executable {
+			return policy.Executable{Path: executable, Unit: "node-agent.service"}
+		}},
+	} {
+		Context("with "+other.name, func() {
+			BeforeEach(func() {
+				newPolicy = func() policy.Policy {
+					return executablePolicy(other.executable())()
+				}
+			})
+
+			It("should deny the caller", func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+				defer cancel()
+
+				_, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+				Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+				Expect(errors.Is(policy.FromError(err), policy.ErrExecutableNotAllowed)).To(BeTrue())
+			})
+		})
+	}
+
+	Context("with a declarative policy on the executable of the caller", func() {
+		BeforeEach(func() {
+			newPolicy = func() policy.Policy {
+				p, err := policy.NewDeclarativePolicy("executable", []policy.Rule{
+					{
+						Method: "/runtime.v1.RuntimeService/Version",
+						Action: policy.ActionAllow,
+						Conditions: []policy.Condition{
+							{Expression: fmt.Sprintf("caller.executable == %q && caller.executableDigest == 'sha256:%s'", executable, digest)},
+						},
+					},
+				}, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				return p
+			}
+		})
+
+		It("should evaluate the executable of the caller", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("NewExecutablePolicy", func() {
+	DescribeTable("should reject invalid executables",
+		func(executable policy.Executable) {
+			_, err := policy.NewExecutablePolicy([]policy.Executable{executable})
+			Expect(err).To(MatchError(policy.ErrInvalidExecutable))
+		},
+		Entry("relative path", policy.Executable{Path: "node-agent"}),
+		Entry("no path", policy.Executable{Unit: "node-agent.service"}),
+		Entry("short digest", policy.Executable{Path: "/usr/bin/node-agent", SHA256: "abcd"}),
+		Entry("invalid digest", policy.Executable{Path: "/usr/bin/node-agent", SHA256: strings.Repeat("z", 64)}),
+	)
+})
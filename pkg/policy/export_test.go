@@ -0,0 +1,89 @@
+package policy
+
+import (
+	"context"
+	"expvar"
+	"time"
+
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// SetPodSandboxIDFromPID replaces the mapping of caller PIDs to pod sandboxes
+// and returns a function restoring it.
+func SetPodSandboxIDFromPID(f func(context.Context, runtimeapi.RuntimeServiceClient, int32) (string, error)) func() {
+	original := podSandboxIDFromPID
+	podSandboxIDFromPID = f
+
+	return func() {
+		podSandboxIDFromPID = original
+	}
+}
+
+// SetContainerIDFromPID replaces the mapping of caller PIDs to containers and
+// returns a function restoring it.
+func SetContainerIDFromPID(f func(context.Context, int32) (string, error)) func() {
+	original := containerIDFromPID
+	containerIDFromPID = f
+
+	return func() {
+		containerIDFromPID = original
+	}
+}
+
+// SetCallerPodTTL replaces how long the RateLimit policy remembers the pod
+// sandboxes of caller PIDs and returns a function restoring it.
+func SetCallerPodTTL(ttl time.Duration) func() {
+	original := callerPodTTL
+	callerPodTTL = ttl
+
+	return func() {
+		callerPodTTL = original
+	}
+}
+
+// AuditDenials returns how many times an audit policy would have denied
+// requests of method for reason.
+func AuditDenials(p Policy, method, reason string) int {
+	audit, ok := p.(*auditPolicy)
+	if !ok {
+		return 0
+	}
+
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+
+	return audit.denials[auditKey{method: method, reason: reason}]
+}
+
+// PublishedAuditDenials returns the published count of the denials of a
+// policy of an endpoint for reason.
+func PublishedAuditDenials(endpoint, policy, reason string) int64 {
+	count, ok := auditDenials.Get(auditDenialsKey(endpoint, policy, reason)).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+
+	return count.Value()
+}
+
+// ShadowDivergences returns how many times the shadow policies of a shadow
+// policy diverged from its active policies on method.
+func ShadowDivergences(p Policy, method, divergence string) int {
+	shadow, ok := p.(*shadowPolicy)
+	if !ok {
+		return 0
+	}
+
+	shadow.mu.Lock()
+	defer shadow.mu.Unlock()
+
+	return shadow.divergences[shadowKey{method: method, divergence: divergence}]
+}
+
+// MethodClassified reports whether the scoped policies classify method
+// explicitly rather than denying it as unknown.
+func MethodClassified(method string) bool {
+	_, ok := methodScopes[method]
+
+	return ok
+}
@@ -0,0 +1,136 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+var (
+	ErrUnknownMethod = errors.New("unknown CRI method")
+	ErrUnknownField  = errors.New("unknown field")
+)
+
+// methodDescriptor looks up the descriptor of a full gRPC method name such as
+// "/runtime.v1.RuntimeService/ListContainers".
+func methodDescriptor(fullMethod string) (protoreflect.MethodDescriptor, error) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMethod, fullMethod)
+	}
+
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMethod, fullMethod)
+	}
+
+	serviceDesc, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMethod, fullMethod)
+	}
+
+	methodDesc := serviceDesc.Methods().ByName(protoreflect.Name(method))
+	if methodDesc == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMethod, fullMethod)
+	}
+
+	return methodDesc, nil
+}
+
+// fieldByName finds a field either by its proto name ("pod_sandbox_id") or by
+// its Go name ("PodSandboxId"), which is what the policy files use.
+func fieldByName(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if fd := md.Fields().ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+
+	fields := md.Fields()
+	for i := range fields.Len() {
+		if strings.EqualFold(fields.Get(i).JSONName(), name) {
+			return fields.Get(i)
+		}
+	}
+
+	return nil
+}
+
+// resolveFieldPath validates a dot-separated field path against a message
+// descriptor and returns the descriptors of every element of the path.
+func resolveFieldPath(md protoreflect.MessageDescriptor, path string) ([]protoreflect.FieldDescriptor, error) {
+	parts := strings.Split(path, ".")
+	fds := make([]protoreflect.FieldDescriptor, 0, len(parts))
+
+	for i, part := range parts {
+		if md == nil {
+			return nil, fmt.Errorf("%w: %s in %s is not a message", ErrUnknownField, strings.Join(parts[:i], "."), path)
+		}
+
+		fd := fieldByName(md, part)
+		if fd == nil {
+			return nil, fmt.Errorf("%w: %s in %s", ErrUnknownField, part, md.FullName())
+		}
+
+		if i < len(parts)-1 && (fd.IsList() || fd.IsMap()) {
+			return nil, fmt.Errorf("%w: %s in %s is a repeated field", ErrUnknownField, part, path)
+		}
+
+		fds = append(fds, fd)
+		md = fd.Message()
+	}
+
+	return fds, nil
+}
+
+// lookupField returns the value at a dot-separated field path of msg. Unset
+// intermediate messages yield the default value of the final field.
+func lookupField(msg protoreflect.Message, path string) (protoreflect.Value, error) {
+	fds, err := resolveFieldPath(msg.Descriptor(), path)
+	if err != nil {
+		return protoreflect.Value{}, err
+	}
+
+	for _, fd := range fds[:len(fds)-1] {
+		msg = msg.Get(fd).Message()
+	}
+
+	return msg.Get(fds[len(fds)-1]), nil
+}
+
+// setString sets the string field at a dot-separated field path of msg,
+// creating the unset intermediate messages.
+func setString(msg protoreflect.Message, path, value string) error {
+	fds, err := resolveFieldPath(msg.Descriptor(), path)
+	if err != nil {
+		return err
+	}
+
+	for _, fd := range fds[:len(fds)-1] {
+		msg = msg.Mutable(fd).Message()
+	}
+
+	msg.Set(fds[len(fds)-1], protoreflect.ValueOfString(value))
+
+	return nil
+}
+
+// lookupString returns the value at a field path formatted as a string.
+func lookupString(msg protoreflect.Message, path string) (string, error) {
+	v, err := lookupField(msg, path)
+	if err != nil {
+		return "", err
+	}
+
+	return valueString(v), nil
+}
+
+func valueString(v protoreflect.Value) string {
+	if s, ok := v.Interface().(string); ok {
+		return s
+	}
+
+	return v.String()
+}
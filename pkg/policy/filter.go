@@ -0,0 +1,98 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// keepFunc reports whether an element of a filtered field is kept.
+type keepFunc func(elem protoreflect.Message) (bool, error)
+
+// pruneRepeated removes the elements of the repeated message field at path
+// that are rejected by keep. Singular and repeated messages along the path
+// are traversed, so "PodMetrics.ContainerMetrics" prunes the container
+// metrics of every pod.
+func pruneRepeated(msg protoreflect.Message, path []string, keep keepFunc) error {
+	fd := fieldByName(msg.Descriptor(), path[0])
+	if fd == nil || fd.Message() == nil || fd.IsMap() {
+		return fmt.Errorf("%w: %s in %s", ErrUnknownField, path[0], msg.Descriptor().FullName())
+	}
+
+	if !msg.Has(fd) {
+		return nil
+	}
+
+	if len(path) > 1 {
+		if !fd.IsList() {
+			return pruneRepeated(msg.Mutable(fd).Message(), path[1:], keep)
+		}
+
+		list := msg.Mutable(fd).List()
+		for i := range list.Len() {
+			err := pruneRepeated(list.Get(i).Message(), path[1:], keep)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if !fd.IsList() {
+		return fmt.Errorf("%w: %s in %s is not a repeated field", ErrInvalidRule, path[0], msg.Descriptor().FullName())
+	}
+
+	list := msg.Get(fd).List()
+	kept := msg.NewField(fd).List()
+
+	for i := range list.Len() {
+		ok, err := keep(list.Get(i).Message())
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			kept.Append(list.Get(i))
+		}
+	}
+
+	if kept.Len() == 0 {
+		msg.Clear(fd)
+	} else {
+		msg.Set(fd, protoreflect.ValueOfList(kept))
+	}
+
+	return nil
+}
+
+// resolveFilterPath validates the field of a filter against a message
+// descriptor and returns the descriptor of the filtered elements. An empty
+// field filters the message itself.
+func resolveFilterPath(md protoreflect.MessageDescriptor, field string) (protoreflect.MessageDescriptor, error) {
+	if field == "" {
+		return md, nil
+	}
+
+	parts := strings.Split(field, ".")
+	for i, part := range parts {
+		fd := fieldByName(md, part)
+		if fd == nil {
+			return nil, fmt.Errorf("%w: %s in %s", ErrUnknownField, part, md.FullName())
+		}
+
+		if fd.Message() == nil || fd.IsMap() {
+			return nil, fmt.Errorf("%w: %s in %s is not a message field", ErrInvalidRule, part, field)
+		}
+
+		if i == len(parts)-1 && !fd.IsList() {
+			return nil, fmt.Errorf("%w: %s is not a repeated message field", ErrInvalidRule, field)
+		}
+
+		md = fd.Message()
+	}
+
+	return md, nil
+}
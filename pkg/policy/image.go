@@ -3,19 +3,108 @@ package policy
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
 	"strings"
 
+	"github.com/distribution/reference"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
 )
 
+var (
+	ErrInvalidImageManagement = errors.New("invalid image management config")
+	ErrImageNotAllowed        = errors.New("image not allowed")
+	ErrImageInUse             = errors.New("image is in use")
+	ErrImageProtected         = errors.New("image is protected")
+)
+
+// ImageManagement is the configuration of the ImageManagement policy. The
+// zero value allows pulling any image, and removing any image that is neither
+// pinned by the runtime nor used by a container.
+type ImageManagement struct {
+	// AllowedRegistries are the registries images can be pulled from, e.g.
+	// docker.io or registry.k8s.io. Empty means any registry.
+	AllowedRegistries []string `yaml:"allowed-registries,omitempty"`
+	// AllowedRepositories are globs matching the normalized names of the
+	// repositories images can be pulled from, e.g. docker.io/library/*.
+	// Empty means any repository.
+	AllowedRepositories []string `yaml:"allowed-repositories,omitempty"`
+	// RequireDigest only allows pulling images by digest.
+	RequireDigest bool `yaml:"require-digest,omitempty"`
+	// InUseContainerStates are the states, e.g. CONTAINER_RUNNING, of the
+	// containers whose images cannot be removed. Empty means all states.
+	InUseContainerStates []string `yaml:"in-use-container-states,omitempty"`
+	// ProtectedImages are images that cannot be removed, by reference or ID.
+	ProtectedImages []string `yaml:"protected-images,omitempty"`
+	// StripAuth removes the registry credentials of the callers from pulls.
+	StripAuth bool `yaml:"strip-auth,omitempty"`
+	// CredentialsFile is a docker config file with the registry credentials
+	// to pull images with, instead of the ones of the callers.
+	CredentialsFile string `yaml:"credentials-file,omitempty"`
+}
+
 // imageManagementPolicy is a policy that allows only image management CRI calls.
-type imageManagementPolicy struct{}
+type imageManagementPolicy struct {
+	config        ImageManagement
+	inUseStates   []runtimeapi.ContainerState
+	credentials   *registryCredentials
+	runtimeClient runtimeapi.RuntimeServiceClient
+	imageClient   runtimeapi.ImageServiceClient
+}
 
 // NewImageManagementPolicy creates a new ImageManagement policy.
-func NewImageManagementPolicy() Policy {
-	return &imageManagementPolicy{}
+func NewImageManagementPolicy(
+	config ImageManagement,
+	runtimeClient runtimeapi.RuntimeServiceClient,
+	imageClient runtimeapi.ImageServiceClient,
+) (Policy, error) {
+	for _, pattern := range config.AllowedRepositories {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid repository pattern %q: %w", ErrInvalidImageManagement, pattern, err)
+		}
+	}
+
+	inUseStates := make([]runtimeapi.ContainerState, 0, len(runtimeapi.ContainerState_value))
+
+	for _, name := range config.InUseContainerStates {
+		state, ok := runtimeapi.ContainerState_value[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown container state %q", ErrInvalidImageManagement, name)
+		}
+
+		inUseStates = append(inUseStates, runtimeapi.ContainerState(state))
+	}
+
+	if len(inUseStates) == 0 {
+		for state := range runtimeapi.ContainerState_name {
+			inUseStates = append(inUseStates, runtimeapi.ContainerState(state))
+		}
+	}
+
+	var credentials *registryCredentials
+
+	if config.CredentialsFile != "" {
+		var err error
+
+		credentials, err = newRegistryCredentials(config.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &imageManagementPolicy{
+		config:        config,
+		inUseStates:   inUseStates,
+		credentials:   credentials,
+		runtimeClient: runtimeClient,
+		imageClient:   imageClient,
+	}, nil
 }
 
 // Name implements the Policy interface.
@@ -46,7 +135,22 @@ func (p *imageManagementPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
 			}
 
 			if !strings.HasPrefix(info.FullMethod, "/runtime.v1.ImageService/") {
-				return nil, status.Errorf(codes.PermissionDenied, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
+				return nil, newError(codes.PermissionDenied, ReasonMethodNotAllowed, nil, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
+			}
+
+			switch r := req.(type) {
+			case *runtimeapi.PullImageRequest:
+				err := p.verifyImage(ctx, r.GetImage().GetImage())
+				if err != nil {
+					return nil, err
+				}
+
+				p.replaceAuth(ctx, r)
+			case *runtimeapi.RemoveImageRequest:
+				err := p.verifyRemoval(ctx, r.GetImage().GetImage())
+				if err != nil {
+					return nil, err
+				}
 			}
 
 			return handler(ctx, req)
@@ -66,6 +170,162 @@ func (p *imageManagementPolicy) StreamInterceptor() grpc.StreamServerInterceptor
 		info *grpc.StreamServerInfo,
 		handler grpc.StreamHandler,
 	) error {
-		return status.Errorf(codes.PermissionDenied, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
+		return newError(codes.PermissionDenied, ReasonMethodNotAllowed, nil, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
+	}
+}
+
+// verifyImage checks an image reference, after normalizing short names like
+// nginx to docker.io/library/nginx:latest, against the allowed registries,
+// repositories and reference kinds.
+func (p *imageManagementPolicy) verifyImage(ctx context.Context, image string) error {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return newError(codes.PermissionDenied, ReasonImageNotAllowed, nil, "%s: %q is not a valid image reference: %v", ErrImageNotAllowed, image, err)
+	}
+
+	named = reference.TagNameOnly(named)
+	normalized := named.String()
+
+	var reason string
+
+	switch {
+	case len(p.config.AllowedRegistries) > 0 && !slices.Contains(p.config.AllowedRegistries, reference.Domain(named)):
+		reason = fmt.Sprintf("registry %s is not allowed", reference.Domain(named))
+	case len(p.config.AllowedRepositories) > 0 && !slices.ContainsFunc(p.config.AllowedRepositories, func(pattern string) bool {
+		matched, _ := path.Match(pattern, named.Name())
+
+		return matched
+	}):
+		reason = fmt.Sprintf("repository %s is not allowed", named.Name())
+	case p.config.RequireDigest && !isDigested(named):
+		reason = "only images pinned by digest are allowed"
+	default:
+		return nil
+	}
+
+	klog.FromContext(ctx).V(4).Info("image not allowed", "image", image, "normalized", normalized, "reason", reason)
+
+	return newError(codes.PermissionDenied, ReasonImageNotAllowed, nil, "%s: %s: %s", ErrImageNotAllowed, normalized, reason)
+}
+
+func isDigested(named reference.Named) bool {
+	_, ok := named.(reference.Digested)
+
+	return ok
+}
+
+// replaceAuth strips the credentials of the caller from a pull and, if there
+// is a credentials file, injects the credentials of the registry of the image.
+func (p *imageManagementPolicy) replaceAuth(ctx context.Context, r *runtimeapi.PullImageRequest) {
+	if !p.config.StripAuth && p.credentials == nil {
+		return
+	}
+
+	r.Auth = nil
+
+	if p.credentials == nil {
+		return
 	}
+
+	// The image was validated by verifyImage.
+	named, err := reference.ParseNormalizedNamed(r.GetImage().GetImage())
+	if err != nil {
+		return
+	}
+
+	r.Auth = p.credentials.lookup(ctx, reference.Domain(named))
+}
+
+// verifyRemoval denies removing images that are pinned by the runtime,
+// protected, or used by a container in one of the in-use states.
+func (p *imageManagementPolicy) verifyRemoval(ctx context.Context, image string) error {
+	logger := klog.FromContext(ctx)
+
+	resp, err := p.imageClient.ImageStatus(ctx, &runtimeapi.ImageStatusRequest{
+		Image: &runtimeapi.ImageSpec{Image: image},
+	})
+	if err != nil {
+		return newError(codes.Internal, ReasonRuntimeLookupFailed, nil, "failed to get image status: %v", err)
+	}
+
+	// The image does not exist, so the runtime has nothing to remove.
+	if resp.GetImage() == nil {
+		return nil
+	}
+
+	if resp.GetImage().GetPinned() {
+		return newError(codes.PermissionDenied, ReasonImageProtected, nil, "%s: %s is pinned by the runtime", ErrImageProtected, image)
+	}
+
+	names := imageNames(image, resp.GetImage())
+
+	for _, protected := range p.config.ProtectedImages {
+		if names[normalizeImageName(protected)] {
+			return newError(codes.PermissionDenied, ReasonImageProtected, nil, "%s: %s matches %s", ErrImageProtected, image, protected)
+		}
+	}
+
+	containers, err := p.runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+	if err != nil {
+		return newError(codes.Internal, ReasonRuntimeLookupFailed, nil, "failed to list containers: %v", err)
+	}
+
+	var inUseBy []string
+
+	for _, c := range containers.GetContainers() {
+		if !slices.Contains(p.inUseStates, c.GetState()) {
+			continue
+		}
+
+		for _, name := range []string{c.GetImageRef(), c.GetImage().GetImage(), c.GetImage().GetUserSpecifiedImage()} {
+			if name != "" && names[normalizeImageName(name)] {
+				inUseBy = append(inUseBy, c.GetId())
+
+				break
+			}
+		}
+	}
+
+	if len(inUseBy) > 0 {
+		logger.V(4).Info("image is in use", "image", image, "containers", inUseBy)
+
+		return newError(codes.PermissionDenied, ReasonImageInUse, nil, "%s: %s is used by containers %s", ErrImageInUse, image, strings.Join(inUseBy, ", "))
+	}
+
+	return nil
+}
+
+// imageNames returns the normalized ID, tags and digests an image can be
+// referenced by.
+func imageNames(image string, img *runtimeapi.Image) map[string]bool {
+	names := map[string]bool{
+		normalizeImageName(image):       true,
+		normalizeImageName(img.GetId()): true,
+	}
+
+	for _, name := range slices.Concat(img.GetRepoTags(), img.GetRepoDigests()) {
+		names[normalizeImageName(name)] = true
+	}
+
+	return names
+}
+
+// normalizeImageName normalizes image references, e.g. nginx to
+// docker.io/library/nginx:latest, and prefixes bare image IDs with their
+// algorithm. Anything else is returned as is.
+func normalizeImageName(name string) string {
+	if len(name) == 64 && strings.Trim(name, "0123456789abcdef") == "" {
+		return "sha256:" + name
+	}
+
+	if strings.HasPrefix(name, "sha256:") {
+		return name
+	}
+
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return name
+	}
+
+	return reference.TagNameOnly(named).String()
 }
@@ -2,15 +2,22 @@ package policy_test
 
 import (
 	"context"
+	"net"
+	"os"
+	"path/filepath"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 
+	"cri-lite/pkg/fake"
 	"cri-lite/pkg/policy"
+	"cri-lite/pkg/proxy"
 )
 
 var _ = Describe("Image Management Policy", func() {
@@ -18,11 +25,17 @@ var _ = Describe("Image Management Policy", func() {
 		client      runtimeapi.RuntimeServiceClient
 		imageClient runtimeapi.ImageServiceClient
 		cleanup     func()
+		config      policy.ImageManagement
 	)
 
 	BeforeEach(func() {
-		p := policy.NewImageManagementPolicy()
-		client, imageClient, cleanup = setupTestEnvironment(p)
+		config = policy.ImageManagement{}
+	})
+
+	JustBeforeEach(func() {
+		p, err := policy.NewImageManagementPolicy(config, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		client, imageClient, _, cleanup = setupTestEnvironment(withPolicies(p))
 	})
 
 	AfterEach(func() {
@@ -60,4 +73,308 @@ var _ = Describe("Image Management Policy", func() {
 			Expect(st.Code()).To(Equal(codes.PermissionDenied))
 		})
 	})
+
+	Context("with an image allowlist", func() {
+		BeforeEach(func() {
+			config = policy.ImageManagement{
+				AllowedRegistries:   []string{"docker.io", "registry.k8s.io"},
+				AllowedRepositories: []string{"docker.io/library/*", "registry.k8s.io/*"},
+			}
+		})
+
+		DescribeTable("PullImage",
+			func(image string, denial string) {
+				ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+				defer cancel()
+
+				_, err := imageClient.PullImage(ctx, &runtimeapi.PullImageRequest{Image: &runtimeapi.ImageSpec{Image: image}})
+				if denial == "" {
+					Expect(err).NotTo(HaveOccurred())
+
+					return
+				}
+
+				Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+				Expect(err.Error()).To(ContainSubstring(policy.ErrImageNotAllowed.Error()))
+				Expect(err.Error()).To(ContainSubstring(denial))
+			},
+			Entry("short name", "nginx", ""),
+			Entry("fully qualified name", "docker.io/library/nginx:1.27", ""),
+			Entry("other registry", "registry.k8s.io/pause:3.10", ""),
+			Entry("registry not allowed", "quay.io/prometheus/prometheus",
+				"quay.io/prometheus/prometheus:latest: registry quay.io is not allowed"),
+			Entry("repository not allowed", "someuser/nginx",
+				"docker.io/someuser/nginx:latest: repository docker.io/someuser/nginx is not allowed"),
+			Entry("repository glob does not cross path segments", "registry.k8s.io/sig-storage/csi-provisioner:v5.0.0",
+				"repository registry.k8s.io/sig-storage/csi-provisioner is not allowed"),
+			Entry("invalid reference", "NGINX", "is not a valid image reference"),
+		)
+	})
+
+	Context("with digests required", func() {
+		BeforeEach(func() {
+			config = policy.ImageManagement{RequireDigest: true}
+		})
+
+		It("should only allow pulling images by digest", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := imageClient.PullImage(ctx, &runtimeapi.PullImageRequest{Image: &runtimeapi.ImageSpec{
+				Image: "nginx@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			}})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = imageClient.PullImage(ctx, &runtimeapi.PullImageRequest{Image: &runtimeapi.ImageSpec{Image: "nginx:1.27"}})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(err.Error()).To(ContainSubstring("docker.io/library/nginx:1.27: only images pinned by digest are allowed"))
+		})
+	})
+})
+
+var _ = Describe("Image Management Policy with runtime clients", func() {
+	const (
+		nginxID     = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		nginxDigest = "docker.io/library/nginx@sha256:2222222222222222222222222222222222222222222222222222222222222222"
+		pauseID     = "sha256:3333333333333333333333333333333333333333333333333333333333333333"
+		busyboxID   = "sha256:4444444444444444444444444444444444444444444444444444444444444444"
+		unusedID    = "sha256:5555555555555555555555555555555555555555555555555555555555555555"
+		exitedID    = "sha256:6666666666666666666666666666666666666666666666666666666666666666"
+	)
+
+	var (
+		server      *grpc.Server
+		mock        *fake.Server
+		imageClient runtimeapi.ImageServiceClient
+		proxySocket string
+		sockDir     string
+		proxyServer *proxy.Server
+	)
+
+	BeforeEach(func() {
+		var (
+			err error
+			lis net.Listener
+		)
+
+		sockDir, err = os.MkdirTemp("", "cri-lite-test")
+		Expect(err).NotTo(HaveOccurred())
+		serverSocket := createSocket(sockDir)
+		proxySocket = createSocket(sockDir)
+
+		server, lis, mock, err = fake.NewServer(serverSocket)
+		Expect(err).NotTo(HaveOccurred())
+		go func() {
+			defer GinkgoRecover()
+			Expect(server.Serve(lis)).To(Succeed())
+		}()
+
+		mock.SetImages([]*runtimeapi.Image{
+			{Id: nginxID, RepoTags: []string{"docker.io/library/nginx:1.27"}, RepoDigests: []string{nginxDigest}},
+			{Id: pauseID, RepoTags: []string{"registry.k8s.io/pause:3.10"}, Pinned: true},
+			{Id: busyboxID, RepoTags: []string{"docker.io/library/busybox:latest"}},
+			{Id: unusedID, RepoTags: []string{"docker.io/library/alpine:latest"}},
+			{Id: exitedID, RepoTags: []string{"docker.io/library/debian:latest"}},
+		})
+		mock.SetContainers([]*runtimeapi.Container{
+			{
+				Id:       "nginx-container",
+				Image:    &runtimeapi.ImageSpec{Image: "nginx:1.27"},
+				ImageRef: nginxID,
+				State:    runtimeapi.ContainerState_CONTAINER_RUNNING,
+			},
+			{
+				Id:    "busybox-container",
+				Image: &runtimeapi.ImageSpec{Image: "busybox"},
+				State: runtimeapi.ContainerState_CONTAINER_CREATED,
+			},
+			{
+				Id:       "debian-container",
+				ImageRef: exitedID,
+				State:    runtimeapi.ContainerState_CONTAINER_EXITED,
+			},
+		})
+
+		proxyServer, err = proxy.NewServer("unix://"+serverSocket, "unix://"+serverSocket)
+		Expect(err).NotTo(HaveOccurred())
+
+		conn, err := grpc.NewClient("unix://"+proxySocket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).NotTo(HaveOccurred())
+		imageClient = runtimeapi.NewImageServiceClient(conn)
+	})
+
+	AfterEach(func() {
+		server.Stop()
+		Expect(os.RemoveAll(sockDir)).To(Succeed())
+	})
+
+	startProxy := func(config policy.ImageManagement) {
+		p, err := policy.NewImageManagementPolicy(config, proxyServer.GetRuntimeClient(), proxyServer.GetImageClient())
+		Expect(err).NotTo(HaveOccurred())
+		proxyServer.SetPolicy(p)
+
+		go func() {
+			defer GinkgoRecover()
+			Expect(proxyServer.Start(proxySocket)).To(Succeed())
+		}()
+
+		Eventually(func() error {
+			conn, err := net.Dial("unix", proxySocket)
+			if err != nil {
+				return err
+			}
+
+			return conn.Close()
+		}, "5s", "100ms").Should(Succeed())
+	}
+
+	removeImage := func(image string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := imageClient.RemoveImage(ctx, &runtimeapi.RemoveImageRequest{Image: &runtimeapi.ImageSpec{Image: image}})
+
+		return err
+	}
+
+	Context("with the default config", func() {
+		BeforeEach(func() {
+			startProxy(policy.ImageManagement{ProtectedImages: []string{"alpine"}})
+		})
+
+		DescribeTable("should deny removing images in use",
+			func(image string, container string) {
+				err := removeImage(image)
+				Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+				Expect(err.Error()).To(ContainSubstring(policy.ErrImageInUse.Error()))
+				Expect(err.Error()).To(ContainSubstring(container))
+			},
+			Entry("by ID", nginxID, "nginx-container"),
+			Entry("by tag", "docker.io/library/nginx:1.27", "nginx-container"),
+			Entry("by digest", nginxDigest, "nginx-container"),
+			Entry("used by short name", "docker.io/library/busybox:latest", "busybox-container"),
+			Entry("used by an exited container", exitedID, "debian-container"),
+		)
+
+		It("should deny removing protected and pinned images", func() {
+			err := removeImage(unusedID)
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(err.Error()).To(ContainSubstring(policy.ErrImageProtected.Error()))
+
+			err = removeImage("registry.k8s.io/pause:3.10")
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(err.Error()).To(ContainSubstring("is pinned by the runtime"))
+		})
+
+		It("should allow removing unknown images", func() {
+			Expect(removeImage("docker.io/library/unknown:latest")).To(Succeed())
+		})
+	})
+
+	Context("with a credentials file", func() {
+		var credentialsFile string
+
+		writeCredentials := func(data string) {
+			Expect(os.WriteFile(credentialsFile, []byte(data), 0o600)).To(Succeed())
+		}
+
+		pullImage := func(image string) *runtimeapi.AuthConfig {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := imageClient.PullImage(ctx, &runtimeapi.PullImageRequest{
+				Image: &runtimeapi.ImageSpec{Image: image},
+				Auth:  &runtimeapi.AuthConfig{Username: "tenant", Password: "tenant-secret"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			return mock.LastPullImageRequest().GetAuth()
+		}
+
+		BeforeEach(func() {
+			credentialsFile = filepath.Join(sockDir, "config.json")
+			// The auth of mirror.example.com is base64 of "mirror-user:mirror-secret".
+			writeCredentials(`{"auths": {
+				"https://index.docker.io/v1/": {"username": "hub-user", "password": "hub-secret"},
+				"mirror.example.com": {"auth": "bWlycm9yLXVzZXI6bWlycm9yLXNlY3JldA=="}
+			}}`)
+
+			startProxy(policy.ImageManagement{CredentialsFile: credentialsFile})
+		})
+
+		It("should inject the credentials of the registry", func() {
+			auth := pullImage("nginx")
+			Expect(auth.GetUsername()).To(Equal("hub-user"))
+			Expect(auth.GetPassword()).To(Equal("hub-secret"))
+
+			auth = pullImage("mirror.example.com/team/app:v1")
+			Expect(auth.GetUsername()).To(Equal("mirror-user"))
+			Expect(auth.GetPassword()).To(Equal("mirror-secret"))
+			Expect(auth.GetServerAddress()).To(Equal("mirror.example.com"))
+		})
+
+		It("should strip the credentials of the caller for other registries", func() {
+			Expect(pullImage("quay.io/prometheus/prometheus")).To(BeNil())
+		})
+
+		It("should reload the credentials file when it changes", func() {
+			Expect(pullImage("nginx").GetUsername()).To(Equal("hub-user"))
+
+			writeCredentials(`{"auths": {"docker.io": {"username": "rotated-user", "password": "rotated-secret"}}}`)
+			Expect(os.Chtimes(credentialsFile, time.Time{}, time.Now().Add(time.Minute))).To(Succeed())
+
+			Expect(pullImage("nginx").GetUsername()).To(Equal("rotated-user"))
+
+			By("keeping the last credentials if the file becomes invalid")
+			writeCredentials(`{`)
+			Expect(os.Chtimes(credentialsFile, time.Time{}, time.Now().Add(2*time.Minute))).To(Succeed())
+
+			Expect(pullImage("nginx").GetUsername()).To(Equal("rotated-user"))
+		})
+	})
+
+	Context("with caller credentials stripped", func() {
+		BeforeEach(func() {
+			startProxy(policy.ImageManagement{StripAuth: true})
+		})
+
+		It("should pull without credentials", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := imageClient.PullImage(ctx, &runtimeapi.PullImageRequest{
+				Image: &runtimeapi.ImageSpec{Image: "nginx"},
+				Auth:  &runtimeapi.AuthConfig{Username: "tenant", Password: "tenant-secret"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mock.LastPullImageRequest().GetAuth()).To(BeNil())
+		})
+	})
+
+	Context("with running containers only", func() {
+		BeforeEach(func() {
+			startProxy(policy.ImageManagement{InUseContainerStates: []string{"CONTAINER_RUNNING"}})
+		})
+
+		It("should allow removing images of containers in other states", func() {
+			Expect(removeImage(exitedID)).To(Succeed())
+			Expect(removeImage(unusedID)).To(Succeed())
+
+			err := removeImage(nginxID)
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+	})
+})
+
+var _ = Describe("NewImageManagementPolicy", func() {
+	It("should reject invalid repository patterns", func() {
+		_, err := policy.NewImageManagementPolicy(policy.ImageManagement{AllowedRepositories: []string{"docker.io/["}}, nil, nil)
+		Expect(err).To(MatchError(policy.ErrInvalidImageManagement))
+
+		_, err = policy.NewImageManagementPolicy(policy.ImageManagement{InUseContainerStates: []string{"RUNNING"}}, nil, nil)
+		Expect(err).To(MatchError(policy.ErrInvalidImageManagement))
+
+		_, err = policy.NewImageManagementPolicy(policy.ImageManagement{CredentialsFile: "/nonexistent/config.json"}, nil, nil)
+		Expect(err).To(HaveOccurred())
+	})
 })
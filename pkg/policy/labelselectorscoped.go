@@ -0,0 +1,39 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+var ErrInvalidLabelSelector = errors.New("invalid label selector")
+
+// NewLabelSelectorScopedPolicy creates a new LabelSelectorScoped policy, which
+// restricts RuntimeService calls to the pod sandboxes and containers matching a
+// Kubernetes label selector, e.g. "app=ci-runner,team in (build,release)".
+func NewLabelSelectorScopedPolicy(selector string, runtimeClient runtimeapi.RuntimeServiceClient) (Policy, error) {
+	if selector == "" {
+		return nil, fmt.Errorf("%w: selector is required", ErrInvalidLabelSelector)
+	}
+
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidLabelSelector, err)
+	}
+
+	matches := func(l map[string]string) bool {
+		return parsed.Matches(labels.Set(l))
+	}
+
+	return &sandboxScopedPolicy{
+		name:          "labelSelectorScoped",
+		runtimeClient: runtimeClient,
+		matcher: func(context.Context) (labelMatcher, error) {
+			return matches, nil
+		},
+	}, nil
+}
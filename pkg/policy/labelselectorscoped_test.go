@@ -0,0 +1,143 @@
+package policy_test
+
+import (
+	"context"
+	"io"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/fake"
+	"cri-lite/pkg/policy"
+	"cri-lite/pkg/proxy"
+)
+
+var _ = Describe("LabelSelectorScoped Policy", func() {
+	var (
+		mock          *fake.Server
+		runtimeClient runtimeapi.RuntimeServiceClient
+		cleanup       func()
+	)
+
+	BeforeEach(func() {
+		runtimeClient, _, mock, cleanup = setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+			p, err := policy.NewLabelSelectorScopedPolicy("app=ci-runner,team in (build,release)", proxyServer.GetRuntimeClient())
+			Expect(err).NotTo(HaveOccurred())
+
+			return []policy.Policy{p}
+		})
+
+		mock.SetPodSandboxes([]*runtimeapi.PodSandbox{
+			{Id: "build-pod", Labels: map[string]string{"app": "ci-runner", "team": "build"}},
+			{Id: "release-pod", Labels: map[string]string{"app": "ci-runner", "team": "release"}},
+			{Id: "test-pod", Labels: map[string]string{"app": "ci-runner", "team": "test"}},
+			{Id: "web-pod", Labels: map[string]string{"app": "web", "team": "build"}},
+		})
+		mock.SetContainers([]*runtimeapi.Container{
+			{Id: "build-container", PodSandboxId: "build-pod"},
+			{Id: "release-container", PodSandboxId: "release-pod"},
+			{Id: "test-container", PodSandboxId: "test-pod"},
+			{Id: "web-container", PodSandboxId: "web-pod"},
+			// Container labels narrow the scope of their pod sandbox, but do not widen it.
+			{Id: "sidecar-container", PodSandboxId: "build-pod", Labels: map[string]string{"team": "test"}},
+			{Id: "escaping-container", PodSandboxId: "test-pod", Labels: map[string]string{"team": "build"}},
+		})
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("should only allow calls on pod sandboxes matching the selector", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		for _, id := range []string{"build-pod", "release-pod"} {
+			_, err := runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{PodSandboxId: id})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		for _, id := range []string{"test-pod", "web-pod"} {
+			_, err := runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{PodSandboxId: id})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		}
+	})
+
+	It("should only allow calls on containers matching the selector", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: "release-container"})
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, id := range []string{"sidecar-container", "escaping-container", "web-container", "unknown-container"} {
+			_, err = runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: id})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		}
+	})
+
+	It("should filter list responses", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		containers, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+		Expect(err).NotTo(HaveOccurred())
+
+		var ids []string
+		for _, c := range containers.GetContainers() {
+			ids = append(ids, c.GetId())
+		}
+
+		Expect(ids).To(ConsistOf("build-container", "release-container"))
+
+		podSandboxes, err := runtimeClient.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(podSandboxes.GetItems()).To(HaveLen(2))
+
+		mock.SetPodSandboxMetrics([]*runtimeapi.PodSandboxMetrics{
+			{PodSandboxId: "web-pod"},
+			{PodSandboxId: "release-pod"},
+		})
+
+		metrics, err := runtimeClient.ListPodSandboxMetrics(ctx, &runtimeapi.ListPodSandboxMetricsRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(metrics.GetPodMetrics()).To(HaveLen(1))
+		Expect(metrics.GetPodMetrics()[0].GetPodSandboxId()).To(Equal("release-pod"))
+	})
+
+	It("should filter GetContainerEvents", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		mock.SetEmittedEvents([]*runtimeapi.ContainerEventResponse{
+			{ContainerId: "web-container"},
+			{ContainerId: "build-container"},
+			{ContainerId: "sidecar-container"},
+			{ContainerId: "escaping-container"},
+		})
+
+		stream, err := runtimeClient.GetContainerEvents(ctx, &runtimeapi.GetEventsRequest{})
+		Expect(err).NotTo(HaveOccurred())
+
+		event, err := stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(event.GetContainerId()).To(Equal("build-container"))
+
+		_, err = stream.Recv()
+		Expect(err).To(MatchError(io.EOF))
+	})
+})
+
+var _ = Describe("NewLabelSelectorScopedPolicy", func() {
+	It("should reject invalid selectors", func() {
+		_, err := policy.NewLabelSelectorScopedPolicy("app in (", nil)
+		Expect(err).To(MatchError(policy.ErrInvalidLabelSelector))
+
+		_, err = policy.NewLabelSelectorScopedPolicy("", nil)
+		Expect(err).To(MatchError(policy.ErrInvalidLabelSelector))
+	})
+})
@@ -0,0 +1,41 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// NamespaceLabel is the label set by the kubelet on pod sandboxes to the
+// Kubernetes namespace of the pod.
+const NamespaceLabel = "io.kubernetes.pod.namespace"
+
+// NewNamespaceScopedPolicy creates a new NamespaceScoped policy, which
+// restricts RuntimeService calls to the pod sandboxes of a Kubernetes
+// namespace. If namespace is empty, the namespace of the caller's pod is used.
+func NewNamespaceScopedPolicy(namespace string, runtimeClient runtimeapi.RuntimeServiceClient) Policy {
+	return &sandboxScopedPolicy{
+		name:          "namespaceScoped",
+		runtimeClient: runtimeClient,
+		matcher: func(ctx context.Context) (labelMatcher, error) {
+			ns := namespace
+			if ns == "" {
+				labels, err := podSandboxLabelsFromPID(ctx, runtimeClient)
+				if err != nil {
+					return nil, err
+				}
+
+				ns = labels[NamespaceLabel]
+				if ns == "" {
+					return nil, newError(codes.PermissionDenied, ReasonMethodNotAllowed, nil, "%s: caller pod has no %s label", ErrMethodNotAllowed, NamespaceLabel)
+				}
+			}
+
+			return func(labels map[string]string) bool {
+				return labels[NamespaceLabel] == ns
+			}, nil
+		},
+	}
+}
@@ -0,0 +1,241 @@
+package policy_test
+
+import (
+	"context"
+	"io"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/fake"
+	"cri-lite/pkg/policy"
+	"cri-lite/pkg/proxy"
+)
+
+var _ = Describe("NamespaceScoped Policy", func() {
+	var (
+		runtimeClient runtimeapi.RuntimeServiceClient
+		imageClient   runtimeapi.ImageServiceClient
+		mock          *fake.Server
+	)
+
+	startProxy := func(namespace string) {
+		var cleanup func()
+
+		runtimeClient, imageClient, mock, cleanup = setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+			return []policy.Policy{policy.NewNamespaceScopedPolicy(namespace, proxyServer.GetRuntimeClient())}
+		})
+		DeferCleanup(cleanup)
+
+		mock.SetPodSandboxes([]*runtimeapi.PodSandbox{
+			{Id: "pod-a1", Labels: map[string]string{policy.NamespaceLabel: "ns-a"}},
+			{Id: "pod-a2", Labels: map[string]string{policy.NamespaceLabel: "ns-a"}},
+			{Id: "pod-b1", Labels: map[string]string{policy.NamespaceLabel: "ns-b"}},
+			{Id: "pod-no-namespace"},
+		})
+		mock.SetContainers([]*runtimeapi.Container{
+			{Id: "container-a1", PodSandboxId: "pod-a1"},
+			{Id: "container-a2", PodSandboxId: "pod-a2"},
+			{Id: "container-b1", PodSandboxId: "pod-b1"},
+			{Id: "container-b2", PodSandboxId: "pod-b1", Labels: map[string]string{policy.NamespaceLabel: "ns-a"}},
+		})
+	}
+
+	Context("with a static namespace", func() {
+		BeforeEach(func() {
+			startProxy("ns-a")
+		})
+
+		It("should allow calls on pod sandboxes of the namespace only", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			for _, id := range []string{"pod-a1", "pod-a2"} {
+				_, err := runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{PodSandboxId: id})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			for _, id := range []string{"pod-b1", "pod-no-namespace", "unknown-pod"} {
+				_, err := runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{PodSandboxId: id})
+				Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			}
+		})
+
+		It("should allow calls on containers of the namespace only", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: "container-a2"})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: "container-b1"})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			// The labels of a container cannot move it to another namespace.
+			_, err = runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: "container-b2"})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			_, err = runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: "unknown-container"})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+
+		It("should filter list responses", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			containers, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(containers.GetContainers()).To(HaveLen(2))
+			Expect(containers.GetContainers()[0].GetId()).To(Equal("container-a1"))
+			Expect(containers.GetContainers()[1].GetId()).To(Equal("container-a2"))
+
+			podSandboxes, err := runtimeClient.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(podSandboxes.GetItems()).To(HaveLen(2))
+
+			mock.SetContainerStats([]*runtimeapi.ContainerStats{
+				{Attributes: &runtimeapi.ContainerAttributes{Id: "container-a1"}},
+				{Attributes: &runtimeapi.ContainerAttributes{Id: "container-b1"}},
+			})
+
+			stats, err := runtimeClient.ListContainerStats(ctx, &runtimeapi.ListContainerStatsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.GetStats()).To(HaveLen(1))
+			Expect(stats.GetStats()[0].GetAttributes().GetId()).To(Equal("container-a1"))
+
+			mock.SetPodSandboxStats([]*runtimeapi.PodSandboxStats{
+				{Attributes: &runtimeapi.PodSandboxAttributes{Id: "pod-a1", Labels: map[string]string{policy.NamespaceLabel: "ns-a"}}},
+				{Attributes: &runtimeapi.PodSandboxAttributes{Id: "pod-b1", Labels: map[string]string{policy.NamespaceLabel: "ns-b"}}},
+			})
+
+			podStats, err := runtimeClient.ListPodSandboxStats(ctx, &runtimeapi.ListPodSandboxStatsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(podStats.GetStats()).To(HaveLen(1))
+			Expect(podStats.GetStats()[0].GetAttributes().GetId()).To(Equal("pod-a1"))
+		})
+
+		It("should filter GetContainerEvents", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			mock.SetEmittedEvents([]*runtimeapi.ContainerEventResponse{
+				{ContainerId: "container-b1"},
+				{ContainerId: "container-a1"},
+				{
+					ContainerId: "container-new",
+					PodSandboxStatus: &runtimeapi.PodSandboxStatus{
+						Id:     "pod-new",
+						Labels: map[string]string{policy.NamespaceLabel: "ns-a"},
+					},
+				},
+			})
+
+			stream, err := runtimeClient.GetContainerEvents(ctx, &runtimeapi.GetEventsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			event, err := stream.Recv()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(event.GetContainerId()).To(Equal("container-a1"))
+
+			event, err = stream.Recv()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(event.GetContainerId()).To(Equal("container-new"))
+
+			_, err = stream.Recv()
+			Expect(err).To(MatchError(io.EOF))
+		})
+
+		It("should look up the containers of events once per stream", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			mock.SetEmittedEvents([]*runtimeapi.ContainerEventResponse{
+				{ContainerId: "container-a1"},
+				{ContainerId: "container-b1"},
+				{ContainerId: "container-a1"},
+				{ContainerId: "container-b1"},
+			})
+
+			stream, err := runtimeClient.GetContainerEvents(ctx, &runtimeapi.GetEventsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			for range 2 {
+				event, err := stream.Recv()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(event.GetContainerId()).To(Equal("container-a1"))
+			}
+
+			_, err = stream.Recv()
+			Expect(err).To(MatchError(io.EOF))
+			Expect(mock.ListContainersCalls()).To(BeEquivalentTo(2))
+		})
+
+		It("should allow node level calls and deny privileged ones", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = imageClient.ImageFsInfo(ctx, &runtimeapi.ImageFsInfoRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = runtimeClient.RunPodSandbox(ctx, &runtimeapi.RunPodSandboxRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			_, err = runtimeClient.UpdateRuntimeConfig(ctx, &runtimeapi.UpdateRuntimeConfigRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			_, err = imageClient.ListImages(ctx, &runtimeapi.ListImagesRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+	})
+
+	Context("with the namespace of the caller", func() {
+		var (
+			callerPod string
+			restore   func()
+		)
+
+		BeforeEach(func() {
+			restore = policy.SetPodSandboxIDFromPID(func(context.Context, runtimeapi.RuntimeServiceClient, int32) (string, error) {
+				return callerPod, nil
+			})
+
+			startProxy("")
+		})
+
+		AfterEach(func() {
+			restore()
+		})
+
+		It("should scope the caller to its own namespace", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			callerPod = "pod-b1"
+
+			resp, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetContainers()).To(HaveLen(1))
+			Expect(resp.GetContainers()[0].GetId()).To(Equal("container-b1"))
+
+			_, err = runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{PodSandboxId: "pod-a1"})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+
+		It("should deny callers whose pod has no namespace", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			callerPod = "pod-no-namespace"
+
+			_, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+	})
+})
@@ -2,18 +2,13 @@
 package policy
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
-	"os"
-	"regexp"
-	"strings"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/peer"
-	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 	"k8s.io/klog/v2"
 )
@@ -61,42 +56,31 @@ func (p *podScopedPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
 		) (interface{}, error) {
 			logger := klog.FromContext(ctx)
 
-			// ImageFsInfo is used by crictl for CRI connectivity checks.
-			if info.FullMethod == "/runtime.v1.ImageService/ImageFsInfo" {
-				return handler(ctx, req)
-			}
-
-			if strings.HasPrefix(info.FullMethod, "/runtime.v1.ImageService/") {
-				return nil, status.Errorf(codes.PermissionDenied, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
+			scope, ok := methodScopes[info.FullMethod]
+			if !ok || scope == scopeDenied {
+				return nil, newError(codes.PermissionDenied, ReasonMethodNotAllowed, nil, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
 			}
 
-			if !strings.HasPrefix(info.FullMethod, "/runtime.v1.RuntimeService/") {
-				return nil, status.Errorf(codes.PermissionDenied, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
+			if scope == scopeNode {
+				return handler(ctx, req)
 			}
 
 			podSandboxID := p.podSandboxID
 			if p.podSandboxFromCallerPID {
-				peerInfo, isPeer := peer.FromContext(ctx)
-				if !isPeer {
-					return nil, status.Errorf(codes.InvalidArgument, "failed to get peer from context")
-				}
-
-				authInfo, ok := peerInfo.AuthInfo.(interface{ GetPID() int32 })
-				if !ok {
-					return nil, status.Errorf(codes.InvalidArgument, "failed to get auth info from context")
+				pid, err := callerPID(ctx)
+				if err != nil {
+					return nil, err
 				}
 
-				logger.V(4).Info("peer PID", "pid", authInfo.GetPID())
+				logger.V(4).Info("peer PID", "pid", pid)
 
-				var err error
-
-				podSandboxID, err = p.getPodSandboxIDFromPID(ctx, authInfo.GetPID())
+				podSandboxID, err = getPodSandboxIDFromPID(ctx, p.runtimeClient, pid)
 				if err != nil {
-					return nil, status.Errorf(codes.Internal, "failed to get pod sandbox ID from PID: %v", err)
+					return nil, pidResolutionError(err)
 				}
 			}
 
-			err := p.verifyRequest(ctx, req, podSandboxID)
+			err := p.verifyRequest(ctx, scope, req, podSandboxID)
 			if err != nil {
 				return nil, err
 			}
@@ -106,16 +90,11 @@ func (p *podScopedPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
 				return nil, err
 			}
 
-			if r, ok := resp.(*runtimeapi.ListContainersResponse); ok {
-				var containers []*runtimeapi.Container
-
-				for _, c := range r.GetContainers() {
-					if c.GetPodSandboxId() == podSandboxID {
-						containers = append(containers, c)
-					}
+			if scope == scopeList {
+				err = p.filterResponse(ctx, resp, podSandboxID)
+				if err != nil {
+					return nil, err
 				}
-
-				r.Containers = containers
 			}
 
 			return resp, nil
@@ -136,26 +115,19 @@ func (p *podScopedPolicy) StreamInterceptor() grpc.StreamServerInterceptor {
 		handler grpc.StreamHandler,
 	) error {
 		if info.FullMethod != "/runtime.v1.RuntimeService/GetContainerEvents" {
-			return handler(srv, ss)
+			return newError(codes.PermissionDenied, ReasonMethodNotAllowed, nil, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
 		}
 
 		podSandboxID := p.podSandboxID
 		if p.podSandboxFromCallerPID {
-			peerInfo, isPeer := peer.FromContext(ss.Context())
-			if !isPeer {
-				return status.Errorf(codes.InvalidArgument, "failed to get peer from context")
-			}
-
-			authInfo, ok := peerInfo.AuthInfo.(interface{ GetPID() int32 })
-			if !ok {
-				return status.Errorf(codes.InvalidArgument, "failed to get auth info from context")
+			pid, err := callerPID(ss.Context())
+			if err != nil {
+				return err
 			}
 
-			var err error
-
-			podSandboxID, err = p.getPodSandboxIDFromPID(ss.Context(), authInfo.GetPID())
+			podSandboxID, err = getPodSandboxIDFromPID(ss.Context(), p.runtimeClient, pid)
 			if err != nil {
-				return status.Errorf(codes.Internal, "failed to get pod sandbox ID from PID: %v", err)
+				return pidResolutionError(err)
 			}
 		}
 
@@ -167,131 +139,76 @@ func (p *podScopedPolicy) StreamInterceptor() grpc.StreamServerInterceptor {
 	}
 }
 
-// TODO: when it will become a problem we should add caching here.
-func (p *podScopedPolicy) getPodSandboxIDFromPID(ctx context.Context, pid int32) (string, error) {
-	logger := klog.FromContext(ctx)
-	logger.V(4).Info("mapping pid to sandbox id", "pid", pid)
-
-	cgroupFile, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
-	if err != nil {
-		return "", fmt.Errorf("failed to open cgroup file: %w", err)
-	}
-
-	defer func() {
-		err := cgroupFile.Close()
-		if err != nil {
-			logger.Error(err, "failed to close cgroup file")
-		}
-	}()
-
-	scanner := bufio.NewScanner(cgroupFile)
-	for scanner.Scan() {
-		line := scanner.Text()
-		// This regex is designed to extract a container ID from a cgroup line.
-		r := regexp.MustCompile(`([0-9a-f]{64})`)
-
-		matches := r.FindStringSubmatch(line)
-		if len(matches) == 2 {
-			containerID := matches[1]
-			logger.V(4).Info("found container id for pid", "containerID", containerID, "pid", pid)
-
-			return p.getPodSandboxIDFromContainerID(ctx, containerID)
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return "", fmt.Errorf("failed to read cgroup file: %w", err)
+func (p *podScopedPolicy) verifyPodSandboxIDMatch(requestedPodSandboxID, expectedPodSandboxID, methodName string) error {
+	if requestedPodSandboxID != expectedPodSandboxID {
+		return newError(codes.PermissionDenied, ReasonMethodNotAllowed, map[string]string{MetadataPodSandboxID: expectedPodSandboxID}, "%s: %s does not match", ErrMethodNotAllowed, methodName)
 	}
 
-	return "", fmt.Errorf("failed to find container ID for pid %d", pid)
+	return nil
 }
 
-// TODO: when it will become a problem we should add caching here.
-func (p *podScopedPolicy) getPodSandboxIDFromContainerID(ctx context.Context, containerID string) (string, error) {
-	resp, err := p.runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{
-		Filter: &runtimeapi.ContainerFilter{
-			Id: containerID,
-		},
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to list containers: %w", err)
-	}
-
-	if len(resp.GetContainers()) != 1 {
-		return "", fmt.Errorf("%w: expected 1, got %d", ErrUnexpectedNumberOfContainers, len(resp.GetContainers()))
+func (p *podScopedPolicy) verifyContainerIDBelongsToPod(ctx context.Context, containerID, expectedPodSandboxID string) error {
+	metadata := map[string]string{
+		MetadataContainerID:  containerID,
+		MetadataPodSandboxID: expectedPodSandboxID,
 	}
 
-	return resp.GetContainers()[0].GetPodSandboxId(), nil
-}
-
-func (p *podScopedPolicy) verifyContainerPodSandboxID(ctx context.Context, containerID, expectedPodSandboxID string) error {
-	podSandboxID, err := p.getPodSandboxIDFromContainerID(ctx, containerID)
+	podSandboxID, err := getPodSandboxIDFromContainerID(ctx, p.runtimeClient, containerID)
 	if err != nil {
-		return fmt.Errorf("failed to get pod sandbox ID from container ID: %w", err)
+		return newError(codes.PermissionDenied, ReasonMethodNotAllowed, metadata, "%s: failed to get pod sandbox ID from container ID: %v", ErrMethodNotAllowed, err)
 	}
 
 	if podSandboxID != expectedPodSandboxID {
-		return status.Errorf(codes.PermissionDenied, "%s: container %s does not belong to pod sandbox %s", ErrMethodNotAllowed, containerID, expectedPodSandboxID)
+		return newError(codes.PermissionDenied, ReasonContainerNotInPod, metadata, "%s: container %s does not belong to pod sandbox %s", ErrMethodNotAllowed, containerID, expectedPodSandboxID)
 	}
 
 	return nil
 }
 
-func (p *podScopedPolicy) verifyPodSandboxIDMatch(requestedPodSandboxID, expectedPodSandboxID, methodName string) error {
-	if requestedPodSandboxID != expectedPodSandboxID {
-		return status.Errorf(codes.PermissionDenied, "%s: %s does not match", ErrMethodNotAllowed, methodName)
-	}
+// verifyRequest checks the pod sandbox or the container of a request
+// according to the scope of its method, and narrows the filters of list
+// requests to the pod sandbox.
+func (p *podScopedPolicy) verifyRequest(ctx context.Context, scope methodScope, req interface{}, podSandboxID string) error {
+	switch scope {
+	case scopePodSandbox:
+		r, ok := req.(interface{ GetPodSandboxId() string })
+		if !ok {
+			return newError(codes.Internal, ReasonEvaluationFailed, map[string]string{MetadataPodSandboxID: podSandboxID}, "request %T has no pod sandbox ID", req)
+		}
 
-	return nil
-}
+		return p.verifyPodSandboxIDMatch(r.GetPodSandboxId(), podSandboxID, requestName(req)+".PodSandboxId")
+	case scopeContainer:
+		r, ok := req.(interface{ GetContainerId() string })
+		if !ok {
+			return newError(codes.Internal, ReasonEvaluationFailed, map[string]string{MetadataPodSandboxID: podSandboxID}, "request %T has no container ID", req)
+		}
 
-func (p *podScopedPolicy) verifyContainerIDBelongsToPod(ctx context.Context, containerID, expectedPodSandboxID string) error {
-	err := p.verifyContainerPodSandboxID(ctx, containerID, expectedPodSandboxID)
-	if err != nil {
-		return status.Errorf(codes.PermissionDenied, "%s: %v", ErrMethodNotAllowed, err)
+		return p.verifyContainerIDBelongsToPod(ctx, r.GetContainerId(), podSandboxID)
+	case scopeList:
+		return p.verifyListRequest(req, podSandboxID)
+	case scopeDenied, scopeNode:
 	}
 
 	return nil
 }
 
-func (p *podScopedPolicy) verifyRequest(ctx context.Context, req interface{}, podSandboxID string) error {
+// verifyListRequest narrows the filter of a list request to the pod sandbox.
+// List requests that cannot be narrowed are denied.
+func (p *podScopedPolicy) verifyListRequest(req interface{}, podSandboxID string) error {
 	switch r := req.(type) {
 	case *runtimeapi.ListContainersRequest:
 		return p.verifyListContainersRequest(r, podSandboxID)
 	case *runtimeapi.ListContainerStatsRequest:
 		return p.verifyListContainerStatsRequest(r, podSandboxID)
+	case *runtimeapi.ListPodSandboxRequest:
+		return p.verifyListPodSandboxRequest(r, podSandboxID)
 	case *runtimeapi.ListPodSandboxStatsRequest:
 		return p.verifyListPodSandboxStatsRequest(r, podSandboxID)
-	case *runtimeapi.CreateContainerRequest:
-		return p.verifyPodSandboxIDMatch(r.GetPodSandboxId(), podSandboxID, "CreateContainerRequest.PodSandboxId")
-	case *runtimeapi.StopPodSandboxRequest:
-		return p.verifyPodSandboxIDMatch(r.GetPodSandboxId(), podSandboxID, "StopPodSandboxRequest.PodSandboxId")
-	case *runtimeapi.RemovePodSandboxRequest:
-		return p.verifyPodSandboxIDMatch(r.GetPodSandboxId(), podSandboxID, "RemovePodSandboxRequest.PodSandboxId")
-	case *runtimeapi.PodSandboxStatusRequest:
-		return p.verifyPodSandboxIDMatch(r.GetPodSandboxId(), podSandboxID, "PodSandboxStatusRequest.PodSandboxId")
-	case *runtimeapi.UpdatePodSandboxResourcesRequest:
-		return p.verifyPodSandboxIDMatch(r.GetPodSandboxId(), podSandboxID, "UpdatePodSandboxResourcesRequest.PodSandboxId")
-	case *runtimeapi.StartContainerRequest:
-		return p.verifyContainerIDBelongsToPod(ctx, r.GetContainerId(), podSandboxID)
-	case *runtimeapi.StopContainerRequest:
-		return p.verifyContainerIDBelongsToPod(ctx, r.GetContainerId(), podSandboxID)
-	case *runtimeapi.RemoveContainerRequest:
-		return p.verifyContainerIDBelongsToPod(ctx, r.GetContainerId(), podSandboxID)
-	case *runtimeapi.ContainerStatusRequest:
-		return p.verifyContainerIDBelongsToPod(ctx, r.GetContainerId(), podSandboxID)
-	case *runtimeapi.ExecSyncRequest:
-		return p.verifyContainerIDBelongsToPod(ctx, r.GetContainerId(), podSandboxID)
-	case *runtimeapi.AttachRequest:
-		return p.verifyContainerIDBelongsToPod(ctx, r.GetContainerId(), podSandboxID)
-	case *runtimeapi.PortForwardRequest:
-		return p.verifyContainerIDBelongsToPod(ctx, r.GetPodSandboxId(), podSandboxID)
-	case *runtimeapi.UpdateContainerResourcesRequest:
-		return p.verifyContainerIDBelongsToPod(ctx, r.GetContainerId(), podSandboxID)
-	case *runtimeapi.ContainerStatsRequest:
-		return p.verifyContainerIDBelongsToPod(ctx, r.GetContainerId(), podSandboxID)
-	default:
+	case *runtimeapi.ListPodSandboxMetricsRequest:
+		// The request has no filter, the response is filtered instead.
 		return nil
+	default:
+		return newError(codes.PermissionDenied, ReasonMethodNotAllowed, map[string]string{MetadataPodSandboxID: podSandboxID}, "%s: %s cannot be restricted to a pod sandbox", ErrMethodNotAllowed, requestName(req))
 	}
 }
 
@@ -302,7 +219,7 @@ func (p *podScopedPolicy) verifyListContainersRequest(r *runtimeapi.ListContaine
 		}
 	} else {
 		if r.GetFilter().GetPodSandboxId() != "" && r.GetFilter().GetPodSandboxId() != podSandboxID {
-			return status.Errorf(codes.PermissionDenied, "%s: ListContainersRequest.Filter.PodSandboxId does not match", ErrMethodNotAllowed)
+			return newError(codes.PermissionDenied, ReasonMethodNotAllowed, map[string]string{MetadataPodSandboxID: podSandboxID}, "%s: ListContainersRequest.Filter.PodSandboxId does not match", ErrMethodNotAllowed)
 		}
 
 		r.Filter.PodSandboxId = podSandboxID
@@ -318,7 +235,7 @@ func (p *podScopedPolicy) verifyListContainerStatsRequest(r *runtimeapi.ListCont
 		}
 	} else {
 		if r.GetFilter().GetPodSandboxId() != "" && r.GetFilter().GetPodSandboxId() != podSandboxID {
-			return status.Errorf(codes.PermissionDenied, "%s: ListContainerStatsRequest.Filter.PodSandboxId does not match", ErrMethodNotAllowed)
+			return newError(codes.PermissionDenied, ReasonMethodNotAllowed, map[string]string{MetadataPodSandboxID: podSandboxID}, "%s: ListContainerStatsRequest.Filter.PodSandboxId does not match", ErrMethodNotAllowed)
 		}
 
 		r.Filter.PodSandboxId = podSandboxID
@@ -327,6 +244,22 @@ func (p *podScopedPolicy) verifyListContainerStatsRequest(r *runtimeapi.ListCont
 	return nil
 }
 
+func (p *podScopedPolicy) verifyListPodSandboxRequest(r *runtimeapi.ListPodSandboxRequest, podSandboxID string) error {
+	if r.GetFilter() == nil {
+		r.Filter = &runtimeapi.PodSandboxFilter{
+			Id: podSandboxID,
+		}
+	} else {
+		if r.GetFilter().GetId() != "" && r.GetFilter().GetId() != podSandboxID {
+			return newError(codes.PermissionDenied, ReasonMethodNotAllowed, map[string]string{MetadataPodSandboxID: podSandboxID}, "%s: ListPodSandboxRequest.Filter.Id does not match", ErrMethodNotAllowed)
+		}
+
+		r.Filter.Id = podSandboxID
+	}
+
+	return nil
+}
+
 func (p *podScopedPolicy) verifyListPodSandboxStatsRequest(r *runtimeapi.ListPodSandboxStatsRequest, podSandboxID string) error {
 	if r.GetFilter() == nil {
 		r.Filter = &runtimeapi.PodSandboxStatsFilter{
@@ -334,7 +267,7 @@ func (p *podScopedPolicy) verifyListPodSandboxStatsRequest(r *runtimeapi.ListPod
 		}
 	} else {
 		if r.GetFilter().GetId() != "" && r.GetFilter().GetId() != podSandboxID {
-			return status.Errorf(codes.PermissionDenied, "%s: ListPodSandboxStatsRequest.Filter.Id does not match", ErrMethodNotAllowed)
+			return newError(codes.PermissionDenied, ReasonMethodNotAllowed, map[string]string{MetadataPodSandboxID: podSandboxID}, "%s: ListPodSandboxStatsRequest.Filter.Id does not match", ErrMethodNotAllowed)
 		}
 
 		r.Filter.Id = podSandboxID
@@ -343,6 +276,79 @@ func (p *podScopedPolicy) verifyListPodSandboxStatsRequest(r *runtimeapi.ListPod
 	return nil
 }
 
+// filterResponse removes the resources of other pod sandboxes from list
+// responses, in case the runtime does not honor the narrowed filters.
+func (p *podScopedPolicy) filterResponse(ctx context.Context, resp interface{}, podSandboxID string) error {
+	switch r := resp.(type) {
+	case *runtimeapi.ListContainersResponse:
+		r.Containers, _ = filterInScope(r.GetContainers(), func(c *runtimeapi.Container) (bool, error) {
+			return c.GetPodSandboxId() == podSandboxID, nil
+		})
+	case *runtimeapi.ListContainerStatsResponse:
+		if len(r.GetStats()) == 0 {
+			return nil
+		}
+
+		// Container stats do not name the pod sandbox of their container.
+		containerIDs, err := podSandboxContainerIDs(ctx, p.runtimeClient, podSandboxID)
+		if err != nil {
+			return err
+		}
+
+		r.Stats, _ = filterInScope(r.GetStats(), func(s *runtimeapi.ContainerStats) (bool, error) {
+			return containerIDs[s.GetAttributes().GetId()], nil
+		})
+	case *runtimeapi.ListPodSandboxResponse:
+		r.Items, _ = filterInScope(r.GetItems(), func(s *runtimeapi.PodSandbox) (bool, error) {
+			return s.GetId() == podSandboxID, nil
+		})
+	case *runtimeapi.ListPodSandboxStatsResponse:
+		r.Stats, _ = filterInScope(r.GetStats(), func(s *runtimeapi.PodSandboxStats) (bool, error) {
+			return s.GetAttributes().GetId() == podSandboxID, nil
+		})
+	case *runtimeapi.ListPodSandboxMetricsResponse:
+		r.PodMetrics, _ = filterInScope(r.GetPodMetrics(), func(m *runtimeapi.PodSandboxMetrics) (bool, error) {
+			return m.GetPodSandboxId() == podSandboxID, nil
+		})
+	default:
+		return newError(codes.Internal, ReasonEvaluationFailed, nil, "cannot filter response of type %T", resp)
+	}
+
+	return nil
+}
+
+// podSandboxContainerIDs returns the IDs of the containers of a pod sandbox.
+func podSandboxContainerIDs(ctx context.Context, runtimeClient runtimeapi.RuntimeServiceClient, podSandboxID string) (map[string]bool, error) {
+	resp, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{
+		Filter: &runtimeapi.ContainerFilter{
+			PodSandboxId: podSandboxID,
+		},
+	})
+	if err != nil {
+		return nil, newError(codes.Internal, ReasonRuntimeLookupFailed, nil, "failed to list containers: %v", err)
+	}
+
+	containerIDs := make(map[string]bool, len(resp.GetContainers()))
+
+	for _, c := range resp.GetContainers() {
+		if c.GetPodSandboxId() == podSandboxID {
+			containerIDs[c.GetId()] = true
+		}
+	}
+
+	return containerIDs, nil
+}
+
+// requestName returns the name of the message of a request, e.g.
+// CreateContainerRequest.
+func requestName(req interface{}) string {
+	if m, ok := req.(proto.Message); ok {
+		return string(m.ProtoReflect().Descriptor().Name())
+	}
+
+	return fmt.Sprintf("%T", req)
+}
+
 type filteredStream struct {
 	grpc.ServerStream
 
@@ -355,7 +361,7 @@ func (s *filteredStream) SendMsg(m interface{}) error {
 		var err error
 
 		//TODO: cache container to pod sandbox mapping in future, account for containers being removed
-		podSandboxID, err := s.policy.getPodSandboxIDFromContainerID(s.Context(), event.GetContainerId())
+		podSandboxID, err := getPodSandboxIDFromContainerID(s.Context(), s.policy.runtimeClient, event.GetContainerId())
 		if err != nil {
 			// If we fail to get the pod sandbox ID, we assume the container does not exist and we should not send the event.
 			// This can happen if the container was removed before we could get its status.
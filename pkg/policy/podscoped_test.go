@@ -10,7 +10,9 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 
 	"cri-lite/pkg/fake"
@@ -128,6 +130,13 @@ var _ = Describe("PodScoped Policy", func() {
 			})
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("method not allowed by policy"))
+
+			By("checkpointing a container of the pod sandbox to a path of the node (denied)")
+			_, err = runtimeClient.CheckpointContainer(ctx, &runtimeapi.CheckpointContainerRequest{
+				ContainerId: "test-container-id",
+				Location:    "/etc/cron.d/checkpoint.tar",
+			})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
 		})
 
 		It("should deny all image service calls", func() {
@@ -260,6 +269,42 @@ var _ = Describe("PodScoped Policy", func() {
 			Expect(resp.GetStats()[0].GetAttributes().GetMetadata().GetName()).To(Equal("container-1"))
 		})
 
+		It("should filter ListPodSandbox", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			mock.SetPodSandboxes([]*runtimeapi.PodSandbox{
+				{Id: podSandboxID},
+				{Id: otherPodSandboxID},
+			})
+
+			resp, err := runtimeClient.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetItems()).To(HaveLen(1))
+			Expect(resp.GetItems()[0].GetId()).To(Equal(podSandboxID))
+
+			By("filtering by another pod sandbox (denied)")
+			_, err = runtimeClient.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{
+				Filter: &runtimeapi.PodSandboxFilter{Id: otherPodSandboxID},
+			})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+
+		It("should filter ListPodSandboxMetrics", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			mock.SetPodSandboxMetrics([]*runtimeapi.PodSandboxMetrics{
+				{PodSandboxId: podSandboxID},
+				{PodSandboxId: otherPodSandboxID},
+			})
+
+			resp, err := runtimeClient.ListPodSandboxMetrics(ctx, &runtimeapi.ListPodSandboxMetricsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetPodMetrics()).To(HaveLen(1))
+			Expect(resp.GetPodMetrics()[0].GetPodSandboxId()).To(Equal(podSandboxID))
+		})
+
 		It("should not filter ListContainers when runtime respects the filter", func() {
 			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 			defer cancel()
@@ -297,4 +342,172 @@ var _ = Describe("PodScoped Policy", func() {
 			Expect(err).To(MatchError(io.EOF))
 		})
 	})
+
+	Context("combined with the ReadOnly policy", func() {
+		var (
+			containerID1 = "container-id-1"
+			containerID2 = "container-id-2"
+		)
+
+		BeforeEach(func() {
+			proxyServer.SetPolicies(
+				policy.NewPodScopedPolicy(podSandboxID, false, proxyServer.GetRuntimeClient()),
+				policy.NewReadOnlyPolicy(),
+			)
+
+			go func() {
+				defer GinkgoRecover()
+				Expect(proxyServer.Start(proxySocket)).To(Succeed())
+			}()
+
+			Eventually(func() error {
+				conn, err := net.Dial("unix", proxySocket)
+				if err != nil {
+					return err
+				}
+				if err := conn.Close(); err != nil {
+					return err
+				}
+
+				return nil
+			}, "5s", "100ms").Should(Succeed())
+
+			mock.SetContainers([]*runtimeapi.Container{
+				{Id: containerID1, PodSandboxId: podSandboxID},
+				{Id: containerID2, PodSandboxId: otherPodSandboxID},
+			})
+		})
+
+		It("should only allow calls allowed by both policies", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			By("calling a read-only method of the pod (allowed)")
+			_, err := runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{
+				PodSandboxId: podSandboxID,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("calling a read-only method of another pod (denied by PodScoped)")
+			_, err = runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{
+				PodSandboxId: otherPodSandboxID,
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("method not allowed by policy"))
+
+			By("calling a mutating method of the pod (denied by ReadOnly)")
+			_, err = runtimeClient.StopContainer(ctx, &runtimeapi.StopContainerRequest{
+				ContainerId: containerID1,
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("method not allowed by policy"))
+		})
+
+		It("should apply the filters of the policies", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			resp, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetContainers()).To(HaveLen(1))
+			Expect(resp.GetContainers()[0].GetId()).To(Equal(containerID1))
+		})
+
+		It("should report all policies in the version", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			resp, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetRuntimeName()).To(HaveSuffix("with policy podScoped, readonly"))
+		})
+	})
+})
+
+// containerLister is a runtime client that knows no containers.
+type containerLister struct {
+	runtimeapi.RuntimeServiceClient
+}
+
+func (containerLister) ListContainers(context.Context, *runtimeapi.ListContainersRequest, ...grpc.CallOption) (*runtimeapi.ListContainersResponse, error) {
+	return &runtimeapi.ListContainersResponse{}, nil
+}
+
+var _ = Describe("PodScoped Policy methods", func() {
+	// reachRuntime are the methods that reach the runtime with an empty
+	// request: the node-level methods, and the list methods whose filters
+	// or responses are restricted to the pod sandbox.
+	reachRuntime := map[string]bool{
+		"Version":               true,
+		"Status":                true,
+		"RuntimeConfig":         true,
+		"ListMetricDescriptors": true,
+		"ListPodSandbox":        true,
+		"ListContainers":        true,
+		"ListContainerStats":    true,
+		"ListPodSandboxStats":   true,
+		"ListPodSandboxMetrics": true,
+	}
+
+	invoke := func(srv interface{}, method grpc.MethodDesc) error {
+		p := policy.NewPodScopedPolicy("test-sandbox-id", false, containerLister{})
+
+		// The generated handler decodes into an empty request of the method
+		// and calls the runtime through the interceptor of the policy.
+		_, err := method.Handler(srv, context.Background(), func(interface{}) error {
+			return nil
+		}, p.UnaryInterceptor())
+
+		return err
+	}
+
+	It("should classify every RuntimeService method", func() {
+		for _, method := range runtimeapi.RuntimeService_ServiceDesc.Methods {
+			Expect(policy.MethodClassified("/runtime.v1.RuntimeService/"+method.MethodName)).To(BeTrue(), method.MethodName)
+		}
+
+		for _, stream := range runtimeapi.RuntimeService_ServiceDesc.Streams {
+			Expect(policy.MethodClassified("/runtime.v1.RuntimeService/"+stream.StreamName)).To(BeTrue(), stream.StreamName)
+		}
+	})
+
+	It("should only forward unscoped requests of node-level and list methods", func() {
+		for _, method := range runtimeapi.RuntimeService_ServiceDesc.Methods {
+			err := invoke(runtimeapi.UnimplementedRuntimeServiceServer{}, method)
+			if reachRuntime[method.MethodName] {
+				Expect(status.Code(err)).To(Equal(codes.Unimplemented), method.MethodName)
+			} else {
+				Expect(status.Code(err)).To(Equal(codes.PermissionDenied), method.MethodName)
+			}
+		}
+	})
+
+	It("should deny ImageService methods but ImageFsInfo", func() {
+		for _, method := range runtimeapi.ImageService_ServiceDesc.Methods {
+			err := invoke(runtimeapi.UnimplementedImageServiceServer{}, method)
+			if method.MethodName == "ImageFsInfo" {
+				Expect(status.Code(err)).To(Equal(codes.Unimplemented))
+			} else {
+				Expect(status.Code(err)).To(Equal(codes.PermissionDenied), method.MethodName)
+			}
+		}
+	})
+
+	It("should deny unknown methods", func() {
+		p := policy.NewPodScopedPolicy("test-sandbox-id", false, containerLister{})
+
+		_, err := p.UnaryInterceptor()(context.Background(), &runtimeapi.VersionRequest{}, &grpc.UnaryServerInfo{
+			FullMethod: "/runtime.v1.RuntimeService/NewMethod",
+		}, func(context.Context, interface{}) (interface{}, error) {
+			return &runtimeapi.VersionResponse{}, nil
+		})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+		err = p.StreamInterceptor()(nil, nil, &grpc.StreamServerInfo{
+			FullMethod: "/runtime.v1.RuntimeService/NewStream",
+		}, func(interface{}, grpc.ServerStream) error {
+			return nil
+		})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+	})
 })
@@ -2,13 +2,18 @@
 package policy
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"google.golang.org/grpc"
 	"gopkg.in/yaml.v3"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 	"k8s.io/klog/v2"
 )
 
@@ -29,30 +34,58 @@ type Policy interface {
 
 // Config is the configuration for a policy.
 type Config struct {
-	ReadOnly bool `yaml:"read-only"`
+	// Name is the name reported by the policy. It defaults to the base name of the policy file.
+	Name     string `yaml:"name,omitempty"`
+	ReadOnly bool   `yaml:"read-only"`
+	// Rules are the rules of a declarative policy, see NewDeclarativePolicy.
+	Rules []Rule `yaml:"rules,omitempty"`
 }
 
-// NewFromConfig creates a new policy from a config file.
-func NewFromConfig(path string) (Policy, error) {
+// LoadConfig reads and parses a policy config file.
+func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read policy config file: %w", err)
 	}
 
 	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+
+	// Unknown keys are rejected so that a misspelled condition does not
+	// silently widen the policy.
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
 		return nil, fmt.Errorf("failed to unmarshal policy config: %w", err)
 	}
 
-	return NewFromConfigData(&config)
+	if config.Name == "" {
+		config.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	return &config, nil
+}
+
+// NewFromConfig creates a new policy from a config file.
+func NewFromConfig(path string, runtimeClient runtimeapi.RuntimeServiceClient) (Policy, error) {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFromConfigData(config, runtimeClient)
 }
 
 // NewFromConfigData creates a new policy from a config struct.
-func NewFromConfigData(config *Config) (Policy, error) {
+func NewFromConfigData(config *Config, runtimeClient runtimeapi.RuntimeServiceClient) (Policy, error) {
 	if config.ReadOnly {
 		return NewReadOnlyPolicy(), nil
 	}
 
+	if len(config.Rules) > 0 {
+		return NewDeclarativePolicy(config.Name, config.Rules, runtimeClient)
+	}
+
 	return nil, ErrUnknownPolicyType
 }
 
@@ -34,9 +34,25 @@ func createSocket(sockDir string) string {
 	return path
 }
 
-func setupTestEnvironment(p policy.Policy) (
+// policiesFunc builds the policies of the proxy of a test environment. The
+// clients of the proxy reach the fake runtime, for the policies looking up
+// objects.
+type policiesFunc func(proxyServer *proxy.Server) []policy.Policy
+
+// withPolicies is the policiesFunc of policies that need no client.
+func withPolicies(policies ...policy.Policy) policiesFunc {
+	return func(*proxy.Server) []policy.Policy {
+		return policies
+	}
+}
+
+// setupTestEnvironment starts a fake runtime, and a proxy in front of it
+// enforcing the policies returned by newPolicies. It returns the clients of
+// the proxy, the fake runtime, and a function cleaning up.
+func setupTestEnvironment(newPolicies policiesFunc) (
 	runtimeapi.RuntimeServiceClient,
 	runtimeapi.ImageServiceClient,
+	*fake.Server,
 	func(),
 ) {
 	sockDir, err := os.MkdirTemp("", "cri-lite-test")
@@ -46,9 +62,9 @@ func setupTestEnvironment(p policy.Policy) (
 	proxySocket := createSocket(sockDir)
 	serverAddress := "unix://" + serverSocket
 
-	server := startFakeServer(serverSocket)
+	server, mock := startFakeServer(serverSocket)
 
-	startProxyServer(proxySocket, serverAddress, p)
+	startProxyServer(proxySocket, serverAddress, newPolicies)
 	runtimeClient, imageClient := createClients(proxySocket)
 
 	cleanup := func() {
@@ -61,13 +77,11 @@ func setupTestEnvironment(p policy.Policy) (
 		}
 	}
 
-	return runtimeClient, imageClient, cleanup
+	return runtimeClient, imageClient, mock, cleanup
 }
 
-func startFakeServer(serverSocket string) *grpc.Server {
-	var lis net.Listener
-
-	server, lis, _, err := fake.NewServer(serverSocket)
+func startFakeServer(serverSocket string) (*grpc.Server, *fake.Server) {
+	server, lis, mock, err := fake.NewServer(serverSocket)
 	Expect(err).NotTo(HaveOccurred())
 
 	go func() {
@@ -76,13 +90,13 @@ func startFakeServer(serverSocket string) *grpc.Server {
 		Expect(server.Serve(lis)).To(Succeed())
 	}()
 
-	return server
+	return server, mock
 }
 
-func startProxyServer(proxySocket, serverAddress string, p policy.Policy) {
+func startProxyServer(proxySocket, serverAddress string, newPolicies policiesFunc) {
 	proxyServer, err := proxy.NewServer(serverAddress, serverAddress)
 	Expect(err).NotTo(HaveOccurred())
-	proxyServer.SetPolicy(p)
+	proxyServer.SetPolicies(newPolicies(proxyServer)...)
 
 	go func() {
 		defer GinkgoRecover()
@@ -0,0 +1,148 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+var (
+	ErrInvalidPortForward = errors.New("invalid port forward allowlist")
+	ErrPortNotAllowed     = errors.New("port forward not allowed")
+)
+
+// PortForward is the configuration of the PortForward policy.
+type PortForward struct {
+	// AllowedPorts are the ports that can be forwarded in addition to the
+	// container ports declared by the pod sandbox.
+	AllowedPorts []int32 `yaml:"allowed-ports,omitempty"`
+	// DenyAll denies every PortForward call.
+	DenyAll bool `yaml:"deny-all-port-forward,omitempty"`
+}
+
+// portForwardPolicy restricts the ports forwarded by PortForward to the TCP
+// container ports declared in the port mappings of the pod sandbox and to an
+// allowlist. Other methods are passed through, so it is meant to be combined
+// with a policy scoping the pod sandboxes, e.g. PodScoped. The runtime does not
+// hold streams to the ports of their call, so the endpoint must also have a
+// streaming server, which enforces the ports on the stream.
+type portForwardPolicy struct {
+	portForward   PortForward
+	runtimeClient runtimeapi.RuntimeServiceClient
+}
+
+// NewPortForwardPolicy creates a new PortForward policy.
+func NewPortForwardPolicy(portForward PortForward, runtimeClient runtimeapi.RuntimeServiceClient) (Policy, error) {
+	for _, port := range portForward.AllowedPorts {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("%w: port %d is out of range", ErrInvalidPortForward, port)
+		}
+	}
+
+	if portForward.DenyAll && len(portForward.AllowedPorts) > 0 {
+		return nil, fmt.Errorf("%w: allowed-ports cannot be set with deny-all-port-forward", ErrInvalidPortForward)
+	}
+
+	return &portForwardPolicy{
+		portForward:   portForward,
+		runtimeClient: runtimeClient,
+	}, nil
+}
+
+// Name implements the Policy interface.
+func (p *portForwardPolicy) Name() string {
+	return "portForward"
+}
+
+// UnaryInterceptor implements the Policy interface.
+func (p *portForwardPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		interceptor := func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			r, ok := req.(*runtimeapi.PortForwardRequest)
+			if !ok {
+				return handler(ctx, req)
+			}
+
+			err := p.verifyPorts(ctx, r)
+			if err != nil {
+				return nil, err
+			}
+
+			return handler(ctx, req)
+		}
+
+		return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return loggingInterceptor(ctx, req, info, handler)
+		})
+	}
+}
+
+// StreamInterceptor implements the Policy interface.
+func (p *portForwardPolicy) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		return handler(srv, ss)
+	}
+}
+
+// verifyPorts checks that every port of a PortForward request is allowed.
+// Requests without ports are denied, since they do not tell which ports
+// will be forwarded.
+func (p *portForwardPolicy) verifyPorts(ctx context.Context, r *runtimeapi.PortForwardRequest) error {
+	metadata := map[string]string{MetadataPodSandboxID: r.GetPodSandboxId()}
+
+	if p.portForward.DenyAll {
+		return newError(codes.PermissionDenied, ReasonPortNotAllowed, metadata, "%s: port forwarding is disabled", ErrPortNotAllowed)
+	}
+
+	if len(r.GetPort()) == 0 {
+		return newError(codes.PermissionDenied, ReasonPortNotAllowed, metadata, "%s: no ports requested", ErrPortNotAllowed)
+	}
+
+	allowed := slices.Clone(p.portForward.AllowedPorts)
+
+	config, err := podSandboxConfig(ctx, p.runtimeClient, r.GetPodSandboxId())
+	if err != nil {
+		return err
+	}
+
+	for _, mapping := range config.GetPortMappings() {
+		if mapping.GetProtocol() == runtimeapi.Protocol_TCP {
+			allowed = append(allowed, mapping.GetContainerPort())
+		}
+	}
+
+	for _, port := range r.GetPort() {
+		if !slices.Contains(allowed, port) {
+			klog.FromContext(ctx).V(4).Info("port forward not allowed", "podSandboxID", r.GetPodSandboxId(), "port", port, "allowed", allowed)
+
+			metadata[MetadataPort] = strconv.Itoa(int(port))
+
+			return newError(codes.PermissionDenied, ReasonPortNotAllowed, metadata, "%s: port %d is not declared by pod sandbox %s", ErrPortNotAllowed, port, r.GetPodSandboxId())
+		}
+	}
+
+	return nil
+}
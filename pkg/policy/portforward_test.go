@@ -0,0 +1,117 @@
+package policy_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/fake"
+	"cri-lite/pkg/policy"
+	"cri-lite/pkg/proxy"
+)
+
+var _ = Describe("PortForward Policy", func() {
+	var (
+		runtimeClient runtimeapi.RuntimeServiceClient
+		cleanup       func()
+		portForward   policy.PortForward
+	)
+
+	JustBeforeEach(func() {
+		var mock *fake.Server
+
+		runtimeClient, _, mock, cleanup = setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+			p, err := policy.NewPortForwardPolicy(portForward, proxyServer.GetRuntimeClient())
+			Expect(err).NotTo(HaveOccurred())
+
+			return []policy.Policy{p}
+		})
+
+		mock.SetPodSandboxes([]*runtimeapi.PodSandbox{{Id: "web-pod"}, {Id: "undeclared-pod"}})
+		mock.SetPodSandboxConfig("web-pod", &runtimeapi.PodSandboxConfig{
+			PortMappings: []*runtimeapi.PortMapping{
+				{Protocol: runtimeapi.Protocol_TCP, ContainerPort: 8080, HostPort: 80},
+				{Protocol: runtimeapi.Protocol_UDP, ContainerPort: 53},
+			},
+		})
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	forward := func(podSandboxID string, ports ...int32) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := runtimeClient.PortForward(ctx, &runtimeapi.PortForwardRequest{
+			PodSandboxId: podSandboxID,
+			Port:         ports,
+		})
+
+		return err
+	}
+
+	Context("with an allowlist", func() {
+		BeforeEach(func() {
+			portForward = policy.PortForward{AllowedPorts: []int32{9090}}
+		})
+
+		It("should allow the declared and the allowed ports", func() {
+			Expect(forward("web-pod", 8080)).To(Succeed())
+			Expect(forward("web-pod", 8080, 9090)).To(Succeed())
+			Expect(forward("undeclared-pod", 9090)).To(Succeed())
+		})
+
+		DescribeTable("should deny other ports",
+			func(podSandboxID string, ports ...int32) {
+				err := forward(podSandboxID, ports...)
+				Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+				Expect(errors.Is(policy.FromError(err), policy.ErrPortNotAllowed)).To(BeTrue())
+			},
+			Entry("a host port", "web-pod", int32(80)),
+			Entry("a UDP port", "web-pod", int32(53)),
+			Entry("a port among declared ones", "web-pod", int32(8080), int32(22)),
+			Entry("a port declared by another pod sandbox", "undeclared-pod", int32(8080)),
+			Entry("no ports", "web-pod"),
+		)
+
+		It("should pass other calls through", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("with deny-all-port-forward", func() {
+		BeforeEach(func() {
+			portForward = policy.PortForward{DenyAll: true}
+		})
+
+		It("should deny declared ports", func() {
+			err := forward("web-pod", 8080)
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(err.Error()).To(ContainSubstring("port forwarding is disabled"))
+		})
+	})
+})
+
+var _ = Describe("NewPortForwardPolicy", func() {
+	DescribeTable("should reject invalid allowlists",
+		func(portForward policy.PortForward) {
+			_, err := policy.NewPortForwardPolicy(portForward, nil)
+			Expect(err).To(MatchError(policy.ErrInvalidPortForward))
+		},
+		Entry("port zero", policy.PortForward{AllowedPorts: []int32{0}}),
+		Entry("port out of range", policy.PortForward{AllowedPorts: []int32{65536}}),
+		Entry("allowed ports with deny-all", policy.PortForward{AllowedPorts: []int32{8080}, DenyAll: true}),
+	)
+})
@@ -0,0 +1,391 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+var (
+	ErrInvalidRateLimit = errors.New("invalid rate limit")
+	ErrRateLimited      = errors.New("rate limit exceeded")
+	ErrTooManyInFlight  = errors.New("too many calls in flight")
+)
+
+// Keys identifying the callers of the RateLimit policy.
+const (
+	// CallerKeyUID limits the callers by their UID.
+	CallerKeyUID = "uid"
+	// CallerKeyPodSandbox limits the callers by their pod sandbox. Callers
+	// that are not in a pod are limited by their UID.
+	CallerKeyPodSandbox = "pod-sandbox"
+)
+
+// rateLimitSweepInterval is how often the limiters of idle callers are dropped.
+const rateLimitSweepInterval = time.Minute
+
+// callerPodTTL is how long the pod sandbox of a caller PID is remembered. A PID
+// reused within it is limited as the pod sandbox of the previous process.
+var callerPodTTL = time.Minute
+
+// maxCallerPods bounds the pod sandboxes of caller PIDs remembered between
+// sweeps.
+const maxCallerPods = 4096
+
+// RateLimit is the configuration of the RateLimit policy.
+type RateLimit struct {
+	// CallerKey is either CallerKeyUID or CallerKeyPodSandbox. It defaults to
+	// CallerKeyUID.
+	CallerKey string `yaml:"caller-key,omitempty"`
+	// Groups are the limited groups of methods. A call is limited by the first
+	// group matching its method; calls matching no group are not limited.
+	Groups []RateLimitGroup `yaml:"groups"`
+}
+
+// RateLimitGroup limits the calls of every caller to a group of methods.
+type RateLimitGroup struct {
+	Name string `yaml:"name"`
+	// Methods are globs matching full method names, e.g.
+	// /runtime.v1.RuntimeService/List*.
+	Methods []string `yaml:"methods"`
+	// Rate is the number of calls per second refilling the token bucket of a
+	// caller. Zero means no rate limit.
+	Rate float64 `yaml:"rate,omitempty"`
+	// Burst is the size of the token bucket. It defaults to Rate rounded up.
+	Burst int `yaml:"burst,omitempty"`
+	// MaxInFlight is the number of calls a caller can have in flight. Zero
+	// means no limit.
+	MaxInFlight int `yaml:"max-in-flight,omitempty"`
+}
+
+// rateLimitKey identifies the limiter of a caller for a group.
+type rateLimitKey struct {
+	caller string
+	group  string
+}
+
+// callerLimiter is the state of a caller for a group.
+type callerLimiter struct {
+	limiter  *rate.Limiter
+	inFlight int
+}
+
+// callerPod is the pod sandbox of a caller PID, which is empty for callers
+// outside of pod sandboxes.
+type callerPod struct {
+	podSandboxID string
+	resolved     time.Time
+}
+
+// rateLimitPolicy limits the rate and the concurrency of the calls of every
+// caller. Policies are created per endpoint, so the limits of a caller are
+// not shared between endpoints. Calls within the limits are passed through,
+// so it is meant to be combined with other policies. It must be the first
+// of them, so that calls over the limits are denied before the other
+// policies look anything up in the runtime.
+type rateLimitPolicy struct {
+	config        RateLimit
+	runtimeClient runtimeapi.RuntimeServiceClient
+
+	mu         sync.Mutex
+	limiters   map[rateLimitKey]*callerLimiter
+	callerPods map[int32]callerPod
+	lastSweep  time.Time
+}
+
+// NewRateLimitPolicy creates a new RateLimit policy.
+func NewRateLimitPolicy(config RateLimit, runtimeClient runtimeapi.RuntimeServiceClient) (Policy, error) {
+	switch config.CallerKey {
+	case "":
+		config.CallerKey = CallerKeyUID
+	case CallerKeyUID, CallerKeyPodSandbox:
+	default:
+		return nil, fmt.Errorf("%w: caller-key must be %q or %q, got %q", ErrInvalidRateLimit, CallerKeyUID, CallerKeyPodSandbox, config.CallerKey)
+	}
+
+	groups := make([]RateLimitGroup, 0, len(config.Groups))
+	names := make(map[string]bool, len(config.Groups))
+
+	for _, group := range config.Groups {
+		if group.Name == "" || names[group.Name] {
+			return nil, fmt.Errorf("%w: groups must have unique names, got %q", ErrInvalidRateLimit, group.Name)
+		}
+
+		names[group.Name] = true
+
+		if len(group.Methods) == 0 {
+			return nil, fmt.Errorf("%w: group %s has no methods", ErrInvalidRateLimit, group.Name)
+		}
+
+		for _, method := range group.Methods {
+			if _, err := path.Match(method, ""); err != nil {
+				return nil, fmt.Errorf("%w: group %s: invalid method pattern: %w", ErrInvalidRateLimit, group.Name, err)
+			}
+		}
+
+		if group.Rate < 0 || group.Burst < 0 || group.MaxInFlight < 0 {
+			return nil, fmt.Errorf("%w: limits of group %s must not be negative", ErrInvalidRateLimit, group.Name)
+		}
+
+		if group.Rate == 0 && group.MaxInFlight == 0 {
+			return nil, fmt.Errorf("%w: group %s sets neither rate nor max-in-flight", ErrInvalidRateLimit, group.Name)
+		}
+
+		if group.Rate > 0 && group.Burst == 0 {
+			group.Burst = int(math.Ceil(group.Rate))
+		}
+
+		groups = append(groups, group)
+	}
+
+	config.Groups = groups
+
+	return &rateLimitPolicy{
+		config:        config,
+		runtimeClient: runtimeClient,
+		limiters:      make(map[rateLimitKey]*callerLimiter),
+		callerPods:    make(map[int32]callerPod),
+	}, nil
+}
+
+// Name implements the Policy interface.
+func (p *rateLimitPolicy) Name() string {
+	return "rateLimit"
+}
+
+// UnaryInterceptor implements the Policy interface.
+func (p *rateLimitPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		interceptor := func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			release, err := p.acquire(ctx, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+
+			return handler(ctx, req)
+		}
+
+		return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return loggingInterceptor(ctx, req, info, handler)
+		})
+	}
+}
+
+// StreamInterceptor implements the Policy interface. A stream takes a token
+// when it starts and is in flight until it ends.
+func (p *rateLimitPolicy) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		release, err := p.acquire(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		return handler(srv, ss)
+	}
+}
+
+// acquire takes a token and an in-flight slot of the caller for the group of
+// method. The returned function releases the in-flight slot.
+func (p *rateLimitPolicy) acquire(ctx context.Context, method string) (func(), error) {
+	group := p.group(method)
+	if group == nil {
+		return func() {}, nil
+	}
+
+	caller, err := p.callerKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := klog.FromContext(ctx)
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sweep(now)
+
+	key := rateLimitKey{caller: caller, group: group.Name}
+
+	l, ok := p.limiters[key]
+	if !ok {
+		l = &callerLimiter{}
+		if group.Rate > 0 {
+			l.limiter = rate.NewLimiter(rate.Limit(group.Rate), group.Burst)
+		}
+
+		p.limiters[key] = l
+	}
+
+	if group.MaxInFlight > 0 && l.inFlight >= group.MaxInFlight {
+		logger.V(4).Info("too many calls in flight", "method", method, "caller", caller, "group", group.Name)
+
+		return nil, newError(codes.ResourceExhausted, ReasonTooManyInFlight, map[string]string{MetadataRule: group.Name}, "%s: %s has %d calls of group %s in flight", ErrTooManyInFlight, caller, l.inFlight, group.Name)
+	}
+
+	if l.limiter != nil {
+		reservation := l.limiter.ReserveN(now, 1)
+
+		delay := reservation.DelayFrom(now)
+		if delay > 0 {
+			reservation.CancelAt(now)
+			logger.V(4).Info("rate limit exceeded", "method", method, "caller", caller, "group", group.Name, "retryDelay", delay)
+
+			return nil, rateLimitedError(caller, group.Name, delay)
+		}
+	}
+
+	l.inFlight++
+
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		l.inFlight--
+	}, nil
+}
+
+// group returns the first group matching method, or nil if there is none.
+func (p *rateLimitPolicy) group(method string) *RateLimitGroup {
+	for i := range p.config.Groups {
+		for _, pattern := range p.config.Groups[i].Methods {
+			if ok, _ := path.Match(pattern, method); ok {
+				return &p.config.Groups[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// callerKey identifies the caller according to the configured caller key.
+func (p *rateLimitPolicy) callerKey(ctx context.Context) (string, error) {
+	authInfo, err := callerCredentials(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if p.config.CallerKey == CallerKeyPodSandbox {
+		podSandboxID := p.callerPodSandboxID(ctx, authInfo.GetPID())
+		if podSandboxID != "" {
+			return "pod sandbox " + podSandboxID, nil
+		}
+	}
+
+	return fmt.Sprintf("uid %d", authInfo.GetUID()), nil
+}
+
+// callerPodSandboxID returns the pod sandbox of a caller PID, or an empty
+// string if it is not in one. The pod sandboxes of PIDs are remembered, so
+// that the calls over the limits do not call the runtime. Failed lookups are
+// not, so that a transient error does not limit a pod sandbox by its UID
+// until the lookup expires.
+func (p *rateLimitPolicy) callerPodSandboxID(ctx context.Context, pid int32) string {
+	now := time.Now()
+
+	p.mu.Lock()
+	cached, ok := p.callerPods[pid]
+	p.mu.Unlock()
+
+	if ok && now.Sub(cached.resolved) < callerPodTTL {
+		return cached.podSandboxID
+	}
+
+	podSandboxID, err := podSandboxIDFromPID(ctx, p.runtimeClient, pid)
+	if err != nil {
+		klog.FromContext(ctx).V(4).Info("caller is not in a pod sandbox, limiting it by uid", "pid", pid, "err", err)
+
+		return ""
+	}
+
+	p.mu.Lock()
+
+	if len(p.callerPods) >= maxCallerPods {
+		clear(p.callerPods)
+	}
+
+	p.callerPods[pid] = callerPod{podSandboxID: podSandboxID, resolved: now}
+	p.mu.Unlock()
+
+	return podSandboxID
+}
+
+// sweep drops the limiters of the callers that have no call in flight and a
+// full token bucket, as they are equivalent to new limiters, and the expired
+// pod sandboxes of caller PIDs. It must be called with p.mu held.
+func (p *rateLimitPolicy) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+
+	p.lastSweep = now
+
+	for key, l := range p.limiters {
+		if l.inFlight > 0 {
+			continue
+		}
+
+		if l.limiter != nil && l.limiter.TokensAt(now) < float64(l.limiter.Burst()) {
+			continue
+		}
+
+		delete(p.limiters, key)
+	}
+
+	for pid, cached := range p.callerPods {
+		if now.Sub(cached.resolved) >= callerPodTTL {
+			delete(p.callerPods, pid)
+		}
+	}
+}
+
+// rateLimitedError returns a ResourceExhausted error telling the caller when
+// to retry.
+func rateLimitedError(caller, group string, delay time.Duration) error {
+	st := status.Newf(codes.ResourceExhausted, "%s: %s exceeded the rate of group %s, retry in %s", ErrRateLimited, caller, group, delay.Round(time.Millisecond))
+
+	detailed, err := st.WithDetails(
+		&errdetails.ErrorInfo{
+			Reason:   ReasonRateLimited,
+			Domain:   ErrorDomain,
+			Metadata: map[string]string{MetadataRule: group},
+		},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)},
+	)
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
@@ -0,0 +1,231 @@
+package policy_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/fake"
+	"cri-lite/pkg/policy"
+	"cri-lite/pkg/proxy"
+)
+
+var _ = Describe("RateLimit Policy", func() {
+	var (
+		mock          *fake.Server
+		runtimeClient runtimeapi.RuntimeServiceClient
+		cleanup       func()
+		config        policy.RateLimit
+		callerPod     string
+		lookups       atomic.Int32
+		restore       func()
+	)
+
+	BeforeEach(func() {
+		config = policy.RateLimit{
+			Groups: []policy.RateLimitGroup{
+				{
+					Name:    "list",
+					Methods: []string{"/runtime.v1.RuntimeService/List*"},
+					Rate:    0.001,
+					Burst:   2,
+				},
+				{
+					Name:        "exec",
+					Methods:     []string{"/runtime.v1.RuntimeService/ExecSync"},
+					MaxInFlight: 1,
+				},
+			},
+		}
+
+		callerPod = ""
+		lookups.Store(0)
+		restore = policy.SetPodSandboxIDFromPID(func(context.Context, runtimeapi.RuntimeServiceClient, int32) (string, error) {
+			lookups.Add(1)
+
+			if callerPod == "" {
+				return "", errNotInPod
+			}
+
+			return callerPod, nil
+		})
+	})
+
+	JustBeforeEach(func() {
+		runtimeClient, _, mock, cleanup = setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+			p, err := policy.NewRateLimitPolicy(config, proxyServer.GetRuntimeClient())
+			Expect(err).NotTo(HaveOccurred())
+
+			return []policy.Policy{p}
+		})
+	})
+
+	AfterEach(func() {
+		restore()
+		cleanup()
+	})
+
+	It("should deny calls over the rate of a group with a retry delay", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = runtimeClient.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{})
+		Expect(err).NotTo(HaveOccurred())
+
+		By("exceeding the burst of the group")
+		_, err = runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+		Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+		Expect(err.Error()).To(ContainSubstring(policy.ErrRateLimited.Error()))
+
+		var retryInfo *errdetails.RetryInfo
+		for _, detail := range status.Convert(err).Details() {
+			if info, ok := detail.(*errdetails.RetryInfo); ok {
+				retryInfo = info
+			}
+		}
+		Expect(retryInfo).NotTo(BeNil())
+		Expect(retryInfo.GetRetryDelay().AsDuration()).To(BeNumerically(">", time.Minute))
+		Expect(errors.Is(policy.FromError(err), policy.ErrRateLimited)).To(BeTrue())
+
+		By("calling methods of no group")
+		for range 5 {
+			_, err = runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+			Expect(err).NotTo(HaveOccurred())
+		}
+	})
+
+	It("should deny calls over the calls in flight of a group", func() {
+		mock.SetExecSyncDelay(time.Second)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		var wg sync.WaitGroup
+
+		errs := make([]error, 2)
+		for i := range errs {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				_, errs[i] = runtimeClient.ExecSync(ctx, &runtimeapi.ExecSyncRequest{ContainerId: "test-container-id"})
+			}()
+		}
+
+		wg.Wait()
+		Expect([]codes.Code{status.Code(errs[0]), status.Code(errs[1])}).To(ConsistOf(codes.OK, codes.ResourceExhausted))
+		Expect(errs).To(ContainElement(MatchError(ContainSubstring(policy.ErrTooManyInFlight.Error()))))
+
+		By("calling again once the call in flight returned")
+		mock.SetExecSyncDelay(0)
+		_, err := runtimeClient.ExecSync(ctx, &runtimeapi.ExecSyncRequest{ContainerId: "test-container-id"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("with pod sandbox caller keys", func() {
+		BeforeEach(func() {
+			config.CallerKey = policy.CallerKeyPodSandbox
+		})
+
+		It("should limit the callers of every pod sandbox separately", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			// All the calls come from the PID of the test, whose pod sandbox
+			// changes between calls.
+			DeferCleanup(policy.SetCallerPodTTL(0))
+
+			callerPod = "pod-a"
+			for range 2 {
+				_, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			_, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+			Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+
+			By("calling from another pod sandbox")
+			callerPod = "pod-b"
+			_, err = runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("calling from outside of a pod sandbox")
+			callerPod = ""
+			_, err = runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should look up the pod sandbox of a caller once", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			callerPod = "pod-a"
+			for range 2 {
+				_, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			_, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+			Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+			Expect(lookups.Load()).To(BeEquivalentTo(1))
+		})
+
+		It("should not remember the failed lookups of a caller", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("calling once the pod sandbox of the caller can be looked up")
+			callerPod = "pod-a"
+			for range 2 {
+				_, err = runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			Expect(lookups.Load()).To(BeEquivalentTo(2))
+		})
+	})
+})
+
+var _ = Describe("NewRateLimitPolicy", func() {
+	DescribeTable("should reject invalid configurations",
+		func(config policy.RateLimit) {
+			_, err := policy.NewRateLimitPolicy(config, nil)
+			Expect(err).To(MatchError(policy.ErrInvalidRateLimit))
+		},
+		Entry("unknown caller key", policy.RateLimit{CallerKey: "pid"}),
+		Entry("group without a name", policy.RateLimit{Groups: []policy.RateLimitGroup{
+			{Methods: []string{"*"}, Rate: 1},
+		}}),
+		Entry("duplicate groups", policy.RateLimit{Groups: []policy.RateLimitGroup{
+			{Name: "all", Methods: []string{"*"}, Rate: 1},
+			{Name: "all", Methods: []string{"*"}, Rate: 2},
+		}}),
+		Entry("group without methods", policy.RateLimit{Groups: []policy.RateLimitGroup{
+			{Name: "all", Rate: 1},
+		}}),
+		Entry("invalid method pattern", policy.RateLimit{Groups: []policy.RateLimitGroup{
+			{Name: "all", Methods: []string{"["}, Rate: 1},
+		}}),
+		Entry("negative rate", policy.RateLimit{Groups: []policy.RateLimitGroup{
+			{Name: "all", Methods: []string{"*"}, Rate: -1},
+		}}),
+		Entry("group without limits", policy.RateLimit{Groups: []policy.RateLimitGroup{
+			{Name: "all", Methods: []string{"*"}},
+		}}),
+	)
+})
@@ -6,7 +6,6 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 // readOnlyPolicy is a policy that allows only read-only CRI calls.
@@ -56,7 +55,7 @@ func (p *readOnlyPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
 			}
 
 			if !allowedMethods[info.FullMethod] {
-				return nil, status.Errorf(codes.PermissionDenied, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
+				return nil, newError(codes.PermissionDenied, ReasonMethodNotAllowed, nil, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
 			}
 
 			return handler(ctx, req)
@@ -82,7 +81,7 @@ func (p *readOnlyPolicy) StreamInterceptor() grpc.StreamServerInterceptor {
 		}
 
 		if !allowedMethods[info.FullMethod] {
-			return status.Errorf(codes.PermissionDenied, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
+			return newError(codes.PermissionDenied, ReasonMethodNotAllowed, nil, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
 		}
 
 		return handler(srv, ss)
@@ -20,7 +20,7 @@ var _ = Describe("ReadOnly Policy", func() {
 
 	BeforeEach(func() {
 		p := policy.NewReadOnlyPolicy()
-		runtimeClient, imageClient, cleanup = setupTestEnvironment(p)
+		runtimeClient, imageClient, _, cleanup = setupTestEnvironment(withPolicies(p))
 	})
 
 	AfterEach(func() {
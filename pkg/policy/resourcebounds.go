@@ -0,0 +1,486 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/cpuset"
+)
+
+var (
+	ErrInvalidResourceBounds = errors.New("invalid resource bounds")
+	ErrResourcesOutOfBounds  = errors.New("resources out of bounds")
+)
+
+// resourceKind describes how the zero and negative values of a resource are interpreted.
+type resourceKind int
+
+const (
+	// resourceLimit values of zero or less mean unlimited.
+	resourceLimit resourceKind = iota
+	// resourceDefaulted values of zero mean the default of the runtime.
+	resourceDefaulted
+	// resourcePlain values are taken as is.
+	resourcePlain
+)
+
+// resourceField is a bounded field of LinuxContainerResources.
+type resourceField struct {
+	name string
+	kind resourceKind
+	get  func(*runtimeapi.LinuxContainerResources) int64
+}
+
+var resourceFields = []resourceField{
+	{"cpu-period", resourceDefaulted, (*runtimeapi.LinuxContainerResources).GetCpuPeriod},
+	{"cpu-quota", resourceLimit, (*runtimeapi.LinuxContainerResources).GetCpuQuota},
+	{"cpu-shares", resourceDefaulted, (*runtimeapi.LinuxContainerResources).GetCpuShares},
+	{"memory-limit-in-bytes", resourceLimit, (*runtimeapi.LinuxContainerResources).GetMemoryLimitInBytes},
+	{"memory-swap-limit-in-bytes", resourceLimit, (*runtimeapi.LinuxContainerResources).GetMemorySwapLimitInBytes},
+	{"oom-score-adj", resourcePlain, (*runtimeapi.LinuxContainerResources).GetOomScoreAdj},
+}
+
+// hugepageLimit is the name of the bound of the limit of every page size of
+// LinuxContainerResources.HugepageLimits.
+const hugepageLimit = "hugepage-limit-in-bytes"
+
+// ResourceBounds is the configuration of the ResourceBounds policy. Min and
+// Max are keyed by the kebab-case names of the fields of
+// LinuxContainerResources, e.g. memory-limit-in-bytes.
+type ResourceBounds struct {
+	Min map[string]int64 `yaml:"min,omitempty"`
+	Max map[string]int64 `yaml:"max,omitempty"`
+	// MaxRatio is the maximum ratio between the new and the current value of
+	// a resource. Zero means no maximum.
+	MaxRatio float64 `yaml:"max-ratio,omitempty"`
+	// SandboxCeiling caps the resources of containers, and of pod sandboxes,
+	// to the resources declared by their pod sandbox.
+	SandboxCeiling bool `yaml:"sandbox-ceiling,omitempty"`
+	// AllowedCpusetCpus and AllowedCpusetMems are the CPUs and memory nodes,
+	// in the Linux list format, that cpuset_cpus and cpuset_mems can use.
+	AllowedCpusetCpus string `yaml:"allowed-cpuset-cpus,omitempty"`
+	AllowedCpusetMems string `yaml:"allowed-cpuset-mems,omitempty"`
+	// AllowedUnified are the keys of the unified cgroup v2 resources that can
+	// be set, e.g. memory.high. Their values are not bounded.
+	AllowedUnified []string `yaml:"allowed-unified,omitempty"`
+}
+
+// resourceBoundsPolicy bounds the resources set by UpdateContainerResources
+// and UpdatePodSandboxResources. Other methods are passed through, so it is
+// meant to be combined with a policy scoping the pod sandboxes, e.g. PodScoped.
+type resourceBoundsPolicy struct {
+	bounds        ResourceBounds
+	runtimeClient runtimeapi.RuntimeServiceClient
+	cpus          *cpuset.CPUSet
+	mems          *cpuset.CPUSet
+}
+
+// NewResourceBoundsPolicy creates a new ResourceBounds policy.
+func NewResourceBoundsPolicy(bounds ResourceBounds, runtimeClient runtimeapi.RuntimeServiceClient) (Policy, error) {
+	known := map[string]bool{hugepageLimit: true}
+	for _, f := range resourceFields {
+		known[f.name] = true
+	}
+
+	for _, limits := range []map[string]int64{bounds.Min, bounds.Max} {
+		for name := range limits {
+			if !known[name] {
+				return nil, fmt.Errorf("%w: unknown resource %q", ErrInvalidResourceBounds, name)
+			}
+		}
+	}
+
+	for name, maxValue := range bounds.Max {
+		if minValue, ok := bounds.Min[name]; ok && minValue > maxValue {
+			return nil, fmt.Errorf("%w: minimum of %s is greater than its maximum", ErrInvalidResourceBounds, name)
+		}
+	}
+
+	if bounds.MaxRatio < 0 {
+		return nil, fmt.Errorf("%w: max-ratio must not be negative", ErrInvalidResourceBounds)
+	}
+
+	p := &resourceBoundsPolicy{
+		bounds:        bounds,
+		runtimeClient: runtimeClient,
+	}
+
+	for _, allowed := range []struct {
+		name string
+		list string
+		set  **cpuset.CPUSet
+	}{
+		{"allowed-cpuset-cpus", bounds.AllowedCpusetCpus, &p.cpus},
+		{"allowed-cpuset-mems", bounds.AllowedCpusetMems, &p.mems},
+	} {
+		if allowed.list == "" {
+			continue
+		}
+
+		set, err := cpuset.Parse(allowed.list)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidResourceBounds, allowed.name, err)
+		}
+
+		*allowed.set = &set
+	}
+
+	return p, nil
+}
+
+// Name implements the Policy interface.
+func (p *resourceBoundsPolicy) Name() string {
+	return "resourceBounds"
+}
+
+// UnaryInterceptor implements the Policy interface.
+func (p *resourceBoundsPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		interceptor := func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			var (
+				violations []string
+				err        error
+			)
+
+			switch r := req.(type) {
+			case *runtimeapi.UpdateContainerResourcesRequest:
+				violations, err = p.containerViolations(ctx, r)
+			case *runtimeapi.UpdatePodSandboxResourcesRequest:
+				violations, err = p.podSandboxViolations(ctx, r)
+			default:
+				return handler(ctx, req)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			if len(violations) > 0 {
+				klog.FromContext(ctx).V(4).Info("resources out of bounds", "method", info.FullMethod, "violations", violations)
+
+				return nil, newError(codes.PermissionDenied, ReasonResourcesOutOfBounds, nil, "%s: %s", ErrResourcesOutOfBounds, strings.Join(violations, "; "))
+			}
+
+			return handler(ctx, req)
+		}
+
+		return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return loggingInterceptor(ctx, req, info, handler)
+		})
+	}
+}
+
+// StreamInterceptor implements the Policy interface.
+func (p *resourceBoundsPolicy) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		return handler(srv, ss)
+	}
+}
+
+// bounded reports whether any bound is configured. When one is, container
+// updates must set Linux resources and must not set Windows resources, which
+// would otherwise bypass the bounds.
+func (p *resourceBoundsPolicy) bounded() bool {
+	return len(p.bounds.Min) > 0 || len(p.bounds.Max) > 0 || p.bounds.MaxRatio > 0 || p.bounds.SandboxCeiling ||
+		p.cpus != nil || p.mems != nil || len(p.bounds.AllowedUnified) > 0
+}
+
+func (p *resourceBoundsPolicy) containerViolations(ctx context.Context, r *runtimeapi.UpdateContainerResourcesRequest) ([]string, error) {
+	if p.bounded() {
+		var violations []string
+
+		if r.GetWindows() != nil {
+			violations = append(violations, "windows resources are not allowed")
+		}
+
+		if r.GetLinux() == nil {
+			violations = append(violations, "linux resources are required")
+		}
+
+		if len(violations) > 0 {
+			return violations, nil
+		}
+	}
+
+	if r.GetLinux() == nil {
+		return nil, nil
+	}
+
+	var current, ceiling *runtimeapi.LinuxContainerResources
+
+	if p.bounds.MaxRatio > 0 {
+		resp, err := p.runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{
+			ContainerId: r.GetContainerId(),
+		})
+		if err != nil {
+			return nil, newError(codes.Internal, ReasonRuntimeLookupFailed, nil, "failed to get container status: %v", err)
+		}
+
+		current = resp.GetStatus().GetResources().GetLinux()
+		if current == nil {
+			return []string{fmt.Sprintf("current resources of container %s are unknown", r.GetContainerId())}, nil
+		}
+	}
+
+	if p.bounds.SandboxCeiling {
+		podSandboxID, err := getPodSandboxIDFromContainerID(ctx, p.runtimeClient, r.GetContainerId())
+		if err != nil {
+			return nil, newError(codes.Internal, ReasonRuntimeLookupFailed, nil, "failed to get pod sandbox ID from container ID: %v", err)
+		}
+
+		config, err := podSandboxConfig(ctx, p.runtimeClient, podSandboxID)
+		if err != nil {
+			return nil, err
+		}
+
+		ceiling = config.GetLinux().GetResources()
+		if ceiling == nil {
+			return []string{fmt.Sprintf("declared resources of pod sandbox %s are unknown", podSandboxID)}, nil
+		}
+	}
+
+	return p.violations("", r.GetLinux(), current, ceiling), nil
+}
+
+// podSandboxViolations checks the resources of a pod sandbox against the ones
+// it declared, which are both its current resources and its ceiling.
+func (p *resourceBoundsPolicy) podSandboxViolations(ctx context.Context, r *runtimeapi.UpdatePodSandboxResourcesRequest) ([]string, error) {
+	var declared *runtimeapi.LinuxPodSandboxConfig
+
+	if p.bounds.MaxRatio > 0 || p.bounds.SandboxCeiling {
+		config, err := podSandboxConfig(ctx, p.runtimeClient, r.GetPodSandboxId())
+		if err != nil {
+			return nil, err
+		}
+
+		declared = config.GetLinux()
+		if declared == nil {
+			return []string{fmt.Sprintf("declared resources of pod sandbox %s are unknown", r.GetPodSandboxId())}, nil
+		}
+	}
+
+	var current, ceiling *runtimeapi.LinuxPodSandboxConfig
+
+	if p.bounds.MaxRatio > 0 {
+		current = declared
+	}
+
+	if p.bounds.SandboxCeiling {
+		ceiling = declared
+	}
+
+	var violations []string
+
+	if r.GetOverhead() != nil {
+		violations = append(violations, p.violations("overhead ", r.GetOverhead(), current.GetOverhead(), ceiling.GetOverhead())...)
+	}
+
+	if r.GetResources() != nil {
+		violations = append(violations, p.violations("resources ", r.GetResources(), current.GetResources(), ceiling.GetResources())...)
+	}
+
+	return violations, nil
+}
+
+// violations checks the fields of resources against the absolute bounds and,
+// when they are not nil, against the ratio to current and the ceiling.
+func (p *resourceBoundsPolicy) violations(prefix string, resources, current, ceiling *runtimeapi.LinuxContainerResources) []string {
+	var violations []string
+
+	for _, f := range resourceFields {
+		value := f.get(resources)
+		if f.kind == resourceDefaulted && value == 0 {
+			continue
+		}
+
+		unlimited := f.kind == resourceLimit && value <= 0
+
+		name := prefix + f.name
+		if unlimited {
+			name += " unlimited"
+		} else {
+			name += fmt.Sprintf(" %d", value)
+		}
+
+		if minValue, ok := p.bounds.Min[f.name]; ok && !unlimited && value < minValue {
+			violations = append(violations, fmt.Sprintf("%s is below the minimum %d", name, minValue))
+		}
+
+		if maxValue, ok := p.bounds.Max[f.name]; ok && (unlimited || value > maxValue) {
+			violations = append(violations, fmt.Sprintf("%s exceeds the maximum %d", name, maxValue))
+		}
+
+		if f.kind == resourcePlain {
+			continue
+		}
+
+		if c := f.get(current); current != nil && p.bounds.MaxRatio > 0 && c > 0 {
+			if unlimited || float64(value) > float64(c)*p.bounds.MaxRatio {
+				violations = append(violations, fmt.Sprintf("%s exceeds %g times the current %d", name, p.bounds.MaxRatio, c))
+			}
+		}
+
+		if c := f.get(ceiling); ceiling != nil && c > 0 && (unlimited || value > c) {
+			violations = append(violations, fmt.Sprintf("%s exceeds the pod sandbox resources %d", name, c))
+		}
+	}
+
+	violations = append(violations, p.hugepageViolations(prefix, resources, current, ceiling)...)
+	violations = append(violations, p.cpusetViolations(prefix, resources, ceiling)...)
+
+	for _, key := range slices.Sorted(maps.Keys(resources.GetUnified())) {
+		if !slices.Contains(p.bounds.AllowedUnified, key) {
+			violations = append(violations, fmt.Sprintf("%sunified %s is not allowed", prefix, key))
+		}
+	}
+
+	return violations
+}
+
+// hugepageViolations checks the limit of every page size. A page size missing
+// from the resources of the pod sandbox has a ceiling of zero.
+func (p *resourceBoundsPolicy) hugepageViolations(prefix string, resources, current, ceiling *runtimeapi.LinuxContainerResources) []string {
+	var violations []string
+
+	for _, h := range resources.GetHugepageLimits() {
+		limit := hugepageLimitValue(h)
+		name := fmt.Sprintf("%s%s %s %d", prefix, hugepageLimit, h.GetPageSize(), limit)
+
+		if minValue, ok := p.bounds.Min[hugepageLimit]; ok && limit < minValue {
+			violations = append(violations, fmt.Sprintf("%s is below the minimum %d", name, minValue))
+		}
+
+		if maxValue, ok := p.bounds.Max[hugepageLimit]; ok && limit > maxValue {
+			violations = append(violations, fmt.Sprintf("%s exceeds the maximum %d", name, maxValue))
+		}
+
+		if c := pageSizeLimit(current, h.GetPageSize()); current != nil && p.bounds.MaxRatio > 0 && c > 0 {
+			if float64(limit) > float64(c)*p.bounds.MaxRatio {
+				violations = append(violations, fmt.Sprintf("%s exceeds %g times the current %d", name, p.bounds.MaxRatio, c))
+			}
+		}
+
+		if c := pageSizeLimit(ceiling, h.GetPageSize()); ceiling != nil && limit > c {
+			violations = append(violations, fmt.Sprintf("%s exceeds the pod sandbox resources %d", name, c))
+		}
+	}
+
+	return violations
+}
+
+func pageSizeLimit(resources *runtimeapi.LinuxContainerResources, pageSize string) int64 {
+	for _, h := range resources.GetHugepageLimits() {
+		if h.GetPageSize() == pageSize {
+			return hugepageLimitValue(h)
+		}
+	}
+
+	return 0
+}
+
+// hugepageLimitValue returns the limit of a page size as the int64 of the other
+// resources.
+func hugepageLimitValue(h *runtimeapi.HugepageLimit) int64 {
+	//nolint:gosec // The limit is clamped to MaxInt64.
+	return int64(min(h.GetLimit(), math.MaxInt64))
+}
+
+// cpusetViolations checks that the CPUs and memory nodes are allowed by the
+// configuration and within the ones of the pod sandbox. Cpusets are denied
+// when neither bounds them.
+func (p *resourceBoundsPolicy) cpusetViolations(prefix string, resources, ceiling *runtimeapi.LinuxContainerResources) []string {
+	var violations []string
+
+	for _, f := range []struct {
+		name    string
+		list    string
+		allowed *cpuset.CPUSet
+		ceiling string
+	}{
+		{"cpuset-cpus", resources.GetCpusetCpus(), p.cpus, ceiling.GetCpusetCpus()},
+		{"cpuset-mems", resources.GetCpusetMems(), p.mems, ceiling.GetCpusetMems()},
+	} {
+		if f.list == "" {
+			continue
+		}
+
+		name := fmt.Sprintf("%s%s %s", prefix, f.name, f.list)
+
+		set, err := cpuset.Parse(f.list)
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("%s is invalid", name))
+
+			continue
+		}
+
+		if f.allowed == nil && f.ceiling == "" {
+			violations = append(violations, fmt.Sprintf("%s is not allowed", name))
+
+			continue
+		}
+
+		if f.allowed != nil && !set.IsSubsetOf(*f.allowed) {
+			violations = append(violations, fmt.Sprintf("%s is not within the allowed %s", name, f.allowed))
+		}
+
+		if f.ceiling != "" {
+			c, err := cpuset.Parse(f.ceiling)
+			if err != nil || !set.IsSubsetOf(c) {
+				violations = append(violations, fmt.Sprintf("%s is not within the pod sandbox resources %s", name, f.ceiling))
+			}
+		}
+	}
+
+	return violations
+}
+
+// podSandboxConfig returns the config of a pod sandbox, as reported in the
+// verbose info of PodSandboxStatus by runtimes like containerd.
+func podSandboxConfig(ctx context.Context, runtimeClient runtimeapi.RuntimeServiceClient, podSandboxID string) (*runtimeapi.PodSandboxConfig, error) {
+	resp, err := runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{
+		PodSandboxId: podSandboxID,
+		Verbose:      true,
+	})
+	if err != nil {
+		return nil, newError(codes.Internal, ReasonRuntimeLookupFailed, nil, "failed to get pod sandbox status: %v", err)
+	}
+
+	var info struct {
+		Config *runtimeapi.PodSandboxConfig `json:"config"`
+	}
+
+	if data, ok := resp.GetInfo()["info"]; ok {
+		err = json.Unmarshal([]byte(data), &info)
+		if err != nil {
+			klog.FromContext(ctx).V(4).Info("failed to parse pod sandbox info", "podSandboxID", podSandboxID, "err", err)
+		}
+	}
+
+	return info.Config, nil
+}
@@ -0,0 +1,241 @@
+package policy_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/fake"
+	"cri-lite/pkg/policy"
+	"cri-lite/pkg/proxy"
+)
+
+const mib = 1 << 20
+
+var _ = Describe("ResourceBounds Policy", func() {
+	var (
+		runtimeClient runtimeapi.RuntimeServiceClient
+		cleanup       func()
+	)
+
+	BeforeEach(func() {
+		var mock *fake.Server
+
+		runtimeClient, _, mock, cleanup = setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+			p, err := policy.NewResourceBoundsPolicy(policy.ResourceBounds{
+				Min: map[string]int64{
+					"cpu-shares":            2,
+					"memory-limit-in-bytes": 32 * mib,
+					"oom-score-adj":         0,
+				},
+				Max: map[string]int64{
+					"memory-limit-in-bytes": 2048 * mib,
+				},
+				MaxRatio:          2,
+				SandboxCeiling:    true,
+				AllowedCpusetMems: "0",
+				AllowedUnified:    []string{"memory.high"},
+			}, proxyServer.GetRuntimeClient())
+			Expect(err).NotTo(HaveOccurred())
+
+			return []policy.Policy{p}
+		})
+
+		mock.SetPodSandboxes([]*runtimeapi.PodSandbox{{Id: "pod-id"}, {Id: "undeclared-pod-id"}})
+		mock.SetContainers([]*runtimeapi.Container{
+			{Id: "app-id", PodSandboxId: "pod-id"},
+			{Id: "big-id", PodSandboxId: "pod-id"},
+			{Id: "unknown-resources-id", PodSandboxId: "pod-id"},
+			{Id: "undeclared-id", PodSandboxId: "undeclared-pod-id"},
+		})
+		mock.SetContainerResources("app-id", &runtimeapi.LinuxContainerResources{
+			CpuQuota:           50000,
+			MemoryLimitInBytes: 256 * mib,
+			HugepageLimits:     []*runtimeapi.HugepageLimit{{PageSize: "2MB", Limit: 8 * mib}},
+		})
+		mock.SetContainerResources("big-id", &runtimeapi.LinuxContainerResources{MemoryLimitInBytes: 768 * mib})
+		mock.SetContainerResources("undeclared-id", &runtimeapi.LinuxContainerResources{MemoryLimitInBytes: 256 * mib})
+		mock.SetPodSandboxConfig("pod-id", &runtimeapi.PodSandboxConfig{
+			Linux: &runtimeapi.LinuxPodSandboxConfig{
+				Overhead: &runtimeapi.LinuxContainerResources{MemoryLimitInBytes: 64 * mib},
+				Resources: &runtimeapi.LinuxContainerResources{
+					CpuQuota:           200000,
+					MemoryLimitInBytes: 1024 * mib,
+					CpusetCpus:         "0-3",
+					HugepageLimits:     []*runtimeapi.HugepageLimit{{PageSize: "2MB", Limit: 64 * mib}},
+				},
+			},
+		})
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	DescribeTable("UpdateContainerResources",
+		func(containerID string, resources *runtimeapi.LinuxContainerResources, violations ...string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.UpdateContainerResources(ctx, &runtimeapi.UpdateContainerResourcesRequest{
+				ContainerId: containerID,
+				Linux:       resources,
+			})
+			if len(violations) == 0 {
+				Expect(err).NotTo(HaveOccurred())
+
+				return
+			}
+
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(err.Error()).To(ContainSubstring(policy.ErrResourcesOutOfBounds.Error()))
+
+			for _, v := range violations {
+				Expect(err.Error()).To(ContainSubstring(v))
+			}
+		},
+		Entry("within bounds", "app-id", &runtimeapi.LinuxContainerResources{CpuQuota: 100000, MemoryLimitInBytes: 512 * mib}),
+		Entry("above the ratio to the current resources", "app-id",
+			&runtimeapi.LinuxContainerResources{MemoryLimitInBytes: 600 * mib},
+			"memory-limit-in-bytes 629145600 exceeds 2 times the current 268435456"),
+		Entry("unlimited", "app-id",
+			&runtimeapi.LinuxContainerResources{CpuQuota: -1, MemoryLimitInBytes: 256 * mib},
+			"cpu-quota unlimited exceeds 2 times the current 50000", "cpu-quota unlimited exceeds the pod sandbox resources 200000"),
+		Entry("below the minimum", "app-id",
+			&runtimeapi.LinuxContainerResources{CpuShares: 1, MemoryLimitInBytes: 16 * mib, OomScoreAdj: -1000},
+			"cpu-shares 1 is below the minimum 2", "memory-limit-in-bytes 16777216 is below the minimum 33554432", "oom-score-adj -1000 is below the minimum 0"),
+		Entry("above the pod sandbox resources", "big-id",
+			&runtimeapi.LinuxContainerResources{MemoryLimitInBytes: 1200 * mib},
+			"memory-limit-in-bytes 1258291200 exceeds the pod sandbox resources 1073741824"),
+		Entry("cpusets", "app-id",
+			&runtimeapi.LinuxContainerResources{CpuQuota: 50000, MemoryLimitInBytes: 256 * mib, CpusetCpus: "2-3", CpusetMems: "0"}),
+		Entry("cpusets out of bounds", "app-id",
+			&runtimeapi.LinuxContainerResources{MemoryLimitInBytes: 256 * mib, CpusetCpus: "2-5", CpusetMems: "0-1"},
+			"cpuset-cpus 2-5 is not within the pod sandbox resources 0-3", "cpuset-mems 0-1 is not within the allowed 0"),
+		Entry("cpusets of an undeclared pod sandbox", "undeclared-id",
+			&runtimeapi.LinuxContainerResources{CpusetCpus: "0"},
+			"declared resources of pod sandbox undeclared-pod-id are unknown"),
+		Entry("hugepages", "app-id",
+			&runtimeapi.LinuxContainerResources{
+				CpuQuota:           50000,
+				MemoryLimitInBytes: 256 * mib,
+				HugepageLimits:     []*runtimeapi.HugepageLimit{{PageSize: "2MB", Limit: 16 * mib}},
+			}),
+		Entry("hugepages out of bounds", "app-id",
+			&runtimeapi.LinuxContainerResources{
+				MemoryLimitInBytes: 256 * mib,
+				HugepageLimits: []*runtimeapi.HugepageLimit{
+					{PageSize: "2MB", Limit: 32 * mib},
+					{PageSize: "1GB", Limit: 1024 * mib},
+				},
+			},
+			"hugepage-limit-in-bytes 2MB 33554432 exceeds 2 times the current 8388608",
+			"hugepage-limit-in-bytes 1GB 1073741824 exceeds the pod sandbox resources 0"),
+		Entry("allowed unified resources", "app-id",
+			&runtimeapi.LinuxContainerResources{CpuQuota: 50000, MemoryLimitInBytes: 256 * mib, Unified: map[string]string{"memory.high": "200M"}}),
+		Entry("unified resources", "app-id",
+			&runtimeapi.LinuxContainerResources{MemoryLimitInBytes: 256 * mib, Unified: map[string]string{"memory.max": "max", "cpu.max": "max"}},
+			"unified cpu.max is not allowed", "unified memory.max is not allowed"),
+		Entry("unknown current resources", "unknown-resources-id",
+			&runtimeapi.LinuxContainerResources{MemoryLimitInBytes: 64 * mib},
+			"current resources of container unknown-resources-id are unknown"),
+		Entry("undeclared pod sandbox resources", "undeclared-id",
+			&runtimeapi.LinuxContainerResources{MemoryLimitInBytes: 64 * mib},
+			"declared resources of pod sandbox undeclared-pod-id are unknown"),
+	)
+
+	DescribeTable("UpdateContainerResources without Linux resources",
+		func(windows *runtimeapi.WindowsContainerResources, violations ...string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.UpdateContainerResources(ctx, &runtimeapi.UpdateContainerResourcesRequest{
+				ContainerId: "app-id",
+				Windows:     windows,
+			})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(err.Error()).To(ContainSubstring(policy.ErrResourcesOutOfBounds.Error()))
+
+			for _, v := range violations {
+				Expect(err.Error()).To(ContainSubstring(v))
+			}
+		},
+		Entry("no resources", nil, "linux resources are required"),
+		Entry("windows resources", &runtimeapi.WindowsContainerResources{MemoryLimitInBytes: 64 * mib},
+			"windows resources are not allowed", "linux resources are required"),
+	)
+
+	It("should deny Windows resources alongside Linux resources", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := runtimeClient.UpdateContainerResources(ctx, &runtimeapi.UpdateContainerResourcesRequest{
+			ContainerId: "app-id",
+			Linux:       &runtimeapi.LinuxContainerResources{CpuQuota: 50000, MemoryLimitInBytes: 256 * mib},
+			Windows:     &runtimeapi.WindowsContainerResources{MemoryLimitInBytes: 4096 * mib},
+		})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		Expect(err.Error()).To(ContainSubstring("windows resources are not allowed"))
+	})
+
+	DescribeTable("UpdatePodSandboxResources",
+		func(podSandboxID string, overhead, resources *runtimeapi.LinuxContainerResources, violations ...string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			_, err := runtimeClient.UpdatePodSandboxResources(ctx, &runtimeapi.UpdatePodSandboxResourcesRequest{
+				PodSandboxId: podSandboxID,
+				Overhead:     overhead,
+				Resources:    resources,
+			})
+			if len(violations) == 0 {
+				Expect(err).NotTo(HaveOccurred())
+
+				return
+			}
+
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			for _, v := range violations {
+				Expect(err.Error()).To(ContainSubstring(v))
+			}
+		},
+		Entry("within bounds", "pod-id",
+			&runtimeapi.LinuxContainerResources{MemoryLimitInBytes: 48 * mib},
+			&runtimeapi.LinuxContainerResources{CpuQuota: 100000, MemoryLimitInBytes: 512 * mib}),
+		Entry("out of bounds", "pod-id",
+			&runtimeapi.LinuxContainerResources{MemoryLimitInBytes: 256 * mib},
+			&runtimeapi.LinuxContainerResources{MemoryLimitInBytes: 3072 * mib},
+			"overhead memory-limit-in-bytes 268435456 exceeds 2 times the current 67108864",
+			"resources memory-limit-in-bytes 3221225472 exceeds the maximum 2147483648"),
+		Entry("above the declared resources", "pod-id",
+			nil,
+			&runtimeapi.LinuxContainerResources{CpuQuota: 300000, MemoryLimitInBytes: 1024 * mib},
+			"resources cpu-quota 300000 exceeds the pod sandbox resources 200000"),
+		Entry("undeclared pod sandbox resources", "undeclared-pod-id",
+			nil,
+			&runtimeapi.LinuxContainerResources{MemoryLimitInBytes: 64 * mib},
+			"declared resources of pod sandbox undeclared-pod-id are unknown"),
+	)
+})
+
+var _ = Describe("NewResourceBoundsPolicy", func() {
+	DescribeTable("should reject invalid bounds",
+		func(bounds policy.ResourceBounds) {
+			_, err := policy.NewResourceBoundsPolicy(bounds, nil)
+			Expect(err).To(MatchError(policy.ErrInvalidResourceBounds))
+		},
+		Entry("unknown resource", policy.ResourceBounds{Max: map[string]int64{"memory": 1}}),
+		Entry("minimum above maximum", policy.ResourceBounds{
+			Min: map[string]int64{"cpu-shares": 4},
+			Max: map[string]int64{"cpu-shares": 2},
+		}),
+		Entry("negative ratio", policy.ResourceBounds{MaxRatio: -1}),
+		Entry("invalid cpuset", policy.ResourceBounds{AllowedCpusetCpus: "0-"}),
+	)
+})
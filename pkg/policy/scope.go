@@ -0,0 +1,533 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"maps"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+)
+
+// methodScope classifies how a CRI method relates to pod sandboxes, which
+// decides how scoped policies enforce it.
+type methodScope int
+
+const (
+	// scopeDenied methods are never allowed for scoped callers.
+	scopeDenied methodScope = iota
+	// scopePodSandbox methods operate on the pod sandbox in the PodSandboxId field of the request.
+	scopePodSandbox
+	// scopeContainer methods operate on the container in the ContainerId field of the request.
+	scopeContainer
+	// scopeList methods return resources of many pod sandboxes and have their responses filtered.
+	scopeList
+	// scopeNode methods read information about the node rather than about pod sandboxes.
+	scopeNode
+)
+
+// methodScopes classifies every CRI method. Methods missing from the map,
+// e.g. ones added to the CRI API later, are denied.
+var methodScopes = map[string]methodScope{
+	"/runtime.v1.RuntimeService/Version":                  scopeNode,
+	"/runtime.v1.RuntimeService/RunPodSandbox":            scopeDenied,
+	"/runtime.v1.RuntimeService/StopPodSandbox":           scopePodSandbox,
+	"/runtime.v1.RuntimeService/RemovePodSandbox":         scopePodSandbox,
+	"/runtime.v1.RuntimeService/PodSandboxStatus":         scopePodSandbox,
+	"/runtime.v1.RuntimeService/ListPodSandbox":           scopeList,
+	"/runtime.v1.RuntimeService/CreateContainer":          scopePodSandbox,
+	"/runtime.v1.RuntimeService/StartContainer":           scopeContainer,
+	"/runtime.v1.RuntimeService/StopContainer":            scopeContainer,
+	"/runtime.v1.RuntimeService/RemoveContainer":          scopeContainer,
+	"/runtime.v1.RuntimeService/ListContainers":           scopeList,
+	"/runtime.v1.RuntimeService/ContainerStatus":          scopeContainer,
+	"/runtime.v1.RuntimeService/UpdateContainerResources": scopeContainer,
+	"/runtime.v1.RuntimeService/ReopenContainerLog":       scopeContainer,
+	"/runtime.v1.RuntimeService/ExecSync":                 scopeContainer,
+	"/runtime.v1.RuntimeService/Exec":                     scopeContainer,
+	"/runtime.v1.RuntimeService/Attach":                   scopeContainer,
+	"/runtime.v1.RuntimeService/PortForward":              scopePodSandbox,
+	"/runtime.v1.RuntimeService/ContainerStats":           scopeContainer,
+	"/runtime.v1.RuntimeService/ListContainerStats":       scopeList,
+	"/runtime.v1.RuntimeService/PodSandboxStats":          scopePodSandbox,
+	"/runtime.v1.RuntimeService/ListPodSandboxStats":      scopeList,
+	"/runtime.v1.RuntimeService/UpdateRuntimeConfig":      scopeDenied,
+	"/runtime.v1.RuntimeService/Status":                   scopeNode,
+	// CheckpointContainer writes the checkpoint archive of a container to a
+	// location of the node chosen by the caller.
+	"/runtime.v1.RuntimeService/CheckpointContainer":       scopeDenied,
+	"/runtime.v1.RuntimeService/GetContainerEvents":        scopeList,
+	"/runtime.v1.RuntimeService/ListMetricDescriptors":     scopeNode,
+	"/runtime.v1.RuntimeService/ListPodSandboxMetrics":     scopeList,
+	"/runtime.v1.RuntimeService/RuntimeConfig":             scopeNode,
+	"/runtime.v1.RuntimeService/UpdatePodSandboxResources": scopePodSandbox,
+	// ImageFsInfo is used by crictl for CRI connectivity checks.
+	"/runtime.v1.ImageService/ImageFsInfo": scopeNode,
+}
+
+// labelMatcher reports whether a pod sandbox or container with the given
+// labels is in scope. Containers are matched by containerLabelsMatch.
+type labelMatcher func(labels map[string]string) bool
+
+// sandboxScopedPolicy restricts RuntimeService calls to the pod sandboxes and
+// containers whose labels are accepted by the matcher of the caller.
+type sandboxScopedPolicy struct {
+	name          string
+	runtimeClient runtimeapi.RuntimeServiceClient
+	// matcher returns the labelMatcher of the caller of a request.
+	matcher func(ctx context.Context) (labelMatcher, error)
+}
+
+// Name implements the Policy interface.
+func (p *sandboxScopedPolicy) Name() string {
+	return p.name
+}
+
+// UnaryInterceptor implements the Policy interface.
+func (p *sandboxScopedPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		interceptor := func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			scope, ok := methodScopes[info.FullMethod]
+			if !ok || scope == scopeDenied {
+				return nil, newError(codes.PermissionDenied, ReasonMethodNotAllowed, nil, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
+			}
+
+			if scope == scopeNode {
+				return handler(ctx, req)
+			}
+
+			matches, err := p.matcher(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			e := &scopeEvaluation{
+				runtimeClient: p.runtimeClient,
+				matches:       matches,
+			}
+
+			err = e.verifyRequest(ctx, scope, req)
+			if err != nil {
+				return nil, err
+			}
+
+			resp, err := handler(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+
+			if scope == scopeList {
+				err = e.filterResponse(ctx, resp)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			return resp, nil
+		}
+
+		return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return loggingInterceptor(ctx, req, info, handler)
+		})
+	}
+}
+
+// StreamInterceptor implements the Policy interface.
+func (p *sandboxScopedPolicy) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if methodScopes[info.FullMethod] != scopeList {
+			return newError(codes.PermissionDenied, ReasonMethodNotAllowed, nil, "%s: %s", ErrMethodNotAllowed, info.FullMethod)
+		}
+
+		matches, err := p.matcher(ss.Context())
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &scopedStream{
+			ServerStream: ss,
+			evaluation: &scopeEvaluation{
+				runtimeClient: p.runtimeClient,
+				matches:       matches,
+			},
+		})
+	}
+}
+
+// maxScopeCacheEntries bounds the pod sandboxes and containers remembered by a
+// scopeEvaluation, which lives as long as a stream.
+const maxScopeCacheEntries = 1024
+
+// scopeEvaluation checks the resources referenced by a request or the messages
+// of a stream. Single pod sandboxes and containers are looked up by ID, and
+// those of the node are listed at most once to filter a list response. The
+// labels of pod sandboxes and containers never change, so what was looked up
+// is remembered.
+type scopeEvaluation struct {
+	runtimeClient    runtimeapi.RuntimeServiceClient
+	matches          labelMatcher
+	podLabels        map[string]map[string]string
+	podsListed       bool
+	containers       map[string]*runtimeapi.Container
+	containersListed bool
+}
+
+func (e *scopeEvaluation) verifyRequest(ctx context.Context, scope methodScope, req interface{}) error {
+	switch scope {
+	case scopePodSandbox:
+		r, ok := req.(interface{ GetPodSandboxId() string })
+		if !ok {
+			return newError(codes.Internal, ReasonEvaluationFailed, nil, "request %T has no pod sandbox ID", req)
+		}
+
+		inScope, err := e.podSandboxInScope(ctx, r.GetPodSandboxId())
+		if err != nil {
+			return err
+		}
+
+		if !inScope {
+			return newError(codes.PermissionDenied, ReasonMethodNotAllowed, map[string]string{MetadataPodSandboxID: r.GetPodSandboxId()}, "%s: pod sandbox %s is out of scope", ErrMethodNotAllowed, r.GetPodSandboxId())
+		}
+	case scopeContainer:
+		r, ok := req.(interface{ GetContainerId() string })
+		if !ok {
+			return newError(codes.Internal, ReasonEvaluationFailed, nil, "request %T has no container ID", req)
+		}
+
+		inScope, err := e.containerInScope(ctx, r.GetContainerId())
+		if err != nil {
+			return err
+		}
+
+		if !inScope {
+			return newError(codes.PermissionDenied, ReasonMethodNotAllowed, map[string]string{MetadataContainerID: r.GetContainerId()}, "%s: container %s is out of scope", ErrMethodNotAllowed, r.GetContainerId())
+		}
+	case scopeDenied, scopeList, scopeNode:
+	}
+
+	return nil
+}
+
+// filterResponse removes the resources that are out of scope from a list
+// response. The pod sandboxes, and containers, of the node are listed once
+// rather than looked up one by one.
+func (e *scopeEvaluation) filterResponse(ctx context.Context, resp interface{}) error {
+	var err error
+
+	switch resp.(type) {
+	case *runtimeapi.ListContainerStatsResponse:
+		err = e.listContainers(ctx)
+		if err == nil {
+			err = e.listPodSandboxes(ctx)
+		}
+	case *runtimeapi.ListContainersResponse, *runtimeapi.ListPodSandboxMetricsResponse:
+		err = e.listPodSandboxes(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	switch r := resp.(type) {
+	case *runtimeapi.ListContainersResponse:
+		r.Containers, err = filterInScope(r.GetContainers(), func(c *runtimeapi.Container) (bool, error) {
+			return e.containerLabelsInScope(ctx, c.GetPodSandboxId(), c.GetLabels())
+		})
+	case *runtimeapi.ListContainerStatsResponse:
+		r.Stats, err = filterInScope(r.GetStats(), func(s *runtimeapi.ContainerStats) (bool, error) {
+			return e.containerInScope(ctx, s.GetAttributes().GetId())
+		})
+	case *runtimeapi.ListPodSandboxResponse:
+		r.Items, err = filterInScope(r.GetItems(), func(s *runtimeapi.PodSandbox) (bool, error) {
+			return e.matches(s.GetLabels()), nil
+		})
+	case *runtimeapi.ListPodSandboxStatsResponse:
+		r.Stats, err = filterInScope(r.GetStats(), func(s *runtimeapi.PodSandboxStats) (bool, error) {
+			return e.matches(s.GetAttributes().GetLabels()), nil
+		})
+	case *runtimeapi.ListPodSandboxMetricsResponse:
+		r.PodMetrics, err = filterInScope(r.GetPodMetrics(), func(m *runtimeapi.PodSandboxMetrics) (bool, error) {
+			return e.podSandboxInScope(ctx, m.GetPodSandboxId())
+		})
+	default:
+		return newError(codes.Internal, ReasonEvaluationFailed, nil, "cannot filter response of type %T", resp)
+	}
+
+	return err
+}
+
+func (e *scopeEvaluation) podSandboxInScope(ctx context.Context, podSandboxID string) (bool, error) {
+	labels, ok, err := e.podSandboxLabels(ctx, podSandboxID)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return e.matches(labels), nil
+}
+
+func (e *scopeEvaluation) podSandboxLabels(ctx context.Context, podSandboxID string) (map[string]string, bool, error) {
+	labels, ok := e.podLabels[podSandboxID]
+	if ok || e.podsListed {
+		if !ok {
+			klog.FromContext(ctx).V(4).Info("pod sandbox not found", "podSandboxID", podSandboxID)
+		}
+
+		return labels, ok, nil
+	}
+
+	resp, err := e.runtimeClient.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{
+		Filter: &runtimeapi.PodSandboxFilter{Id: podSandboxID},
+	})
+	if err != nil {
+		return nil, false, newError(codes.Internal, ReasonRuntimeLookupFailed, map[string]string{MetadataPodSandboxID: podSandboxID}, "failed to get pod sandbox: %v", err)
+	}
+
+	// The filter is not trusted, as runtimes may ignore it.
+	for _, s := range resp.GetItems() {
+		if s.GetId() == podSandboxID {
+			if len(e.podLabels) >= maxScopeCacheEntries {
+				clear(e.podLabels)
+			}
+
+			e.cachePodSandbox(s)
+
+			return s.GetLabels(), true, nil
+		}
+	}
+
+	klog.FromContext(ctx).V(4).Info("pod sandbox not found", "podSandboxID", podSandboxID)
+
+	return nil, false, nil
+}
+
+// listPodSandboxes remembers the labels of all the pod sandboxes of the node.
+func (e *scopeEvaluation) listPodSandboxes(ctx context.Context) error {
+	if e.podsListed {
+		return nil
+	}
+
+	resp, err := e.runtimeClient.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{})
+	if err != nil {
+		return newError(codes.Internal, ReasonRuntimeLookupFailed, nil, "failed to list pod sandboxes: %v", err)
+	}
+
+	for _, s := range resp.GetItems() {
+		e.cachePodSandbox(s)
+	}
+
+	e.podsListed = true
+
+	return nil
+}
+
+func (e *scopeEvaluation) cachePodSandbox(s *runtimeapi.PodSandbox) {
+	if e.podLabels == nil {
+		e.podLabels = map[string]map[string]string{}
+	}
+
+	e.podLabels[s.GetId()] = s.GetLabels()
+}
+
+func (e *scopeEvaluation) containerInScope(ctx context.Context, containerID string) (bool, error) {
+	c, err := e.container(ctx, containerID)
+	if err != nil || c == nil {
+		return false, err
+	}
+
+	return e.containerLabelsInScope(ctx, c.GetPodSandboxId(), c.GetLabels())
+}
+
+// container returns the container with the given ID, or nil if there is none.
+func (e *scopeEvaluation) container(ctx context.Context, containerID string) (*runtimeapi.Container, error) {
+	c, ok := e.containers[containerID]
+	if ok || e.containersListed {
+		if !ok {
+			klog.FromContext(ctx).V(4).Info("container not found", "containerID", containerID)
+		}
+
+		return c, nil
+	}
+
+	resp, err := e.runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{
+		Filter: &runtimeapi.ContainerFilter{Id: containerID},
+	})
+	if err != nil {
+		return nil, newError(codes.Internal, ReasonRuntimeLookupFailed, map[string]string{MetadataContainerID: containerID}, "failed to get container: %v", err)
+	}
+
+	// The filter is not trusted, as runtimes may ignore it.
+	for _, c := range resp.GetContainers() {
+		if c.GetId() == containerID {
+			if len(e.containers) >= maxScopeCacheEntries {
+				clear(e.containers)
+			}
+
+			e.cacheContainer(c)
+
+			return c, nil
+		}
+	}
+
+	klog.FromContext(ctx).V(4).Info("container not found", "containerID", containerID)
+
+	return nil, nil
+}
+
+// listContainers remembers all the containers of the node.
+func (e *scopeEvaluation) listContainers(ctx context.Context) error {
+	if e.containersListed {
+		return nil
+	}
+
+	resp, err := e.runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+	if err != nil {
+		return newError(codes.Internal, ReasonRuntimeLookupFailed, nil, "failed to list containers: %v", err)
+	}
+
+	for _, c := range resp.GetContainers() {
+		e.cacheContainer(c)
+	}
+
+	e.containersListed = true
+
+	return nil
+}
+
+func (e *scopeEvaluation) cacheContainer(c *runtimeapi.Container) {
+	if e.containers == nil {
+		e.containers = map[string]*runtimeapi.Container{}
+	}
+
+	e.containers[c.GetId()] = c
+}
+
+// containerLabelsInScope matches a container with the labels of its pod
+// sandbox. Containers of unknown pod sandboxes are out of scope.
+func (e *scopeEvaluation) containerLabelsInScope(ctx context.Context, podSandboxID string, labels map[string]string) (bool, error) {
+	podLabels, ok, err := e.podSandboxLabels(ctx, podSandboxID)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return containerLabelsMatch(e.matches, podLabels, labels), nil
+}
+
+// containerLabelsMatch reports whether a container is in scope. Its pod
+// sandbox must match, and so must its own labels merged over the ones of its
+// pod sandbox: the labels of a container can narrow its scope but not widen it.
+func containerLabelsMatch(matches labelMatcher, podLabels, labels map[string]string) bool {
+	return matches(podLabels) && matches(mergeLabels(podLabels, labels))
+}
+
+// mergeLabels returns the union of the labels, with the ones of override
+// taking precedence.
+func mergeLabels(base, override map[string]string) map[string]string {
+	if len(override) == 0 {
+		return base
+	}
+
+	merged := make(map[string]string, len(base)+len(override))
+	maps.Copy(merged, base)
+	maps.Copy(merged, override)
+
+	return merged
+}
+
+// filterInScope returns the items for which inScope is true.
+func filterInScope[T any](items []T, inScope func(T) (bool, error)) ([]T, error) {
+	var filtered []T
+
+	for _, item := range items {
+		ok, err := inScope(item)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			filtered = append(filtered, item)
+		}
+	}
+
+	return filtered, nil
+}
+
+// scopedStream drops the container events of pod sandboxes that are out of
+// scope. The containers looked up are remembered for the lifetime of the stream.
+type scopedStream struct {
+	grpc.ServerStream
+
+	evaluation *scopeEvaluation
+}
+
+func (s *scopedStream) SendMsg(m interface{}) error {
+	event, ok := m.(*runtimeapi.ContainerEventResponse)
+	if !ok {
+		return newError(codes.Internal, ReasonEvaluationFailed, nil, "cannot filter message of type %T", m)
+	}
+
+	inScope := false
+
+	if event.GetPodSandboxStatus() != nil {
+		var labels map[string]string
+
+		for _, c := range event.GetContainersStatuses() {
+			if c.GetId() == event.GetContainerId() {
+				labels = c.GetLabels()
+			}
+		}
+
+		inScope = containerLabelsMatch(s.evaluation.matches, event.GetPodSandboxStatus().GetLabels(), labels)
+	} else {
+		var err error
+
+		inScope, err = s.evaluation.containerInScope(s.Context(), event.GetContainerId())
+		if err != nil {
+			return err
+		}
+	}
+
+	if !inScope {
+		return nil
+	}
+
+	return s.ServerStream.SendMsg(m)
+}
+
+// podSandboxLabelsFromPID returns the labels of the pod sandbox of the caller.
+func podSandboxLabelsFromPID(ctx context.Context, runtimeClient runtimeapi.RuntimeServiceClient) (map[string]string, error) {
+	pid, err := callerPID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	podSandboxID, err := podSandboxIDFromPID(ctx, runtimeClient, pid)
+	if err != nil {
+		return nil, pidResolutionError(err)
+	}
+
+	resp, err := runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{
+		PodSandboxId: podSandboxID,
+	})
+	if err != nil {
+		return nil, newError(codes.Internal, ReasonRuntimeLookupFailed, nil, "failed to get pod sandbox status: %v", err)
+	}
+
+	return resp.GetStatus().GetLabels(), nil
+}
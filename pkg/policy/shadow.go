@@ -0,0 +1,362 @@
+// Package policy provides interfaces and implementations for enforcing CRI API access policies.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"k8s.io/klog/v2"
+)
+
+// Divergences between the active and the shadow policies.
+const (
+	DivergenceDeniedByShadow  = "allowed by active, denied by shadow"
+	DivergenceAllowedByShadow = "denied by active, allowed by shadow"
+	DivergenceRequest         = "different requests"
+	DivergenceResponse        = "different responses"
+	DivergenceMessage         = "different messages"
+)
+
+// errShadowEvaluation is returned to the shadow policies by the handler of
+// the calls denied by the active policies.
+var errShadowEvaluation = errors.New("call denied by the active policies")
+
+// shadowKey identifies a kind of divergence of the shadow policies.
+type shadowKey struct {
+	method     string
+	divergence string
+}
+
+// shadowPolicy enforces the active policies and evaluates the shadow policies
+// alongside them. The shadow policies never reach the runtime: the handler of
+// the calls changing state returns an empty response, and the handler of the
+// other calls returns a copy of the response the active policies got, so
+// they only see the responses of the calls allowed by the active policies.
+// Every divergence is logged.
+type shadowPolicy struct {
+	active []Policy
+	shadow []Policy
+
+	mu          sync.Mutex
+	divergences map[shadowKey]int
+}
+
+// NewShadowPolicy enforces the active policies, like the server does, and
+// records where the shadow policies would have decided differently.
+func NewShadowPolicy(active, shadow []Policy) Policy {
+	return &shadowPolicy{
+		active:      active,
+		shadow:      shadow,
+		divergences: make(map[shadowKey]int),
+	}
+}
+
+// Name implements the Policy interface.
+func (p *shadowPolicy) Name() string {
+	return fmt.Sprintf("%s (shadow: %s)", policyNames(p.active), policyNames(p.shadow))
+}
+
+// UnaryInterceptor implements the Policy interface.
+func (p *shadowPolicy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	active := chainUnaryInterceptors(p.active)
+	shadow := chainUnaryInterceptors(p.shadow)
+
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		var (
+			activeReq, shadowReq interface{}
+			upstream             interface{}
+			upstreamErr          error
+			shadowResp           interface{}
+			shadowErr            error
+			activeCalled         bool
+			shadowCalled         bool
+		)
+
+		// The shadow policies evaluate the calls changing state before the
+		// active policies, against a handler returning an empty response, so
+		// that they do not look up the state the call changed, like a removed
+		// container. They evaluate the other calls after the active policies,
+		// against a copy of the response the active policies got.
+		mutating := !readMethods[info.FullMethod]
+		originalReq := cloneMessage(req)
+
+		evaluateShadow := func() {
+			shadowResp, shadowErr = shadow(ctx, originalReq, info, func(_ context.Context, req interface{}) (interface{}, error) {
+				shadowCalled = true
+				shadowReq = req
+
+				switch {
+				case mutating:
+					return emptyResponse(info.FullMethod), nil
+				case !activeCalled:
+					return nil, errShadowEvaluation
+				default:
+					return upstream, upstreamErr
+				}
+			})
+		}
+
+		if mutating {
+			evaluateShadow()
+		}
+
+		resp, err := active(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			activeCalled = true
+			activeReq = cloneMessage(req)
+
+			resp, err := handler(ctx, req)
+			upstream, upstreamErr = cloneMessage(resp), err
+
+			return resp, err
+		})
+
+		if !mutating {
+			evaluateShadow()
+		}
+
+		handlerFailed := !activeCalled || upstreamErr != nil
+		activeAllowed := activeCalled && (err == nil || upstreamErr != nil)
+		shadowAllowed := shadowCalled && (shadowErr == nil || (!mutating && handlerFailed))
+
+		switch {
+		case activeAllowed && !shadowAllowed:
+			p.diverge(ctx, info.FullMethod, DivergenceDeniedByShadow, "shadowError", shadowErr)
+		case !activeAllowed && shadowAllowed:
+			p.diverge(ctx, info.FullMethod, DivergenceAllowedByShadow, "activeError", err)
+		case activeAllowed && shadowAllowed:
+			if !messagesEqual(activeReq, shadowReq) {
+				p.diverge(ctx, info.FullMethod, DivergenceRequest)
+			}
+
+			if !mutating && !handlerFailed && !messagesEqual(resp, shadowResp) {
+				p.diverge(ctx, info.FullMethod, DivergenceResponse)
+			}
+		}
+
+		return resp, err
+	}
+}
+
+// emptyResponse returns an empty response of a method, or nil if the method
+// is unknown.
+func emptyResponse(fullMethod string) interface{} {
+	md, err := methodDescriptor(fullMethod)
+	if err != nil {
+		return nil
+	}
+
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(md.Output().FullName())
+	if err != nil {
+		return nil
+	}
+
+	return mt.New().Interface()
+}
+
+// StreamInterceptor implements the Policy interface. The shadow policies see
+// copies of the messages of the stream, and the messages they would send are
+// discarded.
+func (p *shadowPolicy) StreamInterceptor() grpc.StreamServerInterceptor {
+	active := chainStreamInterceptors(p.active)
+	shadow := chainStreamInterceptors(p.shadow)
+
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		var activeCalled, shadowCalled bool
+
+		sent := &sentStream{ServerStream: ss}
+		sink := &auditSink{ServerStream: ss}
+
+		err := active(srv, sent, info, func(srv interface{}, activeStream grpc.ServerStream) error {
+			activeCalled = true
+
+			var handlerErr error
+
+			shadowErr := shadow(srv, sink, info, func(srv interface{}, shadowStream grpc.ServerStream) error {
+				shadowCalled = true
+				handlerErr = handler(srv, &shadowedStream{
+					ServerStream: activeStream,
+					shadow:       shadowStream,
+					sent:         sent,
+					sink:         sink,
+					policy:       p,
+					method:       info.FullMethod,
+				})
+
+				return handlerErr
+			})
+
+			switch {
+			case !shadowCalled:
+				p.diverge(ss.Context(), info.FullMethod, DivergenceDeniedByShadow, "shadowError", shadowErr)
+
+				return handler(srv, activeStream)
+			case handlerErr == nil && shadowErr != nil:
+				p.diverge(ss.Context(), info.FullMethod, DivergenceDeniedByShadow, "shadowError", shadowErr)
+			}
+
+			return handlerErr
+		})
+
+		if !activeCalled {
+			_ = shadow(srv, sink, info, func(interface{}, grpc.ServerStream) error {
+				shadowCalled = true
+
+				return errShadowEvaluation
+			})
+
+			if shadowCalled {
+				p.diverge(ss.Context(), info.FullMethod, DivergenceAllowedByShadow, "activeError", err)
+			}
+		}
+
+		return err
+	}
+}
+
+// diverge logs and counts a divergence of the shadow policies.
+func (p *shadowPolicy) diverge(ctx context.Context, method, divergence string, keysAndValues ...interface{}) {
+	p.mu.Lock()
+	key := shadowKey{method: method, divergence: divergence}
+	p.divergences[key]++
+	count := p.divergences[key]
+	p.mu.Unlock()
+
+	values := []interface{}{"method", method, "divergence", divergence, "count", count}
+
+	if authInfo, err := callerCredentials(ctx); err == nil {
+		values = append(values, "pid", authInfo.GetPID(), "uid", authInfo.GetUID())
+	}
+
+	klog.FromContext(ctx).Info("shadow policy diverges from active policy", append(values, keysAndValues...)...)
+}
+
+// shadowedStream passes the messages of a stream through the streams of the
+// active policies, and copies of them through the streams of the shadow
+// policies.
+type shadowedStream struct {
+	grpc.ServerStream
+
+	shadow grpc.ServerStream
+	sent   *sentStream
+	sink   *auditSink
+	policy *shadowPolicy
+	method string
+}
+
+func (s *shadowedStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+
+	s.sink.received = m
+
+	shadowErr := s.shadow.RecvMsg(cloneMessage(m))
+	if shadowErr != nil {
+		s.policy.diverge(s.Context(), s.method, DivergenceDeniedByShadow, "shadowError", shadowErr)
+	}
+
+	return nil
+}
+
+func (s *shadowedStream) SendMsg(m interface{}) error {
+	s.sent.sent = false
+	s.sink.sent = false
+
+	shadowErr := s.shadow.SendMsg(cloneMessage(m))
+
+	err := s.ServerStream.SendMsg(m)
+	if err == nil && s.sent.sent != (shadowErr == nil && s.sink.sent) {
+		s.policy.diverge(s.Context(), s.method, DivergenceMessage, "sentByActive", s.sent.sent, "shadowError", shadowErr)
+	}
+
+	return err
+}
+
+// sentStream records whether the active policies sent a message.
+type sentStream struct {
+	grpc.ServerStream
+
+	sent bool
+}
+
+func (s *sentStream) SendMsg(m interface{}) error {
+	s.sent = true
+
+	return s.ServerStream.SendMsg(m)
+}
+
+// chainUnaryInterceptors chains the unary interceptors of policies like the
+// server does, so that the first policy sees the request first and its errors
+// name it.
+func chainUnaryInterceptors(policies []Policy) grpc.UnaryServerInterceptor {
+	interceptors := make([]grpc.UnaryServerInterceptor, 0, len(policies))
+	for _, p := range policies {
+		interceptors = append(interceptors, namedUnaryInterceptor(p))
+	}
+
+	var chain func(i int, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) grpc.UnaryHandler
+
+	chain = func(i int, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) grpc.UnaryHandler {
+		if i == len(interceptors) {
+			return handler
+		}
+
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			return interceptors[i](ctx, req, info, chain(i+1, info, handler))
+		}
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return chain(0, info, handler)(ctx, req)
+	}
+}
+
+// chainStreamInterceptors chains the stream interceptors of policies like the
+// server does.
+func chainStreamInterceptors(policies []Policy) grpc.StreamServerInterceptor {
+	interceptors := make([]grpc.StreamServerInterceptor, 0, len(policies))
+	for _, p := range policies {
+		interceptors = append(interceptors, namedStreamInterceptor(p))
+	}
+
+	var chain func(i int, info *grpc.StreamServerInfo, handler grpc.StreamHandler) grpc.StreamHandler
+
+	chain = func(i int, info *grpc.StreamServerInfo, handler grpc.StreamHandler) grpc.StreamHandler {
+		if i == len(interceptors) {
+			return handler
+		}
+
+		return func(srv interface{}, ss grpc.ServerStream) error {
+			return interceptors[i](srv, ss, info, chain(i+1, info, handler))
+		}
+	}
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return chain(0, info, handler)(srv, ss)
+	}
+}
+
+func policyNames(policies []Policy) string {
+	names := make([]string, 0, len(policies))
+	for _, p := range policies {
+		names = append(names, p.Name())
+	}
+
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,156 @@
+package policy_test
+
+import (
+	"context"
+	"io"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"cri-lite/pkg/fake"
+	"cri-lite/pkg/policy"
+	"cri-lite/pkg/proxy"
+)
+
+var _ = Describe("Shadow Policy", func() {
+	var (
+		runtimeClient runtimeapi.RuntimeServiceClient
+		imageClient   runtimeapi.ImageServiceClient
+		cleanup       func()
+		p             policy.Policy
+	)
+
+	BeforeEach(func() {
+		var mock *fake.Server
+
+		runtimeClient, imageClient, mock, cleanup = setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+			p = policy.NewShadowPolicy(
+				[]policy.Policy{policy.NewReadOnlyPolicy()},
+				[]policy.Policy{policy.NewPodScopedPolicy("own-pod", false, proxyServer.GetRuntimeClient())},
+			)
+
+			return []policy.Policy{p}
+		})
+
+		mock.SetContainers([]*runtimeapi.Container{
+			{Id: "own-container", PodSandboxId: "own-pod"},
+			{Id: "other-container", PodSandboxId: "other-pod"},
+		})
+		mock.SetEmittedEvents([]*runtimeapi.ContainerEventResponse{
+			{ContainerId: "own-container"},
+			{ContainerId: "other-container"},
+		})
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("should enforce the active policies and record the calls the shadow policies decide differently", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		By("calling a method denied by the shadow policies")
+		_, err := imageClient.ListImages(ctx, &runtimeapi.ListImagesRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy.ShadowDivergences(p, "/runtime.v1.ImageService/ListImages", policy.DivergenceDeniedByShadow)).To(Equal(1))
+
+		By("calling a method allowed by the shadow policies only")
+		// The fake runtime does not implement StopContainer, so this also
+		// checks that the shadow policies do not reach the runtime.
+		_, err = runtimeClient.StopContainer(ctx, &runtimeapi.StopContainerRequest{ContainerId: "own-container"})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		Expect(policy.ShadowDivergences(p, "/runtime.v1.RuntimeService/StopContainer", policy.DivergenceAllowedByShadow)).To(Equal(1))
+
+		By("calling a method allowed by both")
+		_, err = runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy.ShadowDivergences(p, "/runtime.v1.RuntimeService/Version", policy.DivergenceDeniedByShadow)).To(BeZero())
+	})
+
+	It("should record the responses the shadow policies filter differently", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		resp, err := runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.GetContainers()).To(HaveLen(2))
+		Expect(policy.ShadowDivergences(p, "/runtime.v1.RuntimeService/ListContainers", policy.DivergenceRequest)).To(Equal(1))
+		Expect(policy.ShadowDivergences(p, "/runtime.v1.RuntimeService/ListContainers", policy.DivergenceResponse)).To(Equal(1))
+
+		stream, err := runtimeClient.GetContainerEvents(ctx, &runtimeapi.GetEventsRequest{})
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, id := range []string{"own-container", "other-container"} {
+			event, err := stream.Recv()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(event.GetContainerId()).To(Equal(id))
+		}
+
+		_, err = stream.Recv()
+		Expect(err).To(MatchError(io.EOF))
+		Expect(policy.ShadowDivergences(p, "/runtime.v1.RuntimeService/GetContainerEvents", policy.DivergenceMessage)).To(Equal(1))
+	})
+})
+
+var _ = Describe("Shadow Policy of a call changing state", func() {
+	var (
+		runtimeClient runtimeapi.RuntimeServiceClient
+		mock          *fake.Server
+		cleanup       func()
+		p             policy.Policy
+	)
+
+	BeforeEach(func() {
+		runtimeClient, _, mock, cleanup = setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+			p = policy.NewShadowPolicy(
+				[]policy.Policy{policy.NewPodScopedPolicy("own-pod", false, proxyServer.GetRuntimeClient())},
+				[]policy.Policy{policy.NewPodScopedPolicy("own-pod", false, proxyServer.GetRuntimeClient())},
+			)
+
+			return []policy.Policy{p}
+		})
+
+		mock.SetContainers([]*runtimeapi.Container{{Id: "own-container", PodSandboxId: "own-pod"}})
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("should evaluate the shadow policies before the call removes the container", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := runtimeClient.RemoveContainer(ctx, &runtimeapi.RemoveContainerRequest{ContainerId: "own-container"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy.ShadowDivergences(p, "/runtime.v1.RuntimeService/RemoveContainer", policy.DivergenceDeniedByShadow)).To(BeZero())
+		Expect(mock.RemoveContainerCalls()).To(BeEquivalentTo(1))
+	})
+
+	It("should not call the runtime for the calls allowed by the shadow policies only", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		runtimeClient, _, mock, cleanup := setupTestEnvironment(func(proxyServer *proxy.Server) []policy.Policy {
+			p = policy.NewShadowPolicy(
+				[]policy.Policy{policy.NewReadOnlyPolicy()},
+				[]policy.Policy{policy.NewPodScopedPolicy("own-pod", false, proxyServer.GetRuntimeClient())},
+			)
+
+			return []policy.Policy{p}
+		})
+		DeferCleanup(cleanup)
+
+		mock.SetContainers([]*runtimeapi.Container{{Id: "own-container", PodSandboxId: "own-pod"}})
+
+		_, err := runtimeClient.RemoveContainer(ctx, &runtimeapi.RemoveContainerRequest{ContainerId: "own-container"})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		Expect(policy.ShadowDivergences(p, "/runtime.v1.RuntimeService/RemoveContainer", policy.DivergenceAllowedByShadow)).To(Equal(1))
+		Expect(mock.RemoveContainerCalls()).To(BeZero())
+	})
+})
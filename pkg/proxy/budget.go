@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UpstreamBudget limits the calls made to the runtime and image services. It
+// is shared by the servers of all the endpoints, so that together they stay
+// within the budget, and each server uses its own share of it, so that the
+// callers of one endpoint cannot starve the others. Calls over the budget
+// wait for it until their deadline.
+type UpstreamBudget struct {
+	// limiter is nil if the rate is not limited.
+	limiter *rate.Limiter
+	// inFlight is nil if the calls in flight are not limited.
+	inFlight chan struct{}
+	// shared is the budget that the share is taken from, nil if the budget
+	// is not a share.
+	shared *UpstreamBudget
+}
+
+// NewUpstreamBudget creates a budget of callsPerSecond, with bursts of burst
+// calls, and of maxInFlight unary calls in flight. Zero means no limit.
+// Streams only count against the rate.
+func NewUpstreamBudget(callsPerSecond float64, burst, maxInFlight int) *UpstreamBudget {
+	budget := &UpstreamBudget{}
+
+	if callsPerSecond > 0 {
+		budget.limiter = rate.NewLimiter(rate.Limit(callsPerSecond), max(burst, 1))
+	}
+
+	if maxInFlight > 0 {
+		budget.inFlight = make(chan struct{}, maxInFlight)
+	}
+
+	return budget
+}
+
+// Share returns an even share of the budget between endpoints. Calls count
+// against both the share and the budget, so that an endpoint cannot use more
+// than its share, while the endpoints together stay within the budget. The
+// bursts and the calls in flight of a share are rounded up.
+func (b *UpstreamBudget) Share(endpoints int) *UpstreamBudget {
+	endpoints = max(endpoints, 1)
+	share := &UpstreamBudget{shared: b}
+
+	if b.limiter != nil {
+		burst := (b.limiter.Burst() + endpoints - 1) / endpoints
+		share.limiter = rate.NewLimiter(b.limiter.Limit()/rate.Limit(endpoints), burst)
+	}
+
+	if b.inFlight != nil {
+		share.inFlight = make(chan struct{}, (cap(b.inFlight)+endpoints-1)/endpoints)
+	}
+
+	return share
+}
+
+// SetUpstreamBudget limits the calls that the server makes to the runtime and
+// image services to budget, which other servers can share. It must be called
+// before the server is started.
+func (s *Server) SetUpstreamBudget(budget *UpstreamBudget) {
+	s.upstream = budget
+}
+
+// acquire waits for the budget of a call, and then for the budget that it is
+// a share of. The returned function releases them.
+func (b *UpstreamBudget) acquire(ctx context.Context, stream bool) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+
+	release, err := b.acquireOwn(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	releaseShared, err := b.shared.acquire(ctx, stream)
+	if err != nil {
+		release()
+
+		return nil, err
+	}
+
+	return func() {
+		releaseShared()
+		release()
+	}, nil
+}
+
+// acquireOwn waits for the budget of a call, without the budget that it is a
+// share of.
+func (b *UpstreamBudget) acquireOwn(ctx context.Context, stream bool) (func(), error) {
+	if b.limiter != nil {
+		err := b.limiter.Wait(ctx)
+		if err != nil {
+			return nil, status.Errorf(codes.ResourceExhausted, "upstream rate budget exhausted: %v", err)
+		}
+	}
+
+	if b.inFlight == nil || stream {
+		return func() {}, nil
+	}
+
+	select {
+	case b.inFlight <- struct{}{}:
+		return func() { <-b.inFlight }, nil
+	case <-ctx.Done():
+		return nil, status.Errorf(codes.ResourceExhausted, "upstream in-flight budget exhausted: %v", ctx.Err())
+	}
+}
+
+func (s *Server) upstreamUnaryInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	release, err := s.upstream.acquire(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func (s *Server) upstreamStreamInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	_, err := s.upstream.acquire(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return streamer(ctx, desc, cc, method, opts...)
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strings"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
@@ -16,6 +17,7 @@ import (
 
 	"cri-lite/pkg/creds"
 	"cri-lite/pkg/policy"
+	"cri-lite/pkg/streaming"
 	"cri-lite/pkg/version"
 )
 
@@ -46,8 +48,11 @@ type Server struct {
 
 	runtimeClient runtimeapi.RuntimeServiceClient
 	imageClient   runtimeapi.ImageServiceClient
-	policy        policy.Policy
+	policies      []policy.Policy
 	grpcServer    *grpc.Server
+	upstream      *UpstreamBudget
+	// streaming is nil if the URLs of the runtime are handed out as is.
+	streaming *streaming.Server
 }
 
 // NewServer creates a new cri-lite proxy server.
@@ -62,6 +67,8 @@ func NewServer(runtimeEndpoint, imageEndpoint string) (*Server, error) {
 		grpc.WithDisableRetry(),
 		grpc.WithDefaultServiceConfig(`{"retryPolicy":null}`), // disables transparent retries
 		grpc.WithUserAgent("cri-lite/"+version.Version),
+		grpc.WithUnaryInterceptor(s.upstreamUnaryInterceptor),
+		grpc.WithStreamInterceptor(s.upstreamStreamInterceptor),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to runtime endpoint: %w", err)
@@ -71,7 +78,14 @@ func NewServer(runtimeEndpoint, imageEndpoint string) (*Server, error) {
 
 	klog.Infof("Connecting to image endpoint %s", imageEndpoint)
 
-	imageConn, err := grpc.NewClient(imageEndpoint, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithDisableRetry(), grpc.WithUserAgent("cri-lite/"+version.Version))
+	imageConn, err := grpc.NewClient(
+		imageEndpoint,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDisableRetry(),
+		grpc.WithUserAgent("cri-lite/"+version.Version),
+		grpc.WithUnaryInterceptor(s.upstreamUnaryInterceptor),
+		grpc.WithStreamInterceptor(s.upstreamStreamInterceptor),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to image endpoint: %w", err)
 	}
@@ -133,6 +147,13 @@ func (s *Server) Exec(ctx context.Context, req *runtimeapi.ExecRequest) (*runtim
 		return nil, fmt.Errorf("failed to exec in container: %w", err)
 	}
 
+	resp.Url, err = s.streamingURL(ctx, streaming.Session{Method: streaming.MethodExec, RuntimeURL: resp.GetUrl(), TTY: req.GetTty(), Stdin: req.GetStdin()})
+	if err != nil {
+		logger.Error(err, "failed to hand out streaming URL")
+
+		return nil, err
+	}
+
 	return resp, nil
 }
 
@@ -263,6 +284,13 @@ func (s *Server) PortForward(ctx context.Context, req *runtimeapi.PortForwardReq
 		return nil, fmt.Errorf("failed to port forward: %w", err)
 	}
 
+	resp.Url, err = s.streamingURL(ctx, streaming.Session{Method: streaming.MethodPortForward, RuntimeURL: resp.GetUrl(), Ports: req.GetPort()})
+	if err != nil {
+		logger.Error(err, "failed to hand out streaming URL")
+
+		return nil, err
+	}
+
 	return resp, nil
 }
 
@@ -427,7 +455,14 @@ func (s *Server) SetImageClient(client runtimeapi.ImageServiceClient) {
 
 // SetPolicy sets the policy enforced by the server.
 func (s *Server) SetPolicy(p policy.Policy) {
-	s.policy = p
+	s.SetPolicies(p)
+}
+
+// SetPolicies sets the policies enforced by the server. A request is only
+// allowed if every policy allows it, and the response passes through the
+// filters of every policy.
+func (s *Server) SetPolicies(policies ...policy.Policy) {
+	s.policies = policies
 }
 
 // Start starts the gRPC server on the specified socket.
@@ -444,21 +479,22 @@ func (s *Server) Start(socketPath string) error {
 		return fmt.Errorf("failed to listen on socket: %w", err)
 	}
 
-	var (
-		unaryInterceptors  grpc.UnaryServerInterceptor
-		streamInterceptors grpc.StreamServerInterceptor
-	)
+	// The policies are chained in order, so the first policy sees the
+	// request first and the response last.
+	unaryInterceptors := make([]grpc.UnaryServerInterceptor, 0, len(s.policies))
+	streamInterceptors := make([]grpc.StreamServerInterceptor, 0, len(s.policies))
 
-	if s.policy != nil {
-		klog.Infof("Using policy %s", s.policy.Name())
-		unaryInterceptors = s.policy.UnaryInterceptor()
-		streamInterceptors = s.policy.StreamInterceptor()
+	for _, p := range s.policies {
+		klog.Infof("Using policy %s", p.Name())
+		unary, stream := policy.Interceptors(p)
+		unaryInterceptors = append(unaryInterceptors, unary)
+		streamInterceptors = append(streamInterceptors, stream)
 	}
 
 	s.grpcServer = grpc.NewServer(
 		grpc.Creds(creds.NewPIDCreds()),
-		grpc.UnaryInterceptor(unaryInterceptors),
-		grpc.StreamInterceptor(streamInterceptors),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
 	)
 
 	runtimeapi.RegisterRuntimeServiceServer(s.grpcServer, s)
@@ -585,6 +621,13 @@ func (s *Server) Attach(ctx context.Context, req *runtimeapi.AttachRequest) (*ru
 		return nil, fmt.Errorf("failed to attach to container: %w", err)
 	}
 
+	resp.Url, err = s.streamingURL(ctx, streaming.Session{Method: streaming.MethodAttach, RuntimeURL: resp.GetUrl(), TTY: req.GetTty(), Stdin: req.GetStdin()})
+	if err != nil {
+		logger.Error(err, "failed to hand out streaming URL")
+
+		return nil, err
+	}
+
 	return resp, nil
 }
 
@@ -645,9 +688,10 @@ func (s *Server) PullImage(ctx context.Context, req *runtimeapi.PullImageRequest
 }
 
 func (s *Server) policyNames() string {
-	if s.policy == nil {
-		return ""
+	names := make([]string, 0, len(s.policies))
+	for _, p := range s.policies {
+		names = append(names, p.Name())
 	}
 
-	return s.policy.Name()
+	return strings.Join(names, ", ")
 }
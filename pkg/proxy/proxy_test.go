@@ -13,14 +13,17 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 
 	"cri-lite/pkg/fake"
 	"cri-lite/pkg/policy"
 	"cri-lite/pkg/proxy"
+	"cri-lite/pkg/streaming"
 	"cri-lite/pkg/version"
 )
 
@@ -39,6 +42,10 @@ func (s *fakeRuntimeService) Version(ctx context.Context, req *runtimeapi.Versio
 	}, nil
 }
 
+func (s *fakeRuntimeService) Exec(ctx context.Context, req *runtimeapi.ExecRequest) (*runtimeapi.ExecResponse, error) {
+	return &runtimeapi.ExecResponse{Url: "http://127.0.0.1:34567/exec/runtime-token"}, nil
+}
+
 func (s *fakeRuntimeService) GetContainerEvents(req *runtimeapi.GetEventsRequest, stream runtimeapi.RuntimeService_GetContainerEventsServer) error {
 	events := []*runtimeapi.ContainerEventResponse{
 		{ContainerId: "container1", ContainerEventType: runtimeapi.ContainerEventType_CONTAINER_CREATED_EVENT},
@@ -457,3 +464,166 @@ func TestGetContainerEvents(t *testing.T) {
 		t.Errorf("expected EOF, got %v", err)
 	}
 }
+
+func TestUpstreamBudget(t *testing.T) {
+	t.Parallel()
+
+	fakeRuntimeSocket := t.TempDir() + "/fake-runtime.sock"
+
+	fakeServer, lis, _, err := fake.NewServer(fakeRuntimeSocket)
+	if err != nil {
+		t.Fatalf("Failed to create fake server: %v", err)
+	}
+
+	go func() {
+		if err := fakeServer.Serve(lis); err != nil {
+			t.Logf("Fake server exited: %v", err)
+		}
+	}()
+
+	defer fakeServer.Stop()
+
+	proxyServer, err := proxy.NewServer("unix://"+fakeRuntimeSocket, "unix://"+fakeRuntimeSocket)
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+
+	otherServer, err := proxy.NewServer("unix://"+fakeRuntimeSocket, "unix://"+fakeRuntimeSocket)
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+
+	budget := proxy.NewUpstreamBudget(0.001, 1, 0)
+	proxyServer.SetUpstreamBudget(budget)
+	otherServer.SetUpstreamBudget(budget)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = proxyServer.GetRuntimeClient().Version(ctx, &runtimeapi.VersionRequest{})
+	if err != nil {
+		t.Fatalf("Version failed: %v", err)
+	}
+
+	// The next token is far beyond the deadline of the call, so it fails
+	// without waiting.
+	_, err = proxyServer.GetImageClient().ListImages(ctx, &runtimeapi.ListImagesRequest{})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted, got %v", err)
+	}
+
+	// The budget is shared with the other server.
+	_, err = otherServer.GetRuntimeClient().Version(ctx, &runtimeapi.VersionRequest{})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted from the other server, got %v", err)
+	}
+}
+
+func TestUpstreamBudgetShare(t *testing.T) {
+	t.Parallel()
+
+	fakeRuntimeSocket := t.TempDir() + "/fake-runtime.sock"
+
+	fakeServer, lis, _, err := fake.NewServer(fakeRuntimeSocket)
+	if err != nil {
+		t.Fatalf("Failed to create fake server: %v", err)
+	}
+
+	go func() {
+		if err := fakeServer.Serve(lis); err != nil {
+			t.Logf("Fake server exited: %v", err)
+		}
+	}()
+
+	defer fakeServer.Stop()
+
+	proxyServer, err := proxy.NewServer("unix://"+fakeRuntimeSocket, "unix://"+fakeRuntimeSocket)
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+
+	otherServer, err := proxy.NewServer("unix://"+fakeRuntimeSocket, "unix://"+fakeRuntimeSocket)
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+
+	budget := proxy.NewUpstreamBudget(0.002, 2, 0)
+	proxyServer.SetUpstreamBudget(budget.Share(2))
+	otherServer.SetUpstreamBudget(budget.Share(2))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = proxyServer.GetRuntimeClient().Version(ctx, &runtimeapi.VersionRequest{})
+	if err != nil {
+		t.Fatalf("Version failed: %v", err)
+	}
+
+	// The server has used its share of the budget.
+	_, err = proxyServer.GetRuntimeClient().Version(ctx, &runtimeapi.VersionRequest{})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted, got %v", err)
+	}
+
+	// The share of the other server is left.
+	_, err = otherServer.GetRuntimeClient().Version(ctx, &runtimeapi.VersionRequest{})
+	if err != nil {
+		t.Errorf("Version of the other server failed: %v", err)
+	}
+}
+
+func TestExecStreamingURL(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	lis := bufconn.Listen(bufSize)
+	s := grpc.NewServer()
+	runtimeapi.RegisterRuntimeServiceServer(s, &fakeRuntimeService{})
+
+	go func() {
+		err := s.Serve(lis)
+		if err != nil {
+			t.Errorf("Server exited with error: %v", err)
+		}
+	}()
+
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	proxyServer := &proxy.Server{}
+	proxyServer.SetRuntimeClient(runtimeapi.NewRuntimeServiceClient(conn))
+
+	// Without a streaming server, callers get the URL of the runtime.
+	resp, err := proxyServer.Exec(ctx, &runtimeapi.ExecRequest{ContainerId: "container1"})
+	if err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+
+	if resp.GetUrl() != "http://127.0.0.1:34567/exec/runtime-token" {
+		t.Errorf("expected the URL of the runtime, got %s", resp.GetUrl())
+	}
+
+	streamingServer, err := streaming.NewServer(streaming.Config{Address: "127.0.0.1:10350", BaseURL: "http://node:10350/cri-lite"})
+	if err != nil {
+		t.Fatalf("Failed to create streaming server: %v", err)
+	}
+
+	proxyServer.SetStreamingServer(streamingServer)
+
+	resp, err = proxyServer.Exec(ctx, &runtimeapi.ExecRequest{ContainerId: "container1"})
+	if err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+
+	if !strings.HasPrefix(resp.GetUrl(), "http://node:10350/cri-lite/exec/") {
+		t.Errorf("expected a URL of the streaming server, got %s", resp.GetUrl())
+	}
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/peer"
+
+	"cri-lite/pkg/streaming"
+)
+
+// SetStreamingServer makes the server hand out the URLs of a streaming server
+// for Exec, Attach and PortForward, rather than the URLs of the runtime. It
+// must be called before the server is started.
+func (s *Server) SetStreamingServer(streamingServer *streaming.Server) {
+	s.streaming = streamingServer
+}
+
+// streamingURL returns the URL that the caller opens a stream of the runtime
+// with.
+func (s *Server) streamingURL(ctx context.Context, session streaming.Session) (string, error) {
+	if s.streaming == nil {
+		return session.RuntimeURL, nil
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if authInfo, ok := p.AuthInfo.(interface{ GetUID() uint32 }); ok {
+			session.UID = authInfo.GetUID()
+			session.HasUID = true
+		}
+	}
+
+	url, err := s.streaming.Register(session)
+	if err != nil {
+		return "", fmt.Errorf("failed to register streaming session: %w", err)
+	}
+
+	return url, nil
+}
@@ -0,0 +1,384 @@
+// Package streaming provides the streaming server of cri-lite, which proxies
+// the Exec, Attach and PortForward streams of the callers to the runtime.
+package streaming
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"cri-lite/pkg/creds"
+)
+
+var (
+	// ErrInvalidConfig is returned when the configuration of the streaming
+	// server is invalid.
+	ErrInvalidConfig = errors.New("invalid streaming configuration")
+	// ErrInvalidRuntimeURL is returned when the runtime returns a streaming
+	// URL that cannot be proxied.
+	ErrInvalidRuntimeURL = errors.New("invalid runtime streaming URL")
+	// ErrSessionNotAllowed is returned when a caller opens a session that the
+	// configuration or its identity does not allow.
+	ErrSessionNotAllowed = errors.New("streaming session not allowed")
+)
+
+// Methods of the streaming sessions, as they appear in the URLs handed out
+// to the callers.
+const (
+	MethodExec        = "exec"
+	MethodAttach      = "attach"
+	MethodPortForward = "portforward"
+)
+
+// DefaultTokenTTL is how long the URLs handed out to callers can be used
+// when the configuration does not say.
+const DefaultTokenTTL = 30 * time.Second
+
+// tokenBytes is the number of random bytes of a token.
+const tokenBytes = 32
+
+// Config is the configuration of a streaming server.
+type Config struct {
+	// Address is a host:port to listen on, or the absolute path of a unix
+	// socket. Over TCP, the sessions are not bound to the UID of the caller,
+	// so anyone holding a URL can open its stream.
+	Address string
+	// BaseURL is the URL that callers reach the server at, e.g. through a
+	// relay. It defaults to http://Address for TCP addresses, and is required
+	// for unix sockets.
+	BaseURL string
+	// TokenTTL is how long a URL can be used after it was handed out.
+	TokenTTL time.Duration
+	// MaxSessionDuration ends the streams that last longer. Zero means no
+	// limit.
+	MaxSessionDuration time.Duration
+	// AllowTTY and AllowStdin allow Exec and Attach sessions that allocate a
+	// TTY or stream the standard input.
+	AllowTTY   bool
+	AllowStdin bool
+}
+
+// Session is a stream prepared by the runtime for a caller.
+type Session struct {
+	// Method is one of MethodExec, MethodAttach and MethodPortForward.
+	Method string
+	// RuntimeURL is the URL of the stream on the streaming server of the
+	// runtime.
+	RuntimeURL string
+	TTY        bool
+	Stdin      bool
+	// UID is the UID of the caller. When the stream is opened over a unix
+	// socket, it must be opened by a process with the same UID.
+	UID    uint32
+	HasUID bool
+	// Ports are the ports of a PortForward session, as checked by the
+	// policies. When set, the stream can only forward them: it must be a
+	// WebSocket stream whose port query parameters are all among them, as
+	// SPDY streams name the port of every forwarded connection later on.
+	Ports []int32
+
+	runtimeURL *url.URL
+	expiry     time.Time
+}
+
+// Server hands out short-lived URLs for the streams of the runtime, and
+// proxies the streams opened with them to the runtime after checking them.
+// The URLs of the runtime usually point at a loopback listener the callers
+// cannot reach, and bypass cri-lite once reached.
+type Server struct {
+	config     Config
+	baseURL    *url.URL
+	httpServer *http.Server
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewServer creates a new streaming server.
+func NewServer(config Config) (*Server, error) {
+	if config.Address == "" {
+		return nil, fmt.Errorf("%w: address must be set", ErrInvalidConfig)
+	}
+
+	unixSocket := filepath.IsAbs(config.Address)
+
+	if config.BaseURL == "" {
+		if unixSocket {
+			return nil, fmt.Errorf("%w: url must be set for unix socket %s", ErrInvalidConfig, config.Address)
+		}
+
+		config.BaseURL = "http://" + config.Address
+	}
+
+	baseURL, err := url.Parse(config.BaseURL)
+	if err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+		return nil, fmt.Errorf("%w: url %q must be an absolute http or https URL", ErrInvalidConfig, config.BaseURL)
+	}
+
+	if config.TokenTTL < 0 || config.MaxSessionDuration < 0 {
+		return nil, fmt.Errorf("%w: durations must not be negative", ErrInvalidConfig)
+	}
+
+	if config.TokenTTL == 0 {
+		config.TokenTTL = DefaultTokenTTL
+	}
+
+	s := &Server{
+		config:   config,
+		baseURL:  baseURL,
+		sessions: make(map[string]*Session),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path.Join("/", baseURL.Path, "{method}", "{token}"), s.serveStream)
+
+	s.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		// The connection is kept in the context of the requests, so that
+		// the caller of streams opened over unix sockets can be identified.
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			return context.WithValue(ctx, connKey{}, conn)
+		},
+	}
+
+	return s, nil
+}
+
+// connKey is the context key of the connection of a request.
+type connKey struct{}
+
+// Register records a session prepared by the runtime, and returns the URL
+// the caller opens it with.
+func (s *Server) Register(session Session) (string, error) {
+	runtimeURL, err := url.Parse(session.RuntimeURL)
+	if err != nil || (runtimeURL.Scheme != "http" && runtimeURL.Scheme != "https") || runtimeURL.Host == "" {
+		return "", fmt.Errorf("%w: %q", ErrInvalidRuntimeURL, session.RuntimeURL)
+	}
+
+	b := make([]byte, tokenBytes)
+
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	session.runtimeURL = runtimeURL
+	session.expiry = time.Now().Add(s.config.TokenTTL)
+
+	s.mu.Lock()
+	s.removeExpiredLocked()
+	s.sessions[token] = &session
+	s.mu.Unlock()
+
+	return s.baseURL.JoinPath(session.Method, token).String(), nil
+}
+
+// Start serves the streams on the address of the server.
+func (s *Server) Start() error {
+	network := "tcp"
+
+	if filepath.IsAbs(s.config.Address) {
+		network = "unix"
+
+		err := os.Remove(s.config.Address)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove existing socket: %w", err)
+		}
+	}
+
+	lis, err := (&net.ListenConfig{}).Listen(context.Background(), network, s.config.Address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.Address, err)
+	}
+
+	return s.Serve(lis)
+}
+
+// Serve serves the streams on a listener.
+func (s *Server) Serve(lis net.Listener) error {
+	klog.Infof("Starting streaming server on %s, reachable at %s", lis.Addr(), s.baseURL)
+
+	if _, ok := lis.(*net.UnixListener); !ok {
+		klog.Warningf("Streams opened on %s are not bound to the UID of the caller", lis.Addr())
+	}
+
+	err := s.httpServer.Serve(lis)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return fmt.Errorf("failed to serve streaming server: %w", err)
+}
+
+// Stop stops the streaming server and closes the streams.
+func (s *Server) Stop() {
+	_ = s.httpServer.Close()
+}
+
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request) {
+	logger := klog.FromContext(r.Context()).WithValues("method", r.PathValue("method"))
+
+	session, ok := s.take(r.PathValue("token"))
+	if !ok || session.Method != r.PathValue("method") {
+		http.Error(w, "unknown or expired session", http.StatusNotFound)
+
+		return
+	}
+
+	err := s.verifySession(r, session)
+	if err != nil {
+		logger.Info("streaming session denied", "err", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+
+		return
+	}
+
+	ctx := r.Context()
+
+	if s.config.MaxSessionDuration > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, s.config.MaxSessionDuration)
+		defer cancel()
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = session.runtimeURL.Scheme
+			pr.Out.URL.Host = session.runtimeURL.Host
+			pr.Out.URL.Path = session.runtimeURL.Path
+			pr.Out.URL.RawPath = session.runtimeURL.RawPath
+			pr.Out.URL.RawQuery = session.runtimeURL.RawQuery
+			pr.Out.Host = session.runtimeURL.Host
+
+			// The ports of a PortForward stream over WebSocket are the only
+			// parameters of the caller passed on, once checked.
+			if ports := pr.In.URL.Query()["port"]; session.Method == MethodPortForward && len(ports) > 0 {
+				query := pr.Out.URL.Query()
+				query["port"] = ports
+				pr.Out.URL.RawQuery = query.Encode()
+			}
+		},
+		ErrorHandler: func(w http.ResponseWriter, _ *http.Request, err error) {
+			logger.Error(err, "failed to proxy stream to the runtime")
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	logger.V(4).Info("proxying stream", "runtimeURL", session.runtimeURL.Redacted())
+	proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// take removes the session of a token and returns it, unless it expired.
+// Tokens can only be used once.
+func (s *Server) take(token string) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok {
+		return nil, false
+	}
+
+	delete(s.sessions, token)
+
+	return session, time.Now().Before(session.expiry)
+}
+
+func (s *Server) removeExpiredLocked() {
+	now := time.Now()
+
+	for token, session := range s.sessions {
+		if !now.Before(session.expiry) {
+			delete(s.sessions, token)
+		}
+	}
+}
+
+// verifySession checks a session against the configuration of the server
+// and the caller opening it.
+func (s *Server) verifySession(r *http.Request, session *Session) error {
+	if session.Method != MethodPortForward {
+		if session.TTY && !s.config.AllowTTY {
+			return fmt.Errorf("%w: TTY is not allowed", ErrSessionNotAllowed)
+		}
+
+		if session.Stdin && !s.config.AllowStdin {
+			return fmt.Errorf("%w: stdin is not allowed", ErrSessionNotAllowed)
+		}
+	}
+
+	if session.Method == MethodPortForward && len(session.Ports) > 0 {
+		err := verifyPorts(r, session.Ports)
+		if err != nil {
+			return err
+		}
+	}
+
+	conn, ok := r.Context().Value(connKey{}).(net.Conn)
+	if !ok || !session.HasUID {
+		return nil
+	}
+
+	uid, ok, err := creds.PeerUID(conn)
+	if err != nil {
+		return fmt.Errorf("failed to get peer credentials: %w", err)
+	}
+
+	if ok && uid != session.UID {
+		return fmt.Errorf("%w: session of UID %d cannot be opened by UID %d", ErrSessionNotAllowed, session.UID, uid)
+	}
+
+	return nil
+}
+
+// verifyPorts checks that a PortForward stream only forwards the ports of its
+// session. Only WebSocket streams tell their ports when opened, in their port
+// query parameters, so SPDY streams are refused, including the ones tunneled
+// over WebSocket.
+func verifyPorts(r *http.Request, allowed []int32) error {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return fmt.Errorf("%w: port forwarding is only allowed over WebSocket", ErrSessionNotAllowed)
+	}
+
+	for _, protocol := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(protocol, ",") {
+			if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(p)), "SPDY/") {
+				return fmt.Errorf("%w: port forwarding is not allowed over SPDY", ErrSessionNotAllowed)
+			}
+		}
+	}
+
+	ports := r.URL.Query()["port"]
+	if len(ports) == 0 {
+		return fmt.Errorf("%w: no ports requested", ErrSessionNotAllowed)
+	}
+
+	for _, value := range ports {
+		port, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || !slices.Contains(allowed, int32(port)) {
+			return fmt.Errorf("%w: port %q is not allowed", ErrSessionNotAllowed, value)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,407 @@
+package streaming_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cri-lite/pkg/streaming"
+)
+
+// newRuntime starts a fake streaming server of a runtime, which upgrades the
+// connections of the streams and echoes them. The query it received is sent
+// back in the X-Query header.
+func newRuntime(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	runtime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/exec/runtime-token" && r.URL.Path != "/portforward/runtime-token" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Failed to hijack connection: %v", err)
+
+			return
+		}
+
+		defer conn.Close()
+
+		_, _ = fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\nX-Query: %s\r\n\r\n",
+			r.Header.Get("Upgrade"), r.URL.RawQuery)
+		_ = buf.Flush()
+
+		_, _ = io.Copy(conn, buf)
+	}))
+	t.Cleanup(runtime.Close)
+
+	return runtime
+}
+
+// startServer starts a streaming server on lis.
+func startServer(t *testing.T, config streaming.Config, lis net.Listener) *streaming.Server {
+	t.Helper()
+
+	s, err := streaming.NewServer(config)
+	if err != nil {
+		t.Fatalf("Failed to create streaming server: %v", err)
+	}
+
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			t.Errorf("Streaming server exited: %v", err)
+		}
+	}()
+
+	t.Cleanup(s.Stop)
+
+	return s
+}
+
+// openStream opens the SPDY stream of a URL over conn, and returns the status
+// code of the response.
+func openStream(t *testing.T, conn net.Conn, streamURL string) (*bufio.Reader, int) {
+	t.Helper()
+
+	reader, resp := upgrade(t, conn, streamURL, "SPDY/3.1")
+
+	return reader, resp.StatusCode
+}
+
+// upgrade upgrades the connection of the stream of a URL, including its
+// query, over conn.
+func upgrade(t *testing.T, conn net.Conn, streamURL, protocol string) (*bufio.Reader, *http.Response) {
+	t.Helper()
+
+	u, err := url.Parse(streamURL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL %q: %v", streamURL, err)
+	}
+
+	_, err = fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", u.RequestURI(), u.Host, protocol)
+	if err != nil {
+		t.Fatalf("Failed to write request: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+
+	return reader, resp
+}
+
+func dial(t *testing.T, network, address string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		t.Fatalf("Failed to dial %s: %v", address, err)
+	}
+
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	return lis
+}
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+
+	runtime := newRuntime(t)
+	lis := listen(t)
+	s := startServer(t, streaming.Config{Address: lis.Addr().String()}, lis)
+
+	streamURL, err := s.Register(streaming.Session{
+		Method:     streaming.MethodExec,
+		RuntimeURL: runtime.URL + "/exec/runtime-token",
+	})
+	if err != nil {
+		t.Fatalf("Failed to register session: %v", err)
+	}
+
+	if !strings.HasPrefix(streamURL, "http://"+lis.Addr().String()+"/exec/") {
+		t.Errorf("expected a URL of the streaming server, got %s", streamURL)
+	}
+
+	conn := dial(t, "tcp", lis.Addr().String())
+
+	reader, code := openStream(t, conn, streamURL)
+	if code != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status %d, got %d", http.StatusSwitchingProtocols, code)
+	}
+
+	_, err = conn.Write([]byte("ping\n"))
+	if err != nil {
+		t.Fatalf("Failed to write to stream: %v", err)
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Errorf("expected the stream to be echoed, got %q, %v", line, err)
+	}
+
+	// URLs can only be used once.
+	_, code = openStream(t, dial(t, "tcp", lis.Addr().String()), streamURL)
+	if code != http.StatusNotFound {
+		t.Errorf("expected status %d for a reused URL, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestStreamDenied(t *testing.T) {
+	t.Parallel()
+
+	runtime := newRuntime(t)
+
+	tests := []struct {
+		name    string
+		config  streaming.Config
+		session streaming.Session
+		code    int
+	}{
+		{
+			name:    "TTY",
+			session: streaming.Session{Method: streaming.MethodExec, TTY: true},
+			code:    http.StatusForbidden,
+		},
+		{
+			name:    "stdin",
+			session: streaming.Session{Method: streaming.MethodExec, Stdin: true},
+			code:    http.StatusForbidden,
+		},
+		{
+			name:    "allowed TTY and stdin",
+			config:  streaming.Config{AllowTTY: true, AllowStdin: true},
+			session: streaming.Session{Method: streaming.MethodExec, TTY: true, Stdin: true},
+			code:    http.StatusSwitchingProtocols,
+		},
+		{
+			name:    "expired token",
+			config:  streaming.Config{TokenTTL: time.Nanosecond},
+			session: streaming.Session{Method: streaming.MethodExec},
+			code:    http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			lis := listen(t)
+			tt.config.Address = lis.Addr().String()
+			s := startServer(t, tt.config, lis)
+
+			tt.session.RuntimeURL = runtime.URL + "/exec/runtime-token"
+
+			streamURL, err := s.Register(tt.session)
+			if err != nil {
+				t.Fatalf("Failed to register session: %v", err)
+			}
+
+			_, code := openStream(t, dial(t, "tcp", lis.Addr().String()), streamURL)
+			if code != tt.code {
+				t.Errorf("expected status %d, got %d", tt.code, code)
+			}
+		})
+	}
+}
+
+func TestStreamQuery(t *testing.T) {
+	t.Parallel()
+
+	runtime := newRuntime(t)
+	lis := listen(t)
+	s := startServer(t, streaming.Config{Address: lis.Addr().String()}, lis)
+
+	streamURL, err := s.Register(streaming.Session{
+		Method:     streaming.MethodExec,
+		RuntimeURL: runtime.URL + "/exec/runtime-token",
+	})
+	if err != nil {
+		t.Fatalf("Failed to register session: %v", err)
+	}
+
+	_, resp := upgrade(t, dial(t, "tcp", lis.Addr().String()), streamURL+"?container=b&cmd=sh", "SPDY/3.1")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status %d, got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+
+	// The runtime URL has no query, so the query of the caller is dropped.
+	if query := resp.Header.Get("X-Query"); query != "" {
+		t.Errorf("expected the query of the runtime, got %q", query)
+	}
+}
+
+func TestPortForwardPorts(t *testing.T) {
+	t.Parallel()
+
+	runtime := newRuntime(t)
+
+	tests := []struct {
+		name     string
+		query    string
+		protocol string
+		code     int
+	}{
+		{name: "allowed ports", query: "?port=8080&port=9090", protocol: "websocket", code: http.StatusSwitchingProtocols},
+		{name: "other port", query: "?port=8080&port=22", protocol: "websocket", code: http.StatusForbidden},
+		{name: "no ports", protocol: "websocket", code: http.StatusForbidden},
+		{name: "SPDY", query: "?port=8080", protocol: "SPDY/3.1", code: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			lis := listen(t)
+			s := startServer(t, streaming.Config{Address: lis.Addr().String()}, lis)
+
+			streamURL, err := s.Register(streaming.Session{
+				Method:     streaming.MethodPortForward,
+				RuntimeURL: runtime.URL + "/portforward/runtime-token",
+				Ports:      []int32{8080, 9090},
+			})
+			if err != nil {
+				t.Fatalf("Failed to register session: %v", err)
+			}
+
+			_, resp := upgrade(t, dial(t, "tcp", lis.Addr().String()), streamURL+tt.query, tt.protocol)
+			if resp.StatusCode != tt.code {
+				t.Fatalf("expected status %d, got %d", tt.code, resp.StatusCode)
+			}
+
+			if tt.code == http.StatusSwitchingProtocols && resp.Header.Get("X-Query") != "port=8080&port=9090" {
+				t.Errorf("expected the checked ports to be forwarded, got %q", resp.Header.Get("X-Query"))
+			}
+		})
+	}
+}
+
+func TestStreamCallerUID(t *testing.T) {
+	t.Parallel()
+
+	runtime := newRuntime(t)
+
+	socket := filepath.Join(t.TempDir(), "streaming.sock")
+
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	s := startServer(t, streaming.Config{Address: socket, BaseURL: "http://localhost"}, lis)
+
+	//nolint:gosec // UIDs fit in uint32.
+	uid := uint32(os.Getuid())
+
+	for _, tt := range []struct {
+		uid  uint32
+		code int
+	}{
+		{uid: uid, code: http.StatusSwitchingProtocols},
+		{uid: uid + 1, code: http.StatusForbidden},
+	} {
+		streamURL, err := s.Register(streaming.Session{
+			Method:     streaming.MethodExec,
+			RuntimeURL: runtime.URL + "/exec/runtime-token",
+			UID:        tt.uid,
+			HasUID:     true,
+		})
+		if err != nil {
+			t.Fatalf("Failed to register session: %v", err)
+		}
+
+		_, code := openStream(t, dial(t, "unix", socket), streamURL)
+		if code != tt.code {
+			t.Errorf("expected status %d for UID %d, got %d", tt.code, tt.uid, code)
+		}
+	}
+}
+
+func TestMaxSessionDuration(t *testing.T) {
+	t.Parallel()
+
+	runtime := newRuntime(t)
+	lis := listen(t)
+	s := startServer(t, streaming.Config{Address: lis.Addr().String(), MaxSessionDuration: 100 * time.Millisecond}, lis)
+
+	streamURL, err := s.Register(streaming.Session{
+		Method:     streaming.MethodExec,
+		RuntimeURL: runtime.URL + "/exec/runtime-token",
+	})
+	if err != nil {
+		t.Fatalf("Failed to register session: %v", err)
+	}
+
+	conn := dial(t, "tcp", lis.Addr().String())
+
+	reader, code := openStream(t, conn, streamURL)
+	if code != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status %d, got %d", http.StatusSwitchingProtocols, code)
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err != nil {
+		t.Fatalf("Failed to set deadline: %v", err)
+	}
+
+	_, err = reader.ReadByte()
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("expected the stream to be closed, got %v", err)
+	}
+}
+
+func TestNewServerInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, config := range []streaming.Config{
+		{},
+		{Address: "/run/cri-lite/streaming.sock"},
+		{Address: "127.0.0.1:10350", BaseURL: "unix:///run/cri-lite/streaming.sock"},
+		{Address: "127.0.0.1:10350", TokenTTL: -time.Second},
+	} {
+		_, err := streaming.NewServer(config)
+		if !errors.Is(err, streaming.ErrInvalidConfig) {
+			t.Errorf("expected %v for %+v, got %v", streaming.ErrInvalidConfig, config, err)
+		}
+	}
+}
+
+func TestRegisterInvalidRuntimeURL(t *testing.T) {
+	t.Parallel()
+
+	s, err := streaming.NewServer(streaming.Config{Address: "127.0.0.1:10350"})
+	if err != nil {
+		t.Fatalf("Failed to create streaming server: %v", err)
+	}
+
+	_, err = s.Register(streaming.Session{Method: streaming.MethodExec, RuntimeURL: "/exec/runtime-token"})
+	if !errors.Is(err, streaming.ErrInvalidRuntimeURL) {
+		t.Errorf("expected %v, got %v", streaming.ErrInvalidRuntimeURL, err)
+	}
+}